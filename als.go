@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+const alsModelId = "als"
+const alsFactorsBatchSize = 1000
+
+type alsParams struct {
+	factors        int
+	iterations     int
+	regularization float64
+	alpha          float64
+	seed           int64
+}
+
+var defaultALSParams = alsParams{
+	factors:        32,
+	iterations:     15,
+	regularization: 0.1,
+	alpha:          40,
+	seed:           1,
+}
+
+var errModelNotTrained = errors.New("als model has not been trained")
+
+// trainALS factorises the binary user x game review matrix with the implicit
+// feedback ALS of Hu, Koren and Volinsky: every review is a preference of 1
+// with confidence 1 + alpha, every missing review a preference of 0 with
+// confidence 1. rows holds, per user, the indexes of the games they reviewed.
+func trainALS(rows [][]int, numGames int, params alsParams) ([][]float64, [][]float64) {
+	random := rand.New(rand.NewSource(params.seed))
+	userFactors := randomFactors(len(rows), params.factors, random)
+	gameFactors := randomFactors(numGames, params.factors, random)
+
+	columns := make([][]int, numGames)
+	for user, games := range rows {
+		for _, game := range games {
+			columns[game] = append(columns[game], user)
+		}
+	}
+
+	for iteration := 1; iteration <= params.iterations; iteration++ {
+		start := time.Now()
+		alsSweep(userFactors, gameFactors, rows, params)
+		alsSweep(gameFactors, userFactors, columns, params)
+		slog.Info("ALS iteration", "stage", "train-als", "iteration", iteration, "iterations", params.iterations, "duration", time.Since(start))
+	}
+	return userFactors, gameFactors
+}
+
+func randomFactors(n int, k int, random *rand.Rand) [][]float64 {
+	factors := make([][]float64, n)
+	for i := range factors {
+		factors[i] = make([]float64, k)
+		for j := range factors[i] {
+			factors[i][j] = random.NormFloat64() * 0.01
+		}
+	}
+	return factors
+}
+
+// alsSweep solves every row of solved against the fixed factors in parallel.
+func alsSweep(solved [][]float64, fixed [][]float64, observed [][]int, params alsParams) {
+	k := params.factors
+	gramian := gramianOf(fixed, k)
+
+	rows := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := newMatrix(k)
+			b := make([]float64, k)
+			for row := range rows {
+				solved[row] = alsSolve(gramian, fixed, observed[row], params, a, b)
+			}
+		}()
+	}
+
+	for row := range solved {
+		rows <- row
+	}
+	close(rows)
+	wg.Wait()
+}
+
+// alsSolve computes (YtY + alpha * sum(y yt) + lambda * I)^-1 * (1 + alpha) * sum(y)
+// over the observed columns y of one row. a and b are scratch space.
+func alsSolve(gramian [][]float64, fixed [][]float64, observed []int, params alsParams, a [][]float64, b []float64) []float64 {
+	k := params.factors
+	for i := 0; i < k; i++ {
+		copy(a[i], gramian[i])
+		a[i][i] += params.regularization
+		b[i] = 0
+	}
+
+	for _, column := range observed {
+		y := fixed[column]
+		for i := 0; i < k; i++ {
+			b[i] += (1 + params.alpha) * y[i]
+			for j := 0; j <= i; j++ {
+				a[i][j] += params.alpha * y[i] * y[j]
+			}
+		}
+	}
+	for i := 0; i < k; i++ {
+		for j := i + 1; j < k; j++ {
+			a[i][j] = a[j][i]
+		}
+	}
+
+	return solveCholesky(a, b)
+}
+
+func gramianOf(factors [][]float64, k int) [][]float64 {
+	gramian := newMatrix(k)
+	for _, f := range factors {
+		for i := 0; i < k; i++ {
+			for j := 0; j <= i; j++ {
+				gramian[i][j] += f[i] * f[j]
+			}
+		}
+	}
+	for i := 0; i < k; i++ {
+		for j := i + 1; j < k; j++ {
+			gramian[i][j] = gramian[j][i]
+		}
+	}
+	return gramian
+}
+
+func newMatrix(k int) [][]float64 {
+	m := make([][]float64, k)
+	for i := range m {
+		m[i] = make([]float64, k)
+	}
+	return m
+}
+
+// solveCholesky solves a x = b for a symmetric positive definite a,
+// overwriting a with its Cholesky factor.
+func solveCholesky(a [][]float64, b []float64) []float64 {
+	k := len(b)
+	for j := 0; j < k; j++ {
+		sum := a[j][j]
+		for p := 0; p < j; p++ {
+			sum -= a[j][p] * a[j][p]
+		}
+		a[j][j] = math.Sqrt(math.Max(sum, 1e-12))
+		for i := j + 1; i < k; i++ {
+			sum := a[i][j]
+			for p := 0; p < j; p++ {
+				sum -= a[i][p] * a[j][p]
+			}
+			a[i][j] = sum / a[j][j]
+		}
+	}
+
+	x := make([]float64, k)
+	for i := 0; i < k; i++ {
+		sum := b[i]
+		for p := 0; p < i; p++ {
+			sum -= a[i][p] * x[p]
+		}
+		x[i] = sum / a[i][i]
+	}
+	for i := k - 1; i >= 0; i-- {
+		sum := x[i]
+		for p := i + 1; p < k; p++ {
+			sum -= a[p][i] * x[p]
+		}
+		x[i] = sum / a[i][i]
+	}
+	return x
+}
+
+func dot(a []float64, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func cosine(a []float64, b []float64) float64 {
+	norm := math.Sqrt(dot(a, a) * dot(b, b))
+	if norm == 0 {
+		return 0
+	}
+	return dot(a, b) / norm
+}
+
+// trainALSModel trains on every user-link and replaces the persisted factors
+// once the new ones are all saved.
+func trainALSModel(params alsParams) {
+	defer timeTrack(time.Now(), "trainALSModel")
+
+	slog.Info("loading user links", "stage", "train-als")
+
+	var userIds []string
+	var gameIds []int
+	var rows [][]int
+	gameIndex := make(map[int]int)
+
+	cursor := database.findAllUserLinks()
+	for cursor.Next(context.TODO()) {
+		var userLink UserLinkDTO
+		err := cursor.Decode(&userLink)
+		check(err)
+
+		var row []int
+		for _, gameId := range userLink.GamesReviewed {
+			index, ok := gameIndex[gameId]
+			if !ok {
+				index = len(gameIds)
+				gameIndex[gameId] = index
+				gameIds = append(gameIds, gameId)
+			}
+			row = append(row, index)
+		}
+		userIds = append(userIds, userLink.UserId)
+		rows = append(rows, row)
+	}
+	if len(userIds) == 0 {
+		fatal("no user-links to train on", "stage", "train-als")
+	}
+	slog.Info("training ALS", "stage", "train-als", "users", len(userIds), "games", len(gameIds))
+
+	userFactors, gameFactors := trainALS(rows, len(gameIds), params)
+
+	slog.Info("saving factors", "stage", "train-als")
+	database.clearStagedFactors()
+
+	var userBatch []UserFactorsDTO
+	for i, userId := range userIds {
+		userBatch = append(userBatch, UserFactorsDTO{UserId: userId, Factors: userFactors[i]})
+		if len(userBatch) == alsFactorsBatchSize {
+			database.saveUserFactors(userBatch)
+			userBatch = nil
+		}
+	}
+	if len(userBatch) > 0 {
+		database.saveUserFactors(userBatch)
+	}
+
+	var gameBatch []GameFactorsDTO
+	for i, gameId := range gameIds {
+		gameBatch = append(gameBatch, GameFactorsDTO{GameId: gameId, Factors: gameFactors[i]})
+		if len(gameBatch) == alsFactorsBatchSize {
+			database.saveGameFactors(gameBatch)
+			gameBatch = nil
+		}
+	}
+	if len(gameBatch) > 0 {
+		database.saveGameFactors(gameBatch)
+	}
+	database.publishFactors()
+
+	database.saveALSModel(ALSModelDTO{
+		ID:             alsModelId,
+		Factors:        params.factors,
+		Iterations:     params.iterations,
+		Regularization: params.regularization,
+		Alpha:          params.alpha,
+		Seed:           params.seed,
+		Users:          len(userIds),
+		Games:          len(gameIds),
+		TrainedAt:      time.Now(),
+	})
+}
+
+func loadGameFactors() (map[int][]float64, error) {
+	gameFactors := make(map[int][]float64)
+	for _, factors := range database.findAllGameFactors() {
+		gameFactors[factors.GameId] = factors.Factors
+	}
+	if len(gameFactors) == 0 {
+		return nil, errModelNotTrained
+	}
+	return gameFactors, nil
+}
+
+// recommendForUserALS ranks every game by the dot product of its factors with
+// the user's, explaining each pick by the reviewed games closest to it.
+func recommendForUserALS(steamId string, limit int) ([]Recommendation, error) {
+	defer timeTrack(time.Now(), "recommendForUserALS")
+
+	userLink := database.findUserLink(steamId)
+	if userLink.UserId == "" {
+		return nil, errUserNotFound
+	}
+	userFactors, ok := database.findUserFactors(steamId)
+	if !ok {
+		return nil, errModelNotTrained
+	}
+	gameFactors, err := loadGameFactors()
+	if err != nil {
+		return nil, err
+	}
+
+	recommendations := rankFactors(userFactors.Factors, userLink.GamesReviewed, gameFactors, limit)
+	nameRecommendations(recommendations)
+
+	return recommendations, nil
+}
+
+func rankFactors(userFactors []float64, reviewed []int, gameFactors map[int][]float64, limit int) []Recommendation {
+	reviewedMap := make(map[int]bool)
+	for _, gameId := range reviewed {
+		reviewedMap[gameId] = true
+	}
+
+	recommendations := []Recommendation{}
+	for gameId, factors := range gameFactors {
+		if reviewedMap[gameId] {
+			continue
+		}
+		recommendations = append(recommendations, Recommendation{GameId: gameId, Score: dot(userFactors, factors)})
+	}
+
+	sort.Slice(recommendations, func(i, j int) bool {
+		if recommendations[i].Score != recommendations[j].Score {
+			return recommendations[i].Score > recommendations[j].Score
+		}
+		return recommendations[i].GameId < recommendations[j].GameId
+	})
+	if len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+
+	for i := range recommendations {
+		var because []SeedContribution
+		for _, seed := range reviewed {
+			if seedFactors, ok := gameFactors[seed]; ok {
+				score := cosine(gameFactors[recommendations[i].GameId], seedFactors)
+				because = append(because, SeedContribution{GameId: seed, Score: score})
+			}
+		}
+		sort.Slice(because, func(a, b int) bool {
+			return because[a].Score > because[b].Score
+		})
+		if len(because) > maxExplanations {
+			because = because[:maxExplanations]
+		}
+		recommendations[i].Because = because
+	}
+	return recommendations
+}
+
+// findSimilarGamesALS ranks games by the cosine between their factors.
+func findSimilarGamesALS(gameId int, limit int) ([]GameSimilarity, error) {
+	gameFactors, err := loadGameFactors()
+	if err != nil {
+		return nil, err
+	}
+	return similarFactors(gameId, gameFactors, limit), nil
+}
+
+func similarFactors(gameId int, gameFactors map[int][]float64, limit int) []GameSimilarity {
+	seed, ok := gameFactors[gameId]
+	if !ok {
+		return []GameSimilarity{}
+	}
+
+	similarities := []GameSimilarity{}
+	for otherId, factors := range gameFactors {
+		if otherId == gameId {
+			continue
+		}
+		similarities = append(similarities, GameSimilarity{GameId: otherId, Score: cosine(seed, factors), Weighting: "als-cosine"})
+	}
+
+	sort.Slice(similarities, func(i, j int) bool {
+		if similarities[i].Score != similarities[j].Score {
+			return similarities[i].Score > similarities[j].Score
+		}
+		return similarities[i].GameId < similarities[j].GameId
+	})
+	if len(similarities) > limit {
+		similarities = similarities[:limit]
+	}
+	return similarities
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolveCholesky(t *testing.T) {
+	a := [][]float64{{4, 2, 0}, {2, 5, 1}, {0, 1, 3}}
+	want := []float64{1, -1, 2}
+	b := []float64{2, -1, 5}
+
+	x := solveCholesky(a, b)
+	for i := range want {
+		if math.Abs(x[i]-want[i]) > 1e-9 {
+			t.Fatalf("solveCholesky = %v, want %v", x, want)
+		}
+	}
+}
+
+// Two groups of users each review their own three games. The user who
+// hasn't reviewed the third game of their group gets it first.
+func TestTrainALSOnBlockDiagonalReviews(t *testing.T) {
+	rows := [][]int{
+		{0, 1},
+		{0, 1, 2}, {0, 1, 2}, {0, 1, 2},
+		{3, 4, 5}, {3, 4, 5}, {3, 4, 5}, {3, 4},
+	}
+	params := alsParams{factors: 4, iterations: 10, regularization: 0.1, alpha: 40, seed: 1}
+	userFactors, gameFactors := trainALS(rows, 6, params)
+
+	games := make(map[int][]float64)
+	for i, factors := range gameFactors {
+		games[i] = factors
+	}
+
+	for user, want := range map[int]int{0: 2, 7: 5} {
+		recommendations := rankFactors(userFactors[user], rows[user], games, 1)
+		if len(recommendations) != 1 || recommendations[0].GameId != want {
+			t.Errorf("top recommendation of user %v = %+v, want game %v", user, recommendations, want)
+		}
+	}
+
+	for game, group := range map[int][]int{0: {1, 2}, 4: {3, 5}} {
+		similar := similarFactors(game, games, 2)
+		for _, similarity := range similar {
+			if similarity.GameId != group[0] && similarity.GameId != group[1] {
+				t.Errorf("games most similar to %v = %+v, want %v", game, similar, group)
+			}
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The archive keeps every raw Steam response, gzipped, at
+//
+//	<dir>/<endpoint>/<appid>/<time>[_<cursor>].json.gz
+//
+// with the app list under the appid "all", the time in UTC and the cursor of
+// review pages in unpadded URL-safe base64. The gzip header holds the URL.
+
+const archiveTimeFormat = "20060102T150405.000000000Z"
+const archiveAllApps = "all"
+
+type archiveEntry struct {
+	path      string
+	endpoint  string
+	app       string
+	cursor    string
+	fetchedAt time.Time
+}
+
+// archivingTransport stores each successful response it passes on from next.
+type archivingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func newArchivingTransport(dir string, next http.RoundTripper) (*archivingTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &archivingTransport{dir: dir, next: next}, nil
+}
+
+func (t *archivingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	entry := archiveEntryFor(req.URL, time.Now())
+	entry.path = filepath.Join(t.dir, entry.relativePath())
+	// Losing an archived copy shouldn't lose the response
+	if err := t.write(entry, req.URL, body); err != nil {
+		slog.Warn("archiving response failed", "url", req.URL.String(), "error", err)
+	}
+	return res, nil
+}
+
+func archiveEntryFor(u *url.URL, fetchedAt time.Time) archiveEntry {
+	entry := archiveEntry{endpoint: steamEndpoint(u), app: archiveAllApps, fetchedAt: fetchedAt.UTC()}
+	switch entry.endpoint {
+	case "appreviews":
+		entry.app = strings.TrimPrefix(u.Path, "/appreviews/")
+		entry.cursor = u.Query().Get("cursor")
+	case "appdetails":
+		entry.app = u.Query().Get("appids")
+	}
+	return entry
+}
+
+func (e archiveEntry) relativePath() string {
+	name := e.fetchedAt.Format(archiveTimeFormat)
+	if e.cursor != "" {
+		name += "_" + base64.RawURLEncoding.EncodeToString([]byte(e.cursor))
+	}
+	return filepath.Join(e.endpoint, e.app, name+".json.gz")
+}
+
+func (t *archivingTransport) write(entry archiveEntry, u *url.URL, body []byte) error {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Name = u.String()
+	writer.ModTime = entry.fetchedAt
+	if _, err := writer.Write(body); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(entry.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(entry.path, compressed.Bytes(), 0644)
+}
+
+// parseArchivePath is the inverse of relativePath.
+func parseArchivePath(dir string, path string) (archiveEntry, error) {
+	relative, err := filepath.Rel(dir, path)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	parts := strings.Split(filepath.ToSlash(relative), "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], ".json.gz") {
+		return archiveEntry{}, fmt.Errorf("%v isn't an archived response", path)
+	}
+
+	entry := archiveEntry{path: path, endpoint: parts[0], app: parts[1]}
+	name := strings.TrimSuffix(parts[2], ".json.gz")
+	if i := strings.Index(name, "_"); i >= 0 {
+		cursor, err := base64.RawURLEncoding.DecodeString(name[i+1:])
+		if err != nil {
+			return archiveEntry{}, fmt.Errorf("%v has an invalid cursor: %v", path, err)
+		}
+		entry.cursor, name = string(cursor), name[:i]
+	}
+	entry.fetchedAt, err = time.Parse(archiveTimeFormat, name)
+	if err != nil {
+		return archiveEntry{}, fmt.Errorf("%v has an invalid time: %v", path, err)
+	}
+	return entry, nil
+}
+
+// archiveEntries lists the archived responses of an endpoint by app, oldest
+// first.
+func archiveEntries(dir string, endpoint string) (map[string][]archiveEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, endpoint, "*", "*.json.gz"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string][]archiveEntry)
+	for _, path := range paths {
+		entry, err := parseArchivePath(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		entries[entry.app] = append(entries[entry.app], entry)
+	}
+	for _, appEntries := range entries {
+		sort.Slice(appEntries, func(i, j int) bool {
+			return appEntries[i].fetchedAt.Before(appEntries[j].fetchedAt)
+		})
+	}
+	return entries, nil
+}
+
+func (e archiveEntry) read(value interface{}) error {
+	file, err := os.Open(e.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("reading %v: %v", e.path, err)
+	}
+	defer reader.Close()
+
+	if err := json.NewDecoder(reader).Decode(value); err != nil {
+		return fmt.Errorf("reading %v: %v", e.path, err)
+	}
+	return nil
+}
+
+// reparse updates store-entries, games and game-reviews from the latest
+// archived responses, then rebuilds user-links and game-links from
+// game-reviews when links is set. Apps are only replaced when their archive
+// is complete, so a game whose crawl was cut short, or served from the cache
+// in front of the archive, keeps the reviews already stored. It never calls
+// Steam.
+func reparse(dir string, links bool) {
+	defer timeTrack(time.Now(), "reparse")
+
+	appLists, err := archiveEntries(dir, "applist")
+	check(err)
+	details, err := archiveEntries(dir, "appdetails")
+	check(err)
+	reviews, err := archiveEntries(dir, "appreviews")
+	check(err)
+
+	lists := appLists[archiveAllApps]
+	if len(lists) == 0 {
+		fatal("no archived app list", "dir", dir)
+	}
+	var appList StoreEntriesResponse
+	check(lists[len(lists)-1].read(&appList))
+
+	names := make(map[int]string)
+	for _, entry := range appList.AppList.Apps {
+		names[entry.AppId] = entry.Name
+		database.upsertStoreEntry(entry)
+	}
+	slog.Info("reparsed app list", "stage", "reparse", "entries", len(appList.AppList.Apps), "fetchedAt", lists[len(lists)-1].fetchedAt)
+
+	progress := newProgressTracker("reparse", len(details)+len(reviews))
+	for _, app := range sortedArchiveApps(details) {
+		appEntries := details[app]
+		latest := appEntries[len(appEntries)-1]
+		var response EntryDetailsResponse
+		if err := latest.read(&response); err != nil {
+			slog.Error("skipping app details", "stage", "reparse", "appid", app, "error", err)
+			progress.failed()
+			continue
+		}
+
+		id, _ := strconv.Atoi(app)
+		data := response[app].Data
+		if data.Type == "game" {
+			game := StoreEntryDTO{ID: id, Name: names[id]}
+			if game.Name == "" {
+				game.Name = data.Name
+			}
+			for _, genre := range data.Genres {
+				game.Genres = append(game.Genres, genre.Description)
+			}
+			database.upsertGameDetails(game)
+		}
+		progress.processed()
+	}
+
+	for _, app := range sortedArchiveApps(reviews) {
+		id, _ := strconv.Atoi(app)
+		review, err := reviewsFromArchive(id, reviews[app])
+		if err != nil {
+			slog.Error("keeping stored reviews", "stage", "reparse", "appid", app, "error", err)
+			progress.failed()
+			continue
+		}
+		if len(review.Users) > minSavedReviewers {
+			slog.Info("saving reviews", "stage", "reparse", "appid", review.AppId, "users", len(review.Users))
+			database.replaceGameReview(review)
+		}
+		progress.processed()
+	}
+	progress.finish()
+	database.touchCollection(gamesCollectionName)
+	database.touchCollection(gameReviewsCollection)
+
+	if links {
+		// Both are derived from game-reviews alone, and user-links are
+		// appended to, so they are rebuilt from scratch
+		slog.Info("dropping derived collections", "stage", "reparse", "collections", []string{userLinksCollection, gameLinksCollection})
+		database.dropCollections(userLinksCollection, gameLinksCollection)
+		processUserLinks()
+		populateGameSimilarities()
+	}
+}
+
+// sortedArchiveApps orders app ids numerically so games are saved in the
+// order the crawler saves them.
+func sortedArchiveApps(entries map[string][]archiveEntry) []string {
+	var apps []string
+	for app := range entries {
+		apps = append(apps, app)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		a, _ := strconv.Atoi(apps[i])
+		b, _ := strconv.Atoi(apps[j])
+		return a < b
+	})
+	return apps
+}
+
+// reviewsFromArchive replays the latest complete crawl of a game: a page
+// fetched with the cursor "*" and the pages after it, following cursors the
+// way getReviews does. A crawl that never reached its last page falls back to
+// the one before it, and it is an error when no crawl is complete.
+func reviewsFromArchive(gameId int, entries []archiveEntry) (GameReviewDTO, error) {
+	var latestErr error
+	for start := len(entries) - 1; start >= 0; start-- {
+		if entries[start].cursor != "*" {
+			continue
+		}
+		review, err := reviewsFromCrawl(gameId, entries[start:])
+		if err == nil {
+			if latestErr != nil {
+				slog.Warn("latest crawl incomplete, using an earlier one", "stage", "reparse", "appid", gameId,
+					"error", latestErr, "fetchedAt", entries[start].fetchedAt)
+			}
+			return review, nil
+		}
+		if latestErr == nil {
+			latestErr = err
+		}
+	}
+	if latestErr == nil {
+		return GameReviewDTO{}, fmt.Errorf("no first page archived")
+	}
+	return GameReviewDTO{}, latestErr
+}
+
+// reviewsFromCrawl follows the cursors from the first page of entries,
+// taking the earliest page archived for each cursor.
+func reviewsFromCrawl(gameId int, entries []archiveEntry) (GameReviewDTO, error) {
+	pages := make(map[string]archiveEntry)
+	for _, entry := range entries {
+		if _, ok := pages[entry.cursor]; !ok {
+			pages[entry.cursor] = entry
+		}
+	}
+
+	review := GameReviewDTO{AppId: gameId}
+	var page GameResponse
+	if err := pages["*"].read(&page); err != nil {
+		return GameReviewDTO{}, err
+	}
+	if page.QuerySummary.TotalReviews < minReviewCount {
+		return review, nil
+	}
+	review = appendReviews(page, review)
+
+	seen := map[string]bool{"*": true}
+	for !seen[page.Cursor] {
+		seen[page.Cursor] = true
+		entry, ok := pages[page.Cursor]
+		if !ok {
+			return GameReviewDTO{}, fmt.Errorf("page with cursor %q not archived", page.Cursor)
+		}
+		page = GameResponse{}
+		if err := entry.read(&page); err != nil {
+			return GameReviewDTO{}, err
+		}
+		review = appendReviews(page, review)
+	}
+	return review, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestArchivePathRoundTrips(t *testing.T) {
+	fetchedAt := time.Date(2020, 5, 17, 9, 30, 1, 123456789, time.UTC)
+	tests := []struct {
+		rawUrl string
+		want   archiveEntry
+	}{
+		{"https://store.steampowered.com/appreviews/440?cursor=AoJ4%2Babc%2F1%3D&json=1",
+			archiveEntry{endpoint: "appreviews", app: "440", cursor: "AoJ4+abc/1=", fetchedAt: fetchedAt}},
+		{"https://store.steampowered.com/appreviews/440?cursor=*&json=1",
+			archiveEntry{endpoint: "appreviews", app: "440", cursor: "*", fetchedAt: fetchedAt}},
+		{"https://store.steampowered.com/api/appdetails?appids=10",
+			archiveEntry{endpoint: "appdetails", app: "10", fetchedAt: fetchedAt}},
+		{"http://api.steampowered.com/ISteamApps/GetAppList/v0002/?key=STEAMKEY&format=json",
+			archiveEntry{endpoint: "applist", app: archiveAllApps, fetchedAt: fetchedAt}},
+	}
+	for _, test := range tests {
+		entry := archiveEntryFor(mustParseUrl(t, test.rawUrl), fetchedAt)
+		entry.path = "archive/" + entry.relativePath()
+		test.want.path = entry.path
+
+		parsed, err := parseArchivePath("archive", entry.path)
+		if err != nil {
+			t.Errorf("parseArchivePath(%v) failed: %v", entry.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, test.want) {
+			t.Errorf("archive entry of %v = %+v, want %+v", test.rawUrl, parsed, test.want)
+		}
+	}
+}
+
+func TestReviewsFromArchiveMatchCrawl(t *testing.T) {
+	fake := useFakeSteam(t, testFakeSteamParams)
+
+	dir := t.TempDir()
+	archive, err := newArchivingTransport(dir, steamClient.Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steamClient = &http.Client{Transport: archive}
+
+	entries := fetchStoreEntries()
+	crawled := make(map[int]GameReviewDTO)
+	for _, app := range fake.apps {
+		if _, err := getStoreEntryDetails(app.id); err != nil {
+			t.Fatal(err)
+		}
+		if app.kind != "game" {
+			continue
+		}
+		// Crawled twice, so the archive has to pick out the latest crawl
+		if _, err := getReviews(app.id); err != nil {
+			t.Fatal(err)
+		}
+		review, err := getReviews(app.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crawled[app.id] = review
+	}
+
+	appLists, err := archiveEntries(dir, "applist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var appList StoreEntriesResponse
+	if err := appLists[archiveAllApps][0].read(&appList); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(appList.AppList.Apps, entries) {
+		t.Errorf("archived app list = %+v, want %+v", appList.AppList.Apps, entries)
+	}
+
+	details, err := archiveEntries(dir, "appdetails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details) != len(fake.apps) {
+		t.Errorf("archived details of %v apps, want %v", len(details), len(fake.apps))
+	}
+
+	reviews, err := archiveEntries(dir, "appreviews")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range crawled {
+		app := strconv.Itoa(id)
+		review, err := reviewsFromArchive(id, reviews[app])
+		if err != nil {
+			t.Errorf("reviewsFromArchive(%v) failed: %v", id, err)
+			continue
+		}
+		if !reflect.DeepEqual(review, want) {
+			t.Errorf("reviewsFromArchive(%v) has %v users, want the %v crawled", id, len(review.Users), len(want.Users))
+		}
+
+		// Without its last page the latest crawl falls back to the first
+		appEntries := reviews[app]
+		crawl := len(appEntries) / 2
+		if err := os.Remove(appEntries[len(appEntries)-1].path); err != nil {
+			t.Fatal(err)
+		}
+		appEntries = appEntries[:len(appEntries)-1]
+		review, err = reviewsFromArchive(id, appEntries)
+		if err != nil {
+			t.Errorf("reviewsFromArchive(%v) with an unfinished latest crawl failed: %v", id, err)
+		} else if !reflect.DeepEqual(review, want) {
+			t.Errorf("reviewsFromArchive(%v) with an unfinished latest crawl has %v users, want %v", id, len(review.Users), len(want.Users))
+		}
+
+		// and with neither crawl finished there's nothing to rebuild from
+		if err := os.Remove(appEntries[crawl-1].path); err != nil {
+			t.Fatal(err)
+		}
+		appEntries = append(appEntries[:crawl-1:crawl-1], appEntries[crawl:]...)
+		if _, err := reviewsFromArchive(id, appEntries); err == nil {
+			t.Errorf("reviewsFromArchive(%v) without a finished crawl succeeded, want an error", id)
+		}
+	}
+}
+
+// TestReparseKeepsReviewsOfUnfinishedCrawls needs DATABASE_URL to point at
+// MongoDB.
+func TestReparseKeepsReviewsOfUnfinishedCrawls(t *testing.T) {
+	useTestDatabase(t)
+	fake := useFakeSteam(t, testFakeSteamParams)
+
+	dir := t.TempDir()
+	archive, err := newArchivingTransport(dir, steamClient.Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steamClient = &http.Client{Transport: archive}
+
+	initStoreEntries()
+	filterGames()
+	processReviews()
+	games := database.countDocuments(gameReviewsCollection)
+
+	var gameId int
+	for _, app := range fake.apps {
+		if app.kind == "game" {
+			gameId = app.id
+			break
+		}
+	}
+	stored := database.findGameReview(gameId)
+
+	reviews, err := archiveEntries(dir, "appreviews")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appEntries := reviews[strconv.Itoa(gameId)]
+	if err := os.Remove(appEntries[len(appEntries)-1].path); err != nil {
+		t.Fatal(err)
+	}
+
+	reparse(dir, true)
+	if n := database.countDocuments(gameReviewsCollection); n != games {
+		t.Errorf("reparse left %v games with reviews, want %v", n, games)
+	}
+	if review := database.findGameReview(gameId); !reflect.DeepEqual(review, stored) {
+		t.Errorf("reparse changed the reviews of %v from %v to %v users", gameId, len(stored.Users), len(review.Users))
+	}
+	if n := database.countDocuments(gameLinksCollection); n != games {
+		t.Errorf("reparse rebuilt %v game-links, want %v", n, games)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How long a cached response is used, per endpoint. Zero never expires.
+var defaultCacheTTLs = map[string]time.Duration{
+	"applist":    24 * time.Hour,
+	"appdetails": 30 * 24 * time.Hour,
+	"appreviews": 7 * 24 * time.Hour,
+}
+
+// cacheHeader is set on responses served from the cache.
+const cacheHeader = "X-Steam-Cache"
+
+// errNotCached fails offline requests without a cached response.
+var errNotCached = errors.New("offline and not cached")
+
+type cachedResponse struct {
+	URL       string              `json:"url"`
+	Status    int                 `json:"status"`
+	Header    map[string][]string `json:"header,omitempty"`
+	Body      []byte              `json:"body"`
+	FetchedAt time.Time           `json:"fetchedAt"`
+}
+
+// cachingTransport keeps successful Steam responses on disk, under the hash
+// of their URL. Offline, a request that isn't cached fails instead of going
+// to next, and expired responses are still served.
+type cachingTransport struct {
+	dir     string
+	next    http.RoundTripper
+	ttls    map[string]time.Duration
+	offline bool
+}
+
+func newCachingTransport(dir string, next http.RoundTripper, ttls map[string]time.Duration, offline bool) (*cachingTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &cachingTransport{dir: dir, next: next, ttls: ttls, offline: offline}, nil
+}
+
+// steamEndpoint names the Steam endpoint of a URL, as labelled in metrics.
+func steamEndpoint(u *url.URL) string {
+	switch {
+	case strings.HasPrefix(u.Path, "/appreviews/"):
+		return "appreviews"
+	case strings.HasSuffix(u.Path, "/appdetails"):
+		return "appdetails"
+	case strings.Contains(u.Path, "/GetAppList/"):
+		return "applist"
+	}
+	return "other"
+}
+
+func (t *cachingTransport) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(t.dir, name[:2], name+".json")
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+
+	endpoint := steamEndpoint(req.URL)
+	key := fixtureKey(req.Method, req.URL)
+	path := t.path(key)
+
+	cached, err := readCachedResponse(path)
+	switch {
+	case err == nil && (t.offline || !t.expired(endpoint, cached)):
+		steamCacheRequests.inc(endpoint, "hit")
+		return cached.response(req), nil
+	case err == nil:
+		steamCacheRequests.inc(endpoint, "expired")
+	case !os.IsNotExist(err):
+		slog.Warn("unreadable cache entry", "path", path, "error", err)
+		fallthrough
+	default:
+		steamCacheRequests.inc(endpoint, "miss")
+	}
+
+	if t.offline {
+		return nil, fmt.Errorf("%w: %v", errNotCached, req.URL)
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	entry := cachedResponse{
+		URL:       req.URL.String(),
+		Status:    res.StatusCode,
+		Header:    map[string][]string{},
+		Body:      body,
+		FetchedAt: time.Now(),
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType != "" {
+		entry.Header["Content-Type"] = []string{contentType}
+	}
+	// A response we can't cache is still a response
+	if err := writeCachedResponse(path, entry); err != nil {
+		slog.Warn("caching response failed", "url", req.URL.String(), "error", err)
+	}
+	return res, nil
+}
+
+func (t *cachingTransport) expired(endpoint string, cached cachedResponse) bool {
+	ttl := t.ttls[endpoint]
+	return ttl > 0 && time.Since(cached.FetchedAt) > ttl
+}
+
+func readCachedResponse(path string) (cachedResponse, error) {
+	var cached cachedResponse
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cached, err
+	}
+	err = json.Unmarshal(data, &cached)
+	return cached, err
+}
+
+// writeCachedResponse writes through a temporary file so that concurrent
+// readers never see half an entry.
+func writeCachedResponse(path string, entry cachedResponse) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+func (c cachedResponse) response(req *http.Request) *http.Response {
+	header := http.Header{}
+	for name, values := range c.Header {
+		header[http.CanonicalHeaderKey(name)] = values
+	}
+	header.Set(cacheHeader, "hit")
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.Status, http.StatusText(c.Status)),
+		StatusCode:    c.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// servedFromCache tells the crawler it can skip the wait that keeps it
+// under the Steam rate limit.
+func servedFromCache(res *http.Response) bool {
+	return res.Header.Get(cacheHeader) == "hit"
+}
+
+// parseCacheTTLs reads endpoint=duration pairs, such as
+// appdetails=720h,appreviews=0, over the defaults.
+func parseCacheTTLs(value string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+	for endpoint, ttl := range defaultCacheTTLs {
+		ttls[endpoint] = ttl
+	}
+	if value == "" {
+		return ttls, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("cache TTL %q isn't endpoint=duration", pair)
+		}
+		if _, ok := defaultCacheTTLs[parts[0]]; !ok {
+			return nil, fmt.Errorf("unknown endpoint %q, want applist, appdetails or appreviews", parts[0])
+		}
+		ttl, err := time.ParseDuration(parts[1])
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("cache TTL of %v must be a non negative duration", parts[0])
+		}
+		ttls[parts[0]] = ttl
+	}
+	return ttls, nil
+}
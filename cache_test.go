@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCachingTransport(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("appids") == "20" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"request":%v}`, requests)
+	}))
+	defer server.Close()
+
+	served, rateLimited := seriesValue(steamRequests, "appdetails", "200"), seriesValue(steamRequests, "appdetails", "429")
+
+	dir := t.TempDir()
+	ttls := map[string]time.Duration{"appdetails": time.Hour}
+	cache, err := newCachingTransport(dir, meteredTransport{http.DefaultTransport}, ttls, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: cache}
+
+	get := func(client *http.Client, appids string) (string, bool) {
+		t.Helper()
+		res, err := client.Get(server.URL + "/api/appdetails?appids=" + appids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body), servedFromCache(res)
+	}
+
+	if body, cached := get(client, "10"); body != `{"request":1}` || cached {
+		t.Errorf("first request = %q, cached %v, want the response from the server", body, cached)
+	}
+	if body, cached := get(client, "10"); body != `{"request":1}` || !cached {
+		t.Errorf("second request = %q, cached %v, want the cached response", body, cached)
+	}
+
+	// Rate limited responses aren't cached
+	get(client, "20")
+	get(client, "20")
+	if requests != 3 {
+		t.Errorf("server got %v requests, want 3", requests)
+	}
+	// Only the requests that reached the server are counted
+	if n := seriesValue(steamRequests, "appdetails", "200") - served; n != 1 {
+		t.Errorf("counted %v successful steam requests, want 1", n)
+	}
+	if n := seriesValue(steamRequests, "appdetails", "429") - rateLimited; n != 2 {
+		t.Errorf("counted %v rate limited steam requests, want 2", n)
+	}
+
+	// Past the TTL the response is fetched again
+	path := cache.path(fixtureKey(http.MethodGet, mustParseUrl(t, server.URL+"/api/appdetails?appids=10")))
+	entry, err := readCachedResponse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.FetchedAt = time.Now().Add(-2 * time.Hour)
+	if err := writeCachedResponse(path, entry); err != nil {
+		t.Fatal(err)
+	}
+	if body, cached := get(client, "10"); body != `{"request":4}` || cached {
+		t.Errorf("expired request = %q, cached %v, want a new response from the server", body, cached)
+	}
+
+	// Offline, expired responses are served and misses fail
+	entry.FetchedAt = time.Now().Add(-2 * time.Hour)
+	if err := writeCachedResponse(path, entry); err != nil {
+		t.Fatal(err)
+	}
+	offline, err := newCachingTransport(dir, http.DefaultTransport, ttls, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offlineClient := &http.Client{Transport: offline}
+	if body, cached := get(offlineClient, "10"); body != `{"request":1}` || !cached {
+		t.Errorf("offline request = %q, cached %v, want the expired cached response", body, cached)
+	}
+	if _, err := offlineClient.Get(server.URL + "/api/appdetails?appids=30"); !errors.Is(err, errNotCached) {
+		t.Errorf("offline request that isn't cached = %v, want errNotCached", err)
+	}
+	if requests != 4 {
+		t.Errorf("server got %v requests, want 4", requests)
+	}
+}
+
+func TestSteamEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"https://store.steampowered.com/appreviews/440?json=1":                      "appreviews",
+		"https://store.steampowered.com/api/appdetails?appids=440":                  "appdetails",
+		"http://api.steampowered.com/ISteamApps/GetAppList/v0002/?key=STEAMKEY":     "applist",
+		"http://localhost:8090/ISteamApps/GetAppList/v0002/?key=STEAMKEY&format=js": "applist",
+		"https://store.steampowered.com/other":                                      "other",
+	}
+	for rawUrl, want := range tests {
+		if got := steamEndpoint(mustParseUrl(t, rawUrl)); got != want {
+			t.Errorf("steamEndpoint(%v) = %v, want %v", rawUrl, got, want)
+		}
+	}
+}
+
+func TestParseCacheTTLs(t *testing.T) {
+	ttls, err := parseCacheTTLs("appdetails=1h, appreviews=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttls["appdetails"] != time.Hour || ttls["appreviews"] != 0 || ttls["applist"] != defaultCacheTTLs["applist"] {
+		t.Errorf("parseCacheTTLs = %v, want appdetails 1h, appreviews 0 and the default for applist", ttls)
+	}
+
+	for _, value := range []string{"appdetails", "search=1h", "appdetails=soon", "appdetails=-1h"} {
+		if _, err := parseCacheTTLs(value); err == nil {
+			t.Errorf("parseCacheTTLs(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func mustParseUrl(t *testing.T, rawUrl string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func seriesValue(family *metricFamily, labelValues ...string) float64 {
+	family.mu.Lock()
+	defer family.mu.Unlock()
+	return family.with(labelValues).value
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"container/heap"
+	"log/slog"
+	"math"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const pageRankDamping = 0.85
+const pageRankMaxIterations = 100
+const pageRankTolerance = 1e-9
+
+var centralityFields = []string{"pagerank", "weightedDegree", "betweenness", "clustering"}
+
+type centralityParams struct {
+	topK      int
+	metric    string
+	minWeight float64
+	samples   int
+	seed      int64
+}
+
+var defaultCentralityParams = centralityParams{
+	topK:      20,
+	metric:    "cosine",
+	minWeight: 0,
+	samples:   256,
+	seed:      1,
+}
+
+func isCentralityField(field string) bool {
+	for _, f := range centralityFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// computeCentrality scores every game of the similarity graph and stores
+// the scores on the game.
+func computeCentrality(params centralityParams) {
+	defer timeTrack(time.Now(), "computeCentrality")
+
+	graph := buildSimilarityGraph(params.topK, params.metric, params.minWeight)
+
+	pageRanks := pageRank(graph.adjacency)
+	betweenness := sampledBetweenness(graph.adjacency, params.samples, rand.New(rand.NewSource(params.seed)))
+	clustering := clusteringCoefficients(graph.adjacency)
+
+	fields := make(map[int]bson.M)
+	for i, gameId := range graph.ids {
+		fields[gameId] = bson.M{"centrality": CentralityDTO{
+			PageRank:       pageRanks[i],
+			WeightedDegree: weightedDegree(graph.adjacency[i]),
+			Betweenness:    betweenness[i],
+			Clustering:     clustering[i],
+		}}
+	}
+
+	slog.Info("saving centrality", "stage", "centrality", "games", len(fields))
+	database.setGameFields(fields)
+	database.ensureCentralityIndexes()
+}
+
+func weightedDegree(neighbors map[int]float64) float64 {
+	var degree float64
+	for _, w := range neighbors {
+		degree += w
+	}
+	return degree
+}
+
+// pageRank follows each edge in proportion to its weight. Games without
+// edges spread their rank evenly.
+func pageRank(adjacency []map[int]float64) []float64 {
+	n := len(adjacency)
+	if n == 0 {
+		return nil
+	}
+
+	degrees := make([]float64, n)
+	for i, neighbors := range adjacency {
+		degrees[i] = weightedDegree(neighbors)
+	}
+
+	ranks := make([]float64, n)
+	for i := range ranks {
+		ranks[i] = 1 / float64(n)
+	}
+
+	for iteration := 0; iteration < pageRankMaxIterations; iteration++ {
+		next := make([]float64, n)
+		var dangling float64
+		for i, neighbors := range adjacency {
+			if degrees[i] == 0 {
+				dangling += ranks[i]
+				continue
+			}
+			for j, w := range neighbors {
+				next[j] += pageRankDamping * ranks[i] * w / degrees[i]
+			}
+		}
+
+		base := (1-pageRankDamping)/float64(n) + pageRankDamping*dangling/float64(n)
+		var change float64
+		for i := range next {
+			next[i] += base
+			change += math.Abs(next[i] - ranks[i])
+		}
+		ranks = next
+
+		if change < pageRankTolerance {
+			break
+		}
+	}
+	return ranks
+}
+
+// sampledBetweenness estimates normalised betweenness with Brandes'
+// algorithm from a random sample of sources. Strong links are short:
+// an edge of weight w has length 1/w.
+func sampledBetweenness(adjacency []map[int]float64, samples int, random *rand.Rand) []float64 {
+	n := len(adjacency)
+	betweenness := make([]float64, n)
+	if n < 3 {
+		return betweenness
+	}
+
+	sources := random.Perm(n)
+	if samples < n {
+		sources = sources[:samples]
+	}
+
+	distance := make([]float64, n)
+	paths := make([]float64, n)
+	dependency := make([]float64, n)
+	predecessors := make([][]int, n)
+
+	for _, source := range sources {
+		for i := range distance {
+			distance[i] = math.Inf(1)
+			paths[i] = 0
+			dependency[i] = 0
+			predecessors[i] = predecessors[i][:0]
+		}
+		distance[source] = 0
+		paths[source] = 1
+
+		var stack []int
+		queue := &distanceQueue{{node: source}}
+		for queue.Len() > 0 {
+			item := heap.Pop(queue).(distanceItem)
+			if item.distance > distance[item.node] {
+				continue
+			}
+			stack = append(stack, item.node)
+
+			for j, w := range adjacency[item.node] {
+				d := distance[item.node] + 1/w
+				switch {
+				case d < distance[j]-1e-12:
+					distance[j] = d
+					paths[j] = paths[item.node]
+					predecessors[j] = append(predecessors[j][:0], item.node)
+					heap.Push(queue, distanceItem{node: j, distance: d})
+				case math.Abs(d-distance[j]) <= 1e-12:
+					paths[j] += paths[item.node]
+					predecessors[j] = append(predecessors[j], item.node)
+				}
+			}
+		}
+
+		for k := len(stack) - 1; k >= 0; k-- {
+			w := stack[k]
+			for _, v := range predecessors[w] {
+				dependency[v] += paths[v] / paths[w] * (1 + dependency[w])
+			}
+			if w != source {
+				betweenness[w] += dependency[w]
+			}
+		}
+	}
+
+	// Every pair is seen from both ends in an undirected graph.
+	scale := float64(n) / float64(len(sources)) / 2 / (float64(n-1) * float64(n-2) / 2)
+	for i := range betweenness {
+		betweenness[i] *= scale
+	}
+	return betweenness
+}
+
+// clusteringCoefficients is the share of each game's pairs of neighbours
+// that are linked themselves.
+func clusteringCoefficients(adjacency []map[int]float64) []float64 {
+	coefficients := make([]float64, len(adjacency))
+	for i, neighbors := range adjacency {
+		degree := len(neighbors)
+		if degree < 2 {
+			continue
+		}
+
+		var triangles int
+		for a := range neighbors {
+			for b := range neighbors {
+				if a < b {
+					if _, ok := adjacency[a][b]; ok {
+						triangles++
+					}
+				}
+			}
+		}
+		coefficients[i] = float64(triangles) / (float64(degree) * float64(degree-1) / 2)
+	}
+	return coefficients
+}
+
+type distanceItem struct {
+	node     int
+	distance float64
+}
+
+type distanceQueue []distanceItem
+
+func (q distanceQueue) Len() int            { return len(q) }
+func (q distanceQueue) Less(i, j int) bool  { return q[i].distance < q[j].distance }
+func (q distanceQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *distanceQueue) Push(x interface{}) { *q = append(*q, x.(distanceItem)) }
+func (q *distanceQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestCentralityOfAStar(t *testing.T) {
+	graph := testSimilarityGraph([]testEdge{{10, 11, 1}, {10, 12, 1}, {10, 13, 1}, {10, 14, 1}})
+	center := graph.index[10]
+
+	// c = 0.15/5 + 0.85 * 4l and l = 0.15/5 + 0.85 * c/4
+	wantCenter := 0.132 / 0.2775
+	wantLeaf := (1 - wantCenter) / 4
+	ranks := pageRank(graph.adjacency)
+	for i, rank := range ranks {
+		want := wantLeaf
+		if i == center {
+			want = wantCenter
+		}
+		if math.Abs(rank-want) > 1e-6 {
+			t.Errorf("pagerank of %v = %v, want %v", graph.ids[i], rank, want)
+		}
+	}
+
+	// Every path between two leaves goes through the center
+	betweenness := sampledBetweenness(graph.adjacency, len(graph.ids), rand.New(rand.NewSource(1)))
+	for i, b := range betweenness {
+		want := 0.0
+		if i == center {
+			want = 1
+		}
+		if math.Abs(b-want) > 1e-9 {
+			t.Errorf("betweenness of %v = %v, want %v", graph.ids[i], b, want)
+		}
+	}
+
+	if degree := weightedDegree(graph.adjacency[center]); degree != 4 {
+		t.Errorf("weighted degree of the center = %v, want 4", degree)
+	}
+}
+
+// Betweenness follows strong links, which are short, around a weak one.
+func TestBetweennessPrefersStrongLinks(t *testing.T) {
+	graph := testSimilarityGraph([]testEdge{{1, 2, 0.1}, {1, 3, 1}, {3, 2, 1}})
+	betweenness := sampledBetweenness(graph.adjacency, 3, rand.New(rand.NewSource(1)))
+	if b := betweenness[graph.index[3]]; math.Abs(b-1) > 1e-9 {
+		t.Errorf("betweenness of the game between two strong links = %v, want 1", b)
+	}
+}
+
+func TestClusteringCoefficients(t *testing.T) {
+	graph := testSimilarityGraph([]testEdge{{1, 2, 1}, {2, 3, 1}, {3, 1, 1}, {1, 4, 1}})
+	coefficients := clusteringCoefficients(graph.adjacency)
+
+	want := map[int]float64{1: 1.0 / 3, 2: 1, 3: 1, 4: 0}
+	for gameId, c := range want {
+		if got := coefficients[graph.index[gameId]]; math.Abs(got-c) > 1e-12 {
+			t.Errorf("clustering of %v = %v, want %v", gameId, got, c)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string)
+}
+
+var commands = []command{
+	{"store-entries", "fetch the steam app list into store-entries", runStoreEntries},
+	{"filter-games", "keep the store entries that are games", runFilterGames},
+	{"reviews", "crawl reviewers for every game", runReviews},
+	{"user-links", "build user-links from game-reviews", runUserLinks},
+	{"similarities", "build game-links from user-links", runSimilarities},
+	{"graph", "generate the graph around a game", runGraph},
+	{"recommend", "recommend games for a steam user", runRecommend},
+	{"train-als", "train the ALS model on user-links", runTrainALS},
+	{"similar", "list the games most similar to a game", runSimilar},
+	{"evaluate", "measure recommendation quality on held out reviews", runEvaluate},
+	{"minhash", "estimate game-links with MinHash/LSH", runMinHash},
+	{"communities", "detect communities over all game-links", runCommunities},
+	{"centrality", "compute centrality metrics for every game", runCentrality},
+	{"top", "list games sorted by a centrality metric", runTop},
+	{"path", "find the strongest chain of similar games between two games", runPath},
+	{"serve", "serve the HTTP API", runServe},
+	{"search", "find games by name", runSearch},
+	{"status", "show the progress of the pipeline stages", runStatus},
+	{"fake-steam", "serve synthetic Steam endpoints for offline runs", runFakeSteam},
+	{"synthesize", "write a synthetic dataset with planted communities", runSynthesize},
+	{"reparse", "rebuild collections from archived Steam responses", runReparse},
+	{"export", "export collections to CSV, JSONL or Parquet", runExport},
+	{"neo4j-export", "export the game-user graph for neo4j-admin import and LOAD CSV", runNeo4jExport},
+	{"validate-synthetic", "score game-links and communities against the planted communities", runValidateSynthetic},
+}
+
+// Commands that run without connecting to the database
+var offlineCommands = map[string]bool{
+	"fake-steam": true,
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: steam-scraper <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.usage)
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ExitOnError)
+}
+
+func runStoreEntries(args []string) {
+	flags := newFlagSet("store-entries")
+	metricsAddr := metricsAddrFlag(flags)
+	transport := addSteamTransportFlags(flags)
+	flags.Parse(args)
+
+	transport.apply()
+	startMetrics(*metricsAddr)
+	initStoreEntries()
+}
+
+func runFilterGames(args []string) {
+	flags := newFlagSet("filter-games")
+	metricsAddr := metricsAddrFlag(flags)
+	transport := addSteamTransportFlags(flags)
+	backfill := flags.Bool("backfill-genres", false, "instead of filtering new entries, fetch the genres of games saved without them")
+	flags.Parse(args)
+
+	transport.apply()
+	startMetrics(*metricsAddr)
+	if *backfill {
+		backfillGenres()
+		return
+	}
+	filterGames()
+}
+
+func runReviews(args []string) {
+	flags := newFlagSet("reviews")
+	metricsAddr := metricsAddrFlag(flags)
+	transport := addSteamTransportFlags(flags)
+	flags.DurationVar(&reviewPageDelay, "page-delay", reviewPageDelay, "wait between pages of reviews")
+	flags.Parse(args)
+
+	transport.apply()
+	startMetrics(*metricsAddr)
+	processReviews()
+}
+
+func runUserLinks(args []string) {
+	flags := newFlagSet("user-links")
+	metricsAddr := metricsAddrFlag(flags)
+	flags.Parse(args)
+
+	startMetrics(*metricsAddr)
+	processUserLinks()
+}
+
+func runSimilarities(args []string) {
+	flags := newFlagSet("similarities")
+	weightingName := flags.String("weighting", "none", "co-occurrence weighting: none or playtime")
+	capHours := flags.Float64("playtime-cap", defaultPlaytimeCapHours, "hours of playtime at which a review gets full weight")
+	metricsAddr := metricsAddrFlag(flags)
+	flags.Parse(args)
+
+	setWeighting(*weightingName, *capHours)
+
+	startMetrics(*metricsAddr)
+	populateGameSimilarities()
+}
+
+func setWeighting(name string, capHours float64) {
+	switch name {
+	case "none":
+		weighting = playtimeWeighting{}
+	case "playtime":
+		if capHours <= 0 {
+			fatal("playtime-cap must be positive")
+		}
+		weighting = playtimeWeighting{enabled: true, capHours: capHours}
+	default:
+		fatal("unknown weighting", "weighting", name)
+	}
+	slog.Info("using weighting", "weighting", weighting.name())
+}
+
+func runGraph(args []string) {
+	flags := newFlagSet("graph")
+	gameId := flags.Int("appid", 892970, "app id of the seed game")
+	params := defaultGraphParams
+	flags.IntVar(&params.depth, "depth", params.depth, "number of hops from the seed game, 1 to 3")
+	fanout := flags.String("fanout", "20", "comma separated neighbours followed per node at each hop")
+	flags.IntVar(&params.linkNeighbors, "link-neighbors", params.linkNeighbors, "game-links searched for edges between nodes")
+	format := flags.String("format", "json", "output format: "+strings.Join(graphFormats(), ", "))
+	out := flags.String("out", "", "output path, test.json or graph.<format> by default")
+	refresh := flags.Bool("refresh", false, "regenerate even if a stored graph is up to date")
+	flags.Parse(args)
+
+	if params.depth < 1 || params.depth > maxGraphDepth {
+		fatal("depth must be between 1 and the maximum", "depth", params.depth, "max", maxGraphDepth)
+	}
+	params.fanout = parseFanout(*fanout)
+
+	writer, ok := graphWriters[*format]
+	if !ok {
+		fatal("unknown format", "format", *format)
+	}
+	if *out == "" {
+		*out = "graph." + writer.extension()
+		if *format == "json" {
+			*out = "test.json"
+		}
+	}
+
+	generateGraph(*gameId, params, *refresh, writer, *out)
+}
+
+func parseFanout(value string) []int {
+	var fanout []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			fatal("invalid fanout", "fanout", value)
+		}
+		fanout = append(fanout, n)
+	}
+	return fanout
+}
+
+func runRecommend(args []string) {
+	flags := newFlagSet("recommend")
+	steamId := flags.String("steamid", "", "steam id of the user")
+	limit := flags.Int("limit", defaultRecommendationLimit, "number of games to recommend")
+	neighbors := flags.Int("neighbors", defaultNeighborsPerSeed, "similar games considered per reviewed game")
+	algorithm := flags.String("algorithm", "itemcf", "itemcf or als")
+	flags.Parse(args)
+
+	if *steamId == "" {
+		fatal("steamid is required")
+	}
+	if *limit < 1 || *neighbors < 1 {
+		fatal("limit and neighbors must be at least 1", "limit", *limit, "neighbors", *neighbors)
+	}
+
+	var recommendations []Recommendation
+	var err error
+
+	switch *algorithm {
+	case "itemcf":
+		recommendations, err = recommendForUser(*steamId, *limit, *neighbors)
+	case "als":
+		recommendations, err = recommendForUserALS(*steamId, *limit)
+	default:
+		fatal("unknown algorithm", "algorithm", *algorithm)
+	}
+	if err != nil {
+		fatal("recommendation failed", "steamid", *steamId, "error", err)
+	}
+	printJSON(recommendations)
+}
+
+func runTrainALS(args []string) {
+	flags := newFlagSet("train-als")
+	params := defaultALSParams
+	flags.IntVar(&params.factors, "factors", params.factors, "number of latent factors")
+	flags.IntVar(&params.iterations, "iterations", params.iterations, "number of alternating sweeps")
+	flags.Float64Var(&params.regularization, "regularization", params.regularization, "L2 regularization")
+	flags.Float64Var(&params.alpha, "alpha", params.alpha, "confidence given to a review")
+	flags.Int64Var(&params.seed, "seed", params.seed, "random seed for the initial factors")
+	metricsAddr := metricsAddrFlag(flags)
+	flags.Parse(args)
+
+	if params.factors <= 0 || params.iterations <= 0 {
+		fatal("factors and iterations must be positive")
+	}
+
+	startMetrics(*metricsAddr)
+	trainALSModel(params)
+}
+
+func runSimilar(args []string) {
+	flags := newFlagSet("similar")
+	gameId := flags.Int("appid", 0, "app id of the game")
+	limit := flags.Int("limit", defaultRecommendationLimit, "number of similar games")
+	algorithm := flags.String("algorithm", "cooccurrence", "cooccurrence or als")
+	flags.Parse(args)
+
+	if *limit < 1 {
+		fatal("limit must be at least 1", "limit", *limit)
+	}
+
+	var similarities []GameSimilarity
+	var err error
+
+	switch *algorithm {
+	case "cooccurrence":
+		similarities = database.findGameLink(*gameId).SimilarGames
+		if len(similarities) > *limit {
+			similarities = similarities[:*limit]
+		}
+	case "als":
+		similarities, err = findSimilarGamesALS(*gameId, *limit)
+	default:
+		fatal("unknown algorithm", "algorithm", *algorithm)
+	}
+	if err != nil {
+		fatal("finding similar games failed", "appid", *gameId, "error", err)
+	}
+	printJSON(similarities)
+}
+
+func printJSON(value interface{}) {
+	out, err := json.MarshalIndent(value, "", " ")
+	check(err)
+	fmt.Println(string(out))
+}
+
+func runEvaluate(args []string) {
+	flags := newFlagSet("evaluate")
+	params := evaluationParams{als: defaultALSParams}
+	flags.StringVar(&params.algorithm, "algorithm", "itemcf", "itemcf, als or popularity")
+	flags.Float64Var(&params.holdout, "holdout", 0.2, "fraction of each user's reviewed games to hold out")
+	flags.IntVar(&params.k, "k", 10, "number of recommendations scored per user")
+	flags.IntVar(&params.users, "users", 1000, "number of held out users to score, 0 for all")
+	flags.IntVar(&params.neighborsPerSeed, "neighbors", defaultNeighborsPerSeed, "similar games considered per reviewed game")
+	flags.Int64Var(&params.seed, "seed", 1, "random seed for the split")
+	flags.IntVar(&params.als.factors, "factors", params.als.factors, "number of ALS latent factors")
+	flags.IntVar(&params.als.iterations, "iterations", params.als.iterations, "number of ALS sweeps")
+	flags.Float64Var(&params.als.regularization, "regularization", params.als.regularization, "ALS L2 regularization")
+	flags.Float64Var(&params.als.alpha, "alpha", params.als.alpha, "ALS confidence given to a review")
+	weightingName := flags.String("weighting", "none", "itemcf co-occurrence weighting: none or playtime")
+	capHours := flags.Float64("playtime-cap", defaultPlaytimeCapHours, "hours of playtime at which a review gets full weight")
+	out := flags.String("out", "", "path of the JSON report")
+	metricsAddr := metricsAddrFlag(flags)
+	flags.Parse(args)
+
+	if params.holdout <= 0 || params.holdout >= 1 {
+		fatal("holdout must be between 0 and 1")
+	}
+	if params.k <= 0 {
+		fatal("k must be positive")
+	}
+	setWeighting(*weightingName, *capHours)
+
+	startMetrics(*metricsAddr)
+	report := evaluate(params)
+	writeEvaluationReport(report, *out)
+	printJSON(report)
+}
+
+func runMinHash(args []string) {
+	flags := newFlagSet("minhash")
+	params := defaultMinHashParams
+	flags.IntVar(&params.permutations, "permutations", params.permutations, "length of each signature")
+	flags.IntVar(&params.bands, "bands", params.bands, "number of LSH bands, must divide permutations")
+	flags.IntVar(&params.limit, "limit", params.limit, "similar games kept per game")
+	flags.Float64Var(&params.minJaccard, "min-jaccard", params.minJaccard, "lowest estimated Jaccard kept")
+	flags.IntVar(&params.sample, "sample", params.sample, "games compared against exact results, 0 for none")
+	flags.Int64Var(&params.seed, "seed", params.seed, "random seed for the hash functions and the sample")
+	metricsAddr := metricsAddrFlag(flags)
+	flags.Parse(args)
+
+	if params.permutations <= 0 || params.bands <= 0 || params.permutations%params.bands != 0 {
+		fatal("bands must be positive and divide permutations")
+	}
+	if params.limit < 1 {
+		fatal("limit must be at least 1", "limit", params.limit)
+	}
+	if params.sample < 0 {
+		fatal("sample must not be negative", "sample", params.sample)
+	}
+
+	startMetrics(*metricsAddr)
+	report := populateApproxSimilarities(params)
+	printJSON(report)
+}
+
+func runCommunities(args []string) {
+	flags := newFlagSet("communities")
+	params := defaultCommunityParams
+	flags.IntVar(&params.topK, "top-k", params.topK, "strongest links kept per game")
+	flags.StringVar(&params.metric, "metric", params.metric, "link weight: "+strings.Join(similarityMetrics, ", "))
+	flags.Float64Var(&params.minWeight, "min-weight", params.minWeight, "weakest link weight kept")
+	flags.Float64Var(&params.resolution, "resolution", params.resolution, "modularity resolution, higher finds smaller communities")
+	flags.Int64Var(&params.seed, "seed", params.seed, "random seed for the node order")
+	metricsAddr := metricsAddrFlag(flags)
+	flags.Parse(args)
+
+	if !isSimilarityMetric(params.metric) {
+		fatal("unknown metric", "metric", params.metric)
+	}
+
+	startMetrics(*metricsAddr)
+	summaries := detectCommunities(params)
+	printJSON(summaries)
+}
+
+func runCentrality(args []string) {
+	flags := newFlagSet("centrality")
+	params := defaultCentralityParams
+	flags.IntVar(&params.topK, "top-k", params.topK, "strongest links kept per game")
+	flags.StringVar(&params.metric, "metric", params.metric, "link weight: "+strings.Join(similarityMetrics, ", "))
+	flags.Float64Var(&params.minWeight, "min-weight", params.minWeight, "weakest link weight kept")
+	flags.IntVar(&params.samples, "samples", params.samples, "source games sampled for betweenness")
+	flags.Int64Var(&params.seed, "seed", params.seed, "random seed for the betweenness sample")
+	metricsAddr := metricsAddrFlag(flags)
+	flags.Parse(args)
+
+	if !isSimilarityMetric(params.metric) {
+		fatal("unknown metric", "metric", params.metric)
+	}
+	if params.samples <= 0 {
+		fatal("samples must be positive")
+	}
+
+	startMetrics(*metricsAddr)
+	computeCentrality(params)
+}
+
+func runTop(args []string) {
+	flags := newFlagSet("top")
+	field := flags.String("sort", "pagerank", "sort by: "+strings.Join(centralityFields, ", "))
+	limit := flags.Int64("limit", 20, "number of games")
+	community := flags.Int("community", -1, "only games of this community")
+	flags.Parse(args)
+
+	if !isCentralityField(*field) {
+		fatal("unknown sort field", "sort", *field)
+	}
+
+	var communityFilter *int
+	if *community >= 0 {
+		communityFilter = community
+	}
+	printJSON(database.findTopGames(*field, communityFilter, *limit))
+}
+
+func runPath(args []string) {
+	flags := newFlagSet("path")
+	from := flags.String("from", "", "app id or name of the first game")
+	to := flags.String("to", "", "app id or name of the last game")
+	params := defaultPathParams
+	flags.IntVar(&params.topK, "top-k", params.topK, "strongest links kept per game")
+	flags.StringVar(&params.metric, "metric", params.metric, "link similarity: cosine or jaccard")
+	flags.Float64Var(&params.minWeight, "min-weight", params.minWeight, "weakest link similarity kept")
+	flags.Parse(args)
+
+	if params.metric != "cosine" && params.metric != "jaccard" {
+		fatal("metric must be cosine or jaccard")
+	}
+
+	names := populateGameNameMap()
+	fromId, err := resolveGame(*from, names)
+	if err != nil {
+		fatal("resolving game failed", "error", err)
+	}
+	toId, err := resolveGame(*to, names)
+	if err != nil {
+		fatal("resolving game failed", "error", err)
+	}
+
+	graph := buildSimilarityGraph(params.topK, params.metric, params.minWeight)
+	path, err := findTastePath(graph, fromId, toId, names)
+	if err != nil {
+		fatal("finding path failed", "from", fromId, "to", toId, "error", err)
+	}
+	printJSON(path)
+}
+
+func runServe(args []string) {
+	flags := newFlagSet("serve")
+	addr := flags.String("addr", ":8080", "address to listen on")
+	flags.Parse(args)
+
+	serve(*addr)
+}
+
+func runSearch(args []string) {
+	flags := newFlagSet("search")
+	limit := flags.Int("limit", 10, "number of results")
+	flags.Parse(args)
+
+	query := strings.Join(flags.Args(), " ")
+	if strings.TrimSpace(query) == "" {
+		fatal("usage: search [--limit n] <query>")
+	}
+	if *limit < 1 {
+		fatal("limit must be at least 1", "limit", *limit)
+	}
+
+	results := buildSearchIndex().search(query)
+	if len(results) > *limit {
+		results = results[:*limit]
+	}
+	printJSON(results)
+}
+
+func runStatus(args []string) {
+	flags := newFlagSet("status")
+	stage := flags.String("stage", "", "only this stage")
+	flags.Parse(args)
+
+	statuses, ok := stageStatuses(*stage)
+	if !ok {
+		fatal("no status for stage", "stage", *stage)
+	}
+	printJSON(statuses)
+}
+
+func runFakeSteam(args []string) {
+	flags := newFlagSet("fake-steam")
+	addr := flags.String("addr", ":8090", "address to listen on")
+	params := defaultFakeSteamParams
+	flags.IntVar(&params.apps, "apps", params.apps, "number of apps in the app list")
+	flags.Float64Var(&params.gameShare, "game-share", params.gameShare, "fraction of apps that are games rather than dlc")
+	flags.IntVar(&params.reviewers, "reviewers", params.reviewers, "number of distinct reviewers")
+	flags.IntVar(&params.minReviews, "min-reviews", params.minReviews, "fewest reviews of a game")
+	flags.IntVar(&params.maxReviews, "max-reviews", params.maxReviews, "most reviews of a game")
+	flags.StringVar(&params.distribution, "distribution", params.distribution, "distribution of reviews over games: zipf or uniform")
+	flags.Float64Var(&params.exponent, "exponent", params.exponent, "exponent of the zipf distribution")
+	flags.IntVar(&params.pageSize, "page-size", params.pageSize, "most reviews per page")
+	flags.Float64Var(&params.rateLimit, "rate-limit", params.rateLimit, "probability of answering a request with 429")
+	flags.DurationVar(&params.latency, "latency", params.latency, "delay before every response")
+	flags.Int64Var(&params.seed, "seed", params.seed, "random seed of the dataset")
+	flags.Parse(args)
+
+	server, err := newFakeSteam(params)
+	if err != nil {
+		fatal("invalid fake steam parameters", "error", err)
+	}
+	slog.Info("serving fake steam", "addr", *addr, "apps", params.apps, "seed", params.seed)
+	fatal("fake steam stopped", "error", http.ListenAndServe(*addr, server))
+}
+
+func runSynthesize(args []string) {
+	flags := newFlagSet("synthesize")
+	params := defaultSyntheticParams
+	flags.IntVar(&params.games, "games", params.games, "number of games")
+	flags.IntVar(&params.users, "users", params.users, "number of users")
+	flags.IntVar(&params.clusters, "clusters", params.clusters, "number of planted taste clusters")
+	flags.Float64Var(&params.reviewsPerUser, "reviews-per-user", params.reviewsPerUser, "mean number of games a user reviews")
+	flags.Float64Var(&params.affinity, "affinity", params.affinity, "probability that a review is of a game in the user's cluster")
+	flags.Float64Var(&params.popularity, "popularity", params.popularity, "zipf exponent of game popularity within a cluster, 0 for none")
+	flags.IntVar(&params.firstAppId, "first-appid", params.firstAppId, "app id of the first game")
+	flags.Int64Var(&params.seed, "seed", params.seed, "random seed")
+	flags.Parse(args)
+
+	if err := params.validate(); err != nil {
+		fatal("invalid synthetic parameters", "error", err)
+	}
+	writeSyntheticDataset(generateSyntheticDataset(params))
+}
+
+func runValidateSynthetic(args []string) {
+	flags := newFlagSet("validate-synthetic")
+	k := flags.Int("k", 10, "similar games scored per game")
+	flags.Parse(args)
+
+	if *k < 1 {
+		fatal("k must be at least 1", "k", *k)
+	}
+
+	printJSON(validateSynthetic(*k))
+}
+
+func runReparse(args []string) {
+	flags := newFlagSet("reparse")
+	dir := flags.String("archive", "", "directory of archived Steam responses")
+	links := flags.Bool("links", true, "also rebuild user-links and game-links")
+	metricsAddr := metricsAddrFlag(flags)
+	flags.Parse(args)
+
+	if *dir == "" {
+		fatal("usage: reparse --archive <dir>")
+	}
+	startMetrics(*metricsAddr)
+	reparse(*dir, *links)
+}
+
+func runExport(args []string) {
+	flags := newFlagSet("export")
+	format := flags.String("format", "csv", "output format: "+strings.Join(exportFormatNames(), ", "))
+	compression := flags.String("compression", "none", "compression: "+strings.Join(exportCompressions, ", "))
+	columns := flags.String("columns", "", "comma separated columns to export, all by default, needs a single collection")
+	dir := flags.String("dir", ".", "directory the files are written to")
+	flags.Parse(args)
+
+	tables := flags.Args()
+	if len(tables) == 0 {
+		tables = exportTableNames()
+	}
+	for _, table := range tables {
+		if _, ok := findExportTable(table); !ok {
+			fatal("can't export collection", "collection", table, "collections", exportTableNames())
+		}
+	}
+	if _, ok := exportFormats[*format]; !ok {
+		fatal("unknown export format", "format", *format)
+	}
+	if !isExportCompression(*compression) {
+		fatal("unknown compression", "compression", *compression)
+	}
+
+	var columnNames []string
+	if *columns != "" {
+		if len(tables) != 1 {
+			fatal("columns can only be selected when exporting a single collection")
+		}
+		for _, name := range strings.Split(*columns, ",") {
+			columnNames = append(columnNames, strings.TrimSpace(name))
+		}
+	}
+
+	exportCollections(tables, *dir, *format, *compression, columnNames)
+}
+
+func runNeo4jExport(args []string) {
+	flags := newFlagSet("neo4j-export")
+	dir := flags.String("dir", "neo4j", "directory the CSVs and load.cypher are written to")
+	top := flags.Int("top", 50, "SIMILAR_TO relationships kept per game, 0 for all")
+	flags.Parse(args)
+
+	if *top < 0 {
+		fatal("top can't be negative", "top", *top)
+	}
+	exportNeo4j(*dir, *top)
+}
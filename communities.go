@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const communityTopGames = 10
+const communityTopGenres = 5
+
+type communityParams struct {
+	topK       int
+	metric     string
+	minWeight  float64
+	resolution float64
+	seed       int64
+}
+
+var defaultCommunityParams = communityParams{
+	topK:       20,
+	metric:     "cosine",
+	minWeight:  0,
+	resolution: 1,
+	seed:       1,
+}
+
+// detectCommunities clusters the whole-catalog similarity graph with
+// Louvain and stores each game's community and a summary per community.
+func detectCommunities(params communityParams) []CommunityDTO {
+	defer timeTrack(time.Now(), "detectCommunities")
+
+	graph := buildSimilarityGraph(params.topK, params.metric, params.minWeight)
+
+	membership, modularity := louvain(graph.adjacency, params.resolution, rand.New(rand.NewSource(params.seed)))
+	membership = renumberBySize(membership)
+
+	assignments := make(map[int]int)
+	for i, gameId := range graph.ids {
+		assignments[gameId] = membership[i]
+	}
+	summaries := summarizeCommunities(assignments)
+	slog.Info("found communities", "stage", "communities", "communities", len(summaries), "modularity", modularity)
+
+	database.saveGameCommunities(assignments)
+	database.replaceCommunities(summaries)
+
+	return summaries
+}
+
+// louvain greedily moves nodes to the neighbouring community with the best
+// modularity gain, then merges each community into a single node and starts
+// again, until no move improves modularity. adjacency must be symmetric.
+func louvain(adjacency []map[int]float64, resolution float64, random *rand.Rand) ([]int, float64) {
+	membership := make([]int, len(adjacency))
+	for i := range membership {
+		membership[i] = i
+	}
+
+	level := adjacency
+	for {
+		communities, improved := louvainMoveNodes(level, resolution, random)
+		if !improved {
+			break
+		}
+
+		var count int
+		communities, count = compactCommunities(communities)
+		for i := range membership {
+			membership[i] = communities[membership[i]]
+		}
+		level = aggregateCommunities(level, communities, count)
+	}
+
+	return membership, modularity(adjacency, membership, resolution)
+}
+
+func louvainMoveNodes(adjacency []map[int]float64, resolution float64, random *rand.Rand) ([]int, bool) {
+	n := len(adjacency)
+	degrees := make([]float64, n)
+	var totalWeight float64
+	for i, neighbors := range adjacency {
+		for _, w := range neighbors {
+			degrees[i] += w
+		}
+		totalWeight += degrees[i]
+	}
+
+	communities := make([]int, n)
+	totals := make([]float64, n)
+	for i := range communities {
+		communities[i] = i
+		totals[i] = degrees[i]
+	}
+	if totalWeight == 0 {
+		return communities, false
+	}
+
+	improved := false
+	order := random.Perm(n)
+
+	for moved := true; moved; {
+		moved = false
+		for _, i := range order {
+			current := communities[i]
+
+			weights := make(map[int]float64)
+			for j, w := range adjacency[i] {
+				if j != i {
+					weights[communities[j]] += w
+				}
+			}
+
+			totals[current] -= degrees[i]
+			best := current
+			bestGain := weights[current] - resolution*totals[current]*degrees[i]/totalWeight
+
+			for community, w := range weights {
+				gain := w - resolution*totals[community]*degrees[i]/totalWeight
+				if gain > bestGain+1e-12 || (math.Abs(gain-bestGain) <= 1e-12 && community < best) {
+					best, bestGain = community, gain
+				}
+			}
+			totals[best] += degrees[i]
+			communities[i] = best
+
+			if best != current {
+				moved = true
+				improved = true
+			}
+		}
+	}
+	return communities, improved
+}
+
+func compactCommunities(communities []int) ([]int, int) {
+	ids := make(map[int]int)
+	compact := make([]int, len(communities))
+	for i, c := range communities {
+		id, ok := ids[c]
+		if !ok {
+			id = len(ids)
+			ids[c] = id
+		}
+		compact[i] = id
+	}
+	return compact, len(ids)
+}
+
+// aggregateCommunities sums the weights between communities, keeping the
+// weight inside a community as a self loop.
+func aggregateCommunities(adjacency []map[int]float64, communities []int, count int) []map[int]float64 {
+	aggregated := make([]map[int]float64, count)
+	for i := range aggregated {
+		aggregated[i] = make(map[int]float64)
+	}
+	for i, neighbors := range adjacency {
+		for j, w := range neighbors {
+			aggregated[communities[i]][communities[j]] += w
+		}
+	}
+	return aggregated
+}
+
+func modularity(adjacency []map[int]float64, membership []int, resolution float64) float64 {
+	inside := make(map[int]float64)
+	totals := make(map[int]float64)
+	var totalWeight float64
+
+	for i, neighbors := range adjacency {
+		for j, w := range neighbors {
+			totals[membership[i]] += w
+			totalWeight += w
+			if membership[i] == membership[j] {
+				inside[membership[i]] += w
+			}
+		}
+	}
+	if totalWeight == 0 {
+		return 0
+	}
+
+	var q float64
+	for c, total := range totals {
+		q += inside[c]/totalWeight - resolution*math.Pow(total/totalWeight, 2)
+	}
+	return q
+}
+
+// renumberBySize makes community 0 the largest one.
+func renumberBySize(membership []int) []int {
+	sizes := make(map[int]int)
+	for _, c := range membership {
+		sizes[c]++
+	}
+	var communities []int
+	for c := range sizes {
+		communities = append(communities, c)
+	}
+	sort.Slice(communities, func(i, j int) bool {
+		if sizes[communities[i]] != sizes[communities[j]] {
+			return sizes[communities[i]] > sizes[communities[j]]
+		}
+		return communities[i] < communities[j]
+	})
+
+	ids := make(map[int]int)
+	for id, c := range communities {
+		ids[c] = id
+	}
+	renumbered := make([]int, len(membership))
+	for i, c := range membership {
+		renumbered[i] = ids[c]
+	}
+	return renumbered
+}
+
+func summarizeCommunities(assignments map[int]int) []CommunityDTO {
+	reviewCounts := database.findAllReviewCounts()
+
+	genres := make(map[int][]string)
+	cursor := database.findGames()
+	for cursor.Next(context.TODO()) {
+		var game StoreEntryDTO
+		err := cursor.Decode(&game)
+		check(err)
+		genres[game.ID] = game.Genres
+	}
+
+	members := make(map[int][]int)
+	for gameId, community := range assignments {
+		members[community] = append(members[community], gameId)
+	}
+
+	var topIds []int
+	summaries := make([]CommunityDTO, len(members))
+	for community, gameIds := range members {
+		sort.Slice(gameIds, func(i, j int) bool {
+			if reviewCounts[gameIds[i]] != reviewCounts[gameIds[j]] {
+				return reviewCounts[gameIds[i]] > reviewCounts[gameIds[j]]
+			}
+			return gameIds[i] < gameIds[j]
+		})
+
+		summary := CommunityDTO{ID: community, Size: len(gameIds)}
+		for _, gameId := range gameIds {
+			if len(summary.TopGames) == communityTopGames {
+				break
+			}
+			summary.TopGames = append(summary.TopGames, CommunityGame{GameId: gameId, Reviews: reviewCounts[gameId]})
+			topIds = append(topIds, gameId)
+		}
+
+		genreCounts := make(map[string]int)
+		for _, gameId := range gameIds {
+			for _, genre := range genres[gameId] {
+				genreCounts[genre]++
+			}
+		}
+		for genre, count := range genreCounts {
+			summary.DominantGenres = append(summary.DominantGenres, GenreCount{Genre: genre, Count: count})
+		}
+		sort.Slice(summary.DominantGenres, func(i, j int) bool {
+			if summary.DominantGenres[i].Count != summary.DominantGenres[j].Count {
+				return summary.DominantGenres[i].Count > summary.DominantGenres[j].Count
+			}
+			return summary.DominantGenres[i].Genre < summary.DominantGenres[j].Genre
+		})
+		if len(summary.DominantGenres) > communityTopGenres {
+			summary.DominantGenres = summary.DominantGenres[:communityTopGenres]
+		}
+
+		summaries[community] = summary
+	}
+
+	names := make(map[int]string)
+	for _, entry := range database.findStoreEntriesByIds(topIds) {
+		names[entry.ID] = entry.Name
+	}
+	for i := range summaries {
+		for j := range summaries[i].TopGames {
+			summaries[i].TopGames[j].Name = names[summaries[i].TopGames[j].GameId]
+		}
+	}
+	return summaries
+}
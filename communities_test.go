@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type testEdge struct {
+	a, b   int
+	weight float64
+}
+
+func testSimilarityGraph(edges []testEdge) similarityGraph {
+	graph := similarityGraph{index: make(map[int]int)}
+	for _, edge := range edges {
+		graph.addEdge(graph.node(edge.a), graph.node(edge.b), edge.weight)
+	}
+	return graph
+}
+
+func cliqueEdges(ids []int, weight float64) []testEdge {
+	var edges []testEdge
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			edges = append(edges, testEdge{ids[i], ids[j], weight})
+		}
+	}
+	return edges
+}
+
+// Two cliques of four games joined by one weak link split into two
+// communities whatever order the nodes are visited in.
+func TestLouvainSplitsTwoCliques(t *testing.T) {
+	edges := append(cliqueEdges([]int{1, 2, 3, 4}, 1), cliqueEdges([]int{5, 6, 7, 8}, 1)...)
+	edges = append(edges, testEdge{4, 5, 0.1})
+	graph := testSimilarityGraph(edges)
+
+	// 2m = 24.2 with 12 inside each clique and degrees summing to 12.1
+	wantModularity := 2 * (12/24.2 - math.Pow(12.1/24.2, 2))
+
+	for seed := int64(1); seed <= 5; seed++ {
+		membership, q := louvain(graph.adjacency, 1, rand.New(rand.NewSource(seed)))
+
+		communities := make(map[int]int)
+		for i, gameId := range graph.ids {
+			communities[gameId] = membership[i]
+		}
+		if communities[1] == communities[5] {
+			t.Errorf("seed %v: both cliques are in community %v", seed, communities[1])
+		}
+		for _, gameId := range []int{2, 3, 4} {
+			if communities[gameId] != communities[1] {
+				t.Errorf("seed %v: game %v isn't in the community of its clique: %v", seed, gameId, communities)
+			}
+		}
+		for _, gameId := range []int{6, 7, 8} {
+			if communities[gameId] != communities[5] {
+				t.Errorf("seed %v: game %v isn't in the community of its clique: %v", seed, gameId, communities)
+			}
+		}
+		if math.Abs(q-wantModularity) > 1e-9 {
+			t.Errorf("seed %v: modularity = %v, want %v", seed, q, wantModularity)
+		}
+	}
+}
+
+func TestRenumberBySize(t *testing.T) {
+	renumbered := renumberBySize([]int{7, 3, 3, 9, 3, 7})
+	if want := []int{1, 0, 0, 2, 0, 1}; !reflect.DeepEqual(renumbered, want) {
+		t.Errorf("renumberBySize = %v, want %v", renumbered, want)
+	}
+}
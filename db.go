@@ -2,11 +2,12 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 )
 
@@ -16,6 +17,20 @@ const gameReviewsCollection = "game-reviews"
 const userLinksCollection = "user-links"
 const gameLinksCollection = "game-links"
 const graphCollection = "graph"
+const userFactorsCollection = "user-factors"
+const gameFactorsCollection = "game-factors"
+const modelsCollection = "models"
+const minHashSignaturesCollection = "minhash-signatures"
+const communitiesCollection = "communities"
+const metadataCollection = "metadata"
+const statusCollection = "status"
+const plantedCommunitiesCollection = "planted-communities"
+
+// Collections are written under their name with stagingSuffix, then renamed
+// into place.
+const stagingSuffix = "-staging"
+const bulkWriteBatchSize = 1000
+const maxPoolSize = 100
 
 type DataBase struct {
 	db *mongo.Database
@@ -23,17 +38,67 @@ type DataBase struct {
 
 func (d *DataBase) initDatabase(databaseUrl string) {
 
-	client, err := mongo.NewClient(options.Client().ApplyURI(databaseUrl))
+	clientOptions := options.Client().
+		ApplyURI(databaseUrl).
+		SetMaxPoolSize(maxPoolSize).
+		SetMonitor(newCommandMonitor()).
+		SetPoolMonitor(newPoolMonitor())
+	dbMaxConnections.set(maxPoolSize)
+
+	client, err := mongo.NewClient(clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		fatal("database error", "error", err)
 	}
 	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
 	err = client.Connect(ctx)
 	if err != nil {
-		log.Fatal(err)
+		fatal("database error", "error", err)
+	}
+	slog.Info("connected to database")
+	d.db = client.Database(databaseName)
+}
+
+// newCommandMonitor records the latency of every command against the
+// collection it was issued on.
+func newCommandMonitor() *event.CommandMonitor {
+	var collections sync.Map
+
+	finished := func(e event.CommandFinishedEvent) string {
+		collection, _ := collections.LoadAndDelete(e.RequestID)
+		name, _ := collection.(string)
+		dbOperationSeconds.observe(float64(e.DurationNanos)/1e9, e.CommandName, name)
+		return name
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			// getMore names the cursor id where other commands name the collection
+			collection, ok := e.Command.Lookup(e.CommandName).StringValueOK()
+			if !ok {
+				collection, _ = e.Command.Lookup("collection").StringValueOK()
+			}
+			collections.Store(e.RequestID, collection)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finished(e.CommandFinishedEvent)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			dbOperationErrors.inc(e.CommandName, finished(e.CommandFinishedEvent))
+		},
+	}
+}
+
+func newPoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.GetSucceeded:
+				dbConnectionsInUse.add(1)
+			case event.ConnectionReturned:
+				dbConnectionsInUse.add(-1)
+			}
+		},
 	}
-	fmt.Println("Connected to db")
-	d.db = client.Database("valkyrie")
 }
 
 func (d *DataBase) saveStoreEntry(entry StoreEntry) {
@@ -45,7 +110,7 @@ func (d *DataBase) saveStoreEntry(entry StoreEntry) {
 
 	_, err := gamesCollection.InsertOne(context.TODO(), game)
 	if err != nil {
-		log.Fatal(err)
+		fatal("database error", "error", err)
 	}
 }
 
@@ -65,7 +130,7 @@ func (d *DataBase) findStoreEntries() *mongo.Cursor {
 	res, err := storeEntriesCollection.Find(context.TODO(), bson.M{}, findOptions)
 
 	if err != nil {
-		log.Fatal(err)
+		fatal("database error", "error", err)
 	}
 
 	return res
@@ -76,10 +141,28 @@ func (d *DataBase) saveGame(entry StoreEntryDTO) {
 
 	_, err := gamesCollection.InsertOne(context.TODO(), entry)
 	if err != nil {
-		log.Fatal(err)
+		fatal("database error", "error", err)
 	}
 }
 
+// findGamesWithoutGenres returns the games saved before genres were
+// recorded.
+func (d *DataBase) findGamesWithoutGenres() []StoreEntryDTO {
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{"_id", 1}})
+
+	gamesCollection := d.db.Collection(gamesCollectionName)
+
+	cursor, err := gamesCollection.Find(context.TODO(), bson.M{"genres": bson.M{"$exists": false}}, findOptions)
+	check(err)
+
+	var games []StoreEntryDTO
+	err = cursor.All(context.TODO(), &games)
+	check(err)
+
+	return games
+}
+
 func (d *DataBase) findGames() *mongo.Cursor {
 	findOptions := options.Find()
 	findOptions.SetSort(bson.D{{"_id", 1}})
@@ -89,7 +172,7 @@ func (d *DataBase) findGames() *mongo.Cursor {
 	res, err := storeEntriesCollection.Find(context.TODO(), bson.M{}, findOptions)
 
 	if err != nil {
-		log.Fatal(err)
+		fatal("database error", "error", err)
 	}
 
 	return res
@@ -108,7 +191,7 @@ func (d *DataBase) findLastProcessedReview() GameReviewDTO {
 	err := res.Decode(&game)
 
 	if err != nil {
-		log.Println("Error while fetching last reviewed game")
+		slog.Warn("no last reviewed game", "error", err)
 		return GameReviewDTO{AppId: 0}
 	}
 
@@ -131,12 +214,19 @@ func (d *DataBase) findGameReviews() *mongo.Cursor {
 	res, err := gameReviewsCollection.Find(context.TODO(), bson.M{}, findOptions)
 
 	if err != nil {
-		log.Fatal(err)
+		fatal("database error", "error", err)
 	}
 
 	return res
 }
 
+// countDocuments is an estimate from the collection's metadata.
+func (d *DataBase) countDocuments(collection string) int64 {
+	count, err := d.db.Collection(collection).EstimatedDocumentCount(context.TODO())
+	check(err)
+	return count
+}
+
 func (d *DataBase) findGameReview(gameId int) GameReviewDTO {
 	defer timeTrack(time.Now(), "findGameReview")
 
@@ -194,15 +284,18 @@ func (d *DataBase) findUserLinks(ids []string) []UserLinkDTO {
 	return userLinks
 }
 
+// saveGameLink only sets similarGames, so re-running similarities keeps the
+// approxSimilarGames of minhash and either stage can run first.
 func (d *DataBase) saveGameLink(gameId int, similarities []GameSimilarity) {
-	gameLinksCollection := d.db.Collection(gameLinksCollection)
-
-	var gameLink GameLinkDTO
+	updateOptions := options.Update()
+	updateOptions.SetUpsert(true)
 
-	gameLink.GameId = gameId
-	gameLink.SimilarGames = similarities
+	gameLinksCollection := d.db.Collection(gameLinksCollection)
 
-	_, err := gameLinksCollection.InsertOne(context.TODO(), gameLink)
+	update := bson.M{
+		"$set": bson.M{"similarGames": similarities},
+	}
+	_, err := gameLinksCollection.UpdateOne(context.TODO(), bson.M{"_id": gameId}, update, updateOptions)
 	check(err)
 }
 
@@ -215,7 +308,7 @@ func (d *DataBase) findAllGameLinks() *mongo.Cursor {
 	res, err := gameLinkscollection.Find(context.TODO(), bson.M{}, findOptions)
 
 	if err != nil {
-		log.Fatal(err)
+		fatal("database error", "error", err)
 	}
 
 	return res
@@ -237,9 +330,480 @@ func (d *DataBase) findGameLink(id int) GameLinkDTO {
 	return gameLink
 }
 
-func (d *DataBase) saveGraph(graph Graph) {
+func (d *DataBase) saveGraph(graph GraphDTO) {
+	replaceOptions := options.Replace()
+	replaceOptions.SetUpsert(true)
+
 	graphCollection := d.db.Collection(graphCollection)
 
-	_, err := graphCollection.InsertOne(context.TODO(), graph)
+	_, err := graphCollection.ReplaceOne(context.TODO(), bson.M{"_id": graph.Key}, graph, replaceOptions)
 	check(err)
 }
+
+func (d *DataBase) findGraph(key string) (GraphDTO, bool) {
+	graphCollection := d.db.Collection(graphCollection)
+
+	res := graphCollection.FindOne(context.TODO(), bson.M{"_id": key})
+
+	var graph GraphDTO
+
+	if res.Err() != nil {
+		return graph, false
+	}
+	err := res.Decode(&graph)
+	check(err)
+
+	return graph, true
+}
+
+// touchCollection records that a collection has just been rewritten.
+func (d *DataBase) touchCollection(name string) {
+	updateOptions := options.Update()
+	updateOptions.SetUpsert(true)
+
+	metadataCollection := d.db.Collection(metadataCollection)
+
+	update := bson.M{
+		"$set": bson.M{"updatedAt": time.Now()},
+	}
+	_, err := metadataCollection.UpdateOne(context.TODO(), bson.M{"_id": name}, update, updateOptions)
+	check(err)
+}
+
+// collectionUpdatedAt is the zero time for collections never touched.
+func (d *DataBase) collectionUpdatedAt(name string) time.Time {
+	metadataCollection := d.db.Collection(metadataCollection)
+
+	res := metadataCollection.FindOne(context.TODO(), bson.M{"_id": name})
+
+	var metadata CollectionMetadataDTO
+
+	if res.Err() == nil {
+		err := res.Decode(&metadata)
+		check(err)
+	}
+
+	return metadata.UpdatedAt
+}
+
+func (d *DataBase) findGameLinks(ids []int) []GameLinkDTO {
+	gameLinksCollection := d.db.Collection(gameLinksCollection)
+
+	cursor, err := gameLinksCollection.Find(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+	check(err)
+
+	var gameLinks []GameLinkDTO
+
+	err = cursor.All(context.TODO(), &gameLinks)
+	check(err)
+
+	return gameLinks
+}
+
+func (d *DataBase) findStoreEntriesByIds(ids []int) []StoreEntryDTO {
+	storeEntriesCollection := d.db.Collection(storeEntriesCollection)
+
+	cursor, err := storeEntriesCollection.Find(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+	check(err)
+
+	var storeEntries []StoreEntryDTO
+
+	err = cursor.All(context.TODO(), &storeEntries)
+	check(err)
+
+	return storeEntries
+}
+
+func (d *DataBase) findAllUserLinks() *mongo.Cursor {
+	userLinksCollection := d.db.Collection(userLinksCollection)
+
+	res, err := userLinksCollection.Find(context.TODO(), bson.M{})
+
+	if err != nil {
+		fatal("database error", "error", err)
+	}
+
+	return res
+}
+
+// clearStagedFactors drops the factors an interrupted training left behind.
+func (d *DataBase) clearStagedFactors() {
+	err := d.db.Collection(userFactorsCollection + stagingSuffix).Drop(context.TODO())
+	check(err)
+
+	err = d.db.Collection(gameFactorsCollection + stagingSuffix).Drop(context.TODO())
+	check(err)
+}
+
+// publishFactors renames the staged factors over the served ones, so that
+// recommendations use the previous model until the new one is saved.
+func (d *DataBase) publishFactors() {
+	d.renameCollection(userFactorsCollection+stagingSuffix, userFactorsCollection)
+	d.renameCollection(gameFactorsCollection+stagingSuffix, gameFactorsCollection)
+}
+
+func (d *DataBase) renameCollection(from string, to string) {
+	command := bson.D{
+		{Key: "renameCollection", Value: d.db.Name() + "." + from},
+		{Key: "to", Value: d.db.Name() + "." + to},
+		{Key: "dropTarget", Value: true},
+	}
+	err := d.db.Client().Database("admin").RunCommand(context.TODO(), command).Err()
+	check(err)
+}
+
+// saveUserFactors stages factors until publishFactors.
+func (d *DataBase) saveUserFactors(factors []UserFactorsDTO) {
+	userFactorsCollection := d.db.Collection(userFactorsCollection + stagingSuffix)
+
+	var documents []interface{}
+	for _, f := range factors {
+		documents = append(documents, f)
+	}
+
+	_, err := userFactorsCollection.InsertMany(context.TODO(), documents)
+	check(err)
+}
+
+// saveGameFactors stages factors until publishFactors.
+func (d *DataBase) saveGameFactors(factors []GameFactorsDTO) {
+	gameFactorsCollection := d.db.Collection(gameFactorsCollection + stagingSuffix)
+
+	var documents []interface{}
+	for _, f := range factors {
+		documents = append(documents, f)
+	}
+
+	_, err := gameFactorsCollection.InsertMany(context.TODO(), documents)
+	check(err)
+}
+
+func (d *DataBase) findUserFactors(userId string) (UserFactorsDTO, bool) {
+	userFactorsCollection := d.db.Collection(userFactorsCollection)
+
+	res := userFactorsCollection.FindOne(context.TODO(), bson.M{"_id": userId})
+
+	var factors UserFactorsDTO
+
+	if res.Err() != nil {
+		return factors, false
+	}
+	err := res.Decode(&factors)
+	check(err)
+
+	return factors, true
+}
+
+func (d *DataBase) findAllGameFactors() []GameFactorsDTO {
+	gameFactorsCollection := d.db.Collection(gameFactorsCollection)
+
+	cursor, err := gameFactorsCollection.Find(context.TODO(), bson.M{})
+	check(err)
+
+	var factors []GameFactorsDTO
+
+	err = cursor.All(context.TODO(), &factors)
+	check(err)
+
+	return factors
+}
+
+func (d *DataBase) saveALSModel(model ALSModelDTO) {
+	replaceOptions := options.Replace()
+	replaceOptions.SetUpsert(true)
+
+	modelsCollection := d.db.Collection(modelsCollection)
+
+	_, err := modelsCollection.ReplaceOne(context.TODO(), bson.M{"_id": model.ID}, model, replaceOptions)
+	check(err)
+}
+
+func (d *DataBase) saveMinHashSignature(signature MinHashSignatureDTO) {
+	replaceOptions := options.Replace()
+	replaceOptions.SetUpsert(true)
+
+	signaturesCollection := d.db.Collection(minHashSignaturesCollection)
+
+	_, err := signaturesCollection.ReplaceOne(context.TODO(), bson.M{"_id": signature.GameId}, signature, replaceOptions)
+	check(err)
+}
+
+func (d *DataBase) saveApproxGameLink(gameId int, similarities []GameSimilarity) {
+	updateOptions := options.Update()
+	updateOptions.SetUpsert(true)
+
+	gameLinksCollection := d.db.Collection(gameLinksCollection)
+
+	update := bson.M{
+		"$set": bson.M{"approxSimilarGames": similarities},
+	}
+	_, err := gameLinksCollection.UpdateOne(context.TODO(), bson.M{"_id": gameId}, update, updateOptions)
+	check(err)
+}
+
+func (d *DataBase) findReviewCounts(ids []int) map[int]int {
+	gameReviewsCollection := d.db.Collection(gameReviewsCollection)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$in": ids}}}},
+		{{Key: "$project", Value: bson.M{"count": bson.M{"$size": bson.M{"$ifNull": bson.A{"$users", bson.A{}}}}}}},
+	}
+	cursor, err := gameReviewsCollection.Aggregate(context.TODO(), pipeline)
+	check(err)
+
+	var results []struct {
+		GameId int `bson:"_id"`
+		Count  int `bson:"count"`
+	}
+	err = cursor.All(context.TODO(), &results)
+	check(err)
+
+	counts := make(map[int]int)
+	for _, result := range results {
+		counts[result.GameId] = result.Count
+	}
+	return counts
+}
+
+func (d *DataBase) findGamesByIds(ids []int) []StoreEntryDTO {
+	gamesCollection := d.db.Collection(gamesCollectionName)
+
+	cursor, err := gamesCollection.Find(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+	check(err)
+
+	var games []StoreEntryDTO
+
+	err = cursor.All(context.TODO(), &games)
+	check(err)
+
+	return games
+}
+
+func (d *DataBase) findAllReviewCounts() map[int]int {
+	gameReviewsCollection := d.db.Collection(gameReviewsCollection)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{"count": bson.M{"$size": bson.M{"$ifNull": bson.A{"$users", bson.A{}}}}}}},
+	}
+	cursor, err := gameReviewsCollection.Aggregate(context.TODO(), pipeline)
+	check(err)
+
+	counts := make(map[int]int)
+	for cursor.Next(context.TODO()) {
+		var result struct {
+			GameId int `bson:"_id"`
+			Count  int `bson:"count"`
+		}
+		err := cursor.Decode(&result)
+		check(err)
+		counts[result.GameId] = result.Count
+	}
+	return counts
+}
+
+// setGameFields $sets the given fields on each game, in batches.
+func (d *DataBase) setGameFields(fields map[int]bson.M) {
+	gamesCollection := d.db.Collection(gamesCollectionName)
+
+	var models []mongo.WriteModel
+	flush := func() {
+		if len(models) == 0 {
+			return
+		}
+		_, err := gamesCollection.BulkWrite(context.TODO(), models)
+		check(err)
+		models = nil
+	}
+
+	for gameId, set := range fields {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": gameId}).
+			SetUpdate(bson.M{"$set": set}))
+		if len(models) == bulkWriteBatchSize {
+			flush()
+		}
+	}
+	flush()
+}
+
+func (d *DataBase) setGameGenres(gameId int, genres []string) {
+	d.setGameFields(map[int]bson.M{gameId: {"genres": genres}})
+}
+
+// saveGameCommunities unsets the community of every game first, so that games
+// no longer in the graph don't keep the one of an earlier run.
+func (d *DataBase) saveGameCommunities(assignments map[int]int) {
+	gamesCollection := d.db.Collection(gamesCollectionName)
+	_, err := gamesCollection.UpdateMany(context.TODO(),
+		bson.M{"community": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"community": ""}})
+	check(err)
+
+	fields := make(map[int]bson.M)
+	for gameId, community := range assignments {
+		fields[gameId] = bson.M{"community": community}
+	}
+	d.setGameFields(fields)
+	d.touchCollection(gamesCollectionName)
+}
+
+func (d *DataBase) replaceCommunities(communities []CommunityDTO) {
+	communitiesCollection := d.db.Collection(communitiesCollection)
+
+	err := communitiesCollection.Drop(context.TODO())
+	check(err)
+
+	var documents []interface{}
+	for _, community := range communities {
+		documents = append(documents, community)
+	}
+	if len(documents) == 0 {
+		return
+	}
+
+	_, err = communitiesCollection.InsertMany(context.TODO(), documents)
+	check(err)
+}
+
+func (d *DataBase) ensureCentralityIndexes() {
+	gamesCollection := d.db.Collection(gamesCollectionName)
+
+	var indexes []mongo.IndexModel
+	for _, field := range centralityFields {
+		indexes = append(indexes, mongo.IndexModel{Keys: bson.D{{Key: "centrality." + field, Value: -1}}})
+	}
+
+	_, err := gamesCollection.Indexes().CreateMany(context.TODO(), indexes)
+	check(err)
+}
+
+// findTopGames returns the games with the highest value of a centrality field.
+func (d *DataBase) findTopGames(field string, community *int, limit int64) []StoreEntryDTO {
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "centrality." + field, Value: -1}})
+	findOptions.SetLimit(limit)
+
+	filter := bson.M{"centrality": bson.M{"$exists": true}}
+	if community != nil {
+		filter["community"] = *community
+	}
+
+	gamesCollection := d.db.Collection(gamesCollectionName)
+
+	cursor, err := gamesCollection.Find(context.TODO(), filter, findOptions)
+	check(err)
+
+	var games []StoreEntryDTO
+
+	err = cursor.All(context.TODO(), &games)
+	check(err)
+
+	return games
+}
+
+func (d *DataBase) saveStageStatus(status StageStatus) {
+	replaceOptions := options.Replace()
+	replaceOptions.SetUpsert(true)
+
+	statusCollection := d.db.Collection(statusCollection)
+
+	_, err := statusCollection.ReplaceOne(context.TODO(), bson.M{"_id": status.Stage}, status, replaceOptions)
+	check(err)
+}
+
+func (d *DataBase) findStageStatuses() []StageStatus {
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "startedAt", Value: 1}})
+
+	statusCollection := d.db.Collection(statusCollection)
+
+	cursor, err := statusCollection.Find(context.TODO(), bson.M{}, findOptions)
+	check(err)
+
+	statuses := []StageStatus{}
+	err = cursor.All(context.TODO(), &statuses)
+	check(err)
+
+	return statuses
+}
+
+func (d *DataBase) replacePlantedCommunities(planted map[int]int) {
+	plantedCollection := d.db.Collection(plantedCommunitiesCollection)
+
+	err := plantedCollection.Drop(context.TODO())
+	check(err)
+
+	var documents []interface{}
+	for gameId, community := range planted {
+		documents = append(documents, PlantedCommunityDTO{GameId: gameId, Community: community})
+	}
+	if len(documents) == 0 {
+		return
+	}
+
+	_, err = plantedCollection.InsertMany(context.TODO(), documents)
+	check(err)
+}
+
+func (d *DataBase) findPlantedCommunities() map[int]int {
+	plantedCollection := d.db.Collection(plantedCommunitiesCollection)
+
+	cursor, err := plantedCollection.Find(context.TODO(), bson.M{})
+	check(err)
+
+	var documents []PlantedCommunityDTO
+	err = cursor.All(context.TODO(), &documents)
+	check(err)
+
+	planted := make(map[int]int)
+	for _, document := range documents {
+		planted[document.GameId] = document.Community
+	}
+	return planted
+}
+
+// upsertStoreEntry sets the name of a store entry, adding it when missing.
+func (d *DataBase) upsertStoreEntry(entry StoreEntry) {
+	updateOptions := options.Update()
+	updateOptions.SetUpsert(true)
+
+	storeEntriesCollection := d.db.Collection(storeEntriesCollection)
+
+	update := bson.M{
+		"$set": bson.M{"title": entry.Name},
+	}
+	_, err := storeEntriesCollection.UpdateOne(context.TODO(), bson.M{"_id": entry.AppId}, update, updateOptions)
+	check(err)
+}
+
+// upsertGameDetails sets the name and genres of a game, keeping the
+// community and centrality computed for it.
+func (d *DataBase) upsertGameDetails(game StoreEntryDTO) {
+	updateOptions := options.Update()
+	updateOptions.SetUpsert(true)
+
+	gamesCollection := d.db.Collection(gamesCollectionName)
+
+	update := bson.M{
+		"$set": bson.M{"title": game.Name, "genres": game.Genres},
+	}
+	_, err := gamesCollection.UpdateOne(context.TODO(), bson.M{"_id": game.ID}, update, updateOptions)
+	check(err)
+}
+
+func (d *DataBase) replaceGameReview(review GameReviewDTO) {
+	replaceOptions := options.Replace()
+	replaceOptions.SetUpsert(true)
+
+	gameReviewsCollection := d.db.Collection(gameReviewsCollection)
+
+	_, err := gameReviewsCollection.ReplaceOne(context.TODO(), bson.M{"_id": review.AppId}, review, replaceOptions)
+	check(err)
+}
+
+func (d *DataBase) dropCollections(names ...string) {
+	for _, name := range names {
+		err := d.db.Collection(name).Drop(context.TODO())
+		check(err)
+	}
+}
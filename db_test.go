@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// useTestDatabase connects to a scratch database, dropped on cleanup, and
+// skips the test unless DATABASE_URL points at MongoDB.
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+
+	name, progressFile := databaseName, progressfilename
+	databaseName = "steam-scraper-test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	progressfilename = filepath.Join(t.TempDir(), "progress.txt")
+	database.initDatabase(os.Getenv("DATABASE_URL"))
+	t.Cleanup(func() {
+		database.db.Drop(context.Background())
+		database.db.Client().Disconnect(context.Background())
+		databaseName, progressfilename = name, progressFile
+	})
+}
+
+func TestGameLinkStagesRunInEitherOrder(t *testing.T) {
+	useTestDatabase(t)
+	params := testSyntheticParams
+	params.games, params.users = 20, 500
+	writeSyntheticDataset(generateSyntheticDataset(params))
+	games := int(database.countDocuments(gameReviewsCollection))
+
+	exact := populateGameSimilarities
+	approx := func() { populateApproxSimilarities(defaultMinHashParams) }
+	orders := map[string][]func(){
+		"similarities first": {exact, approx},
+		"minhash first":      {approx, exact},
+	}
+	for name, stages := range orders {
+		database.dropCollections(gameLinksCollection)
+		for _, stage := range stages {
+			stage()
+		}
+
+		links := getAllGameLinks()
+		if len(links) != games {
+			t.Errorf("%v: game-links has %v games, want %v", name, len(links), games)
+		}
+		approxLinks := 0
+		for _, link := range links {
+			if len(link.SimilarGames) == 0 {
+				t.Errorf("%v: game-link of %v has no exact links", name, link.GameId)
+			}
+			approxLinks += len(link.ApproxSimilarGames)
+		}
+		if approxLinks == 0 {
+			t.Errorf("%v: game-links have no approximate links", name)
+		}
+
+		// Re-running either stage keeps the links of the other
+		for _, stage := range stages {
+			stage()
+		}
+		if rerun := getAllGameLinks(); !reflect.DeepEqual(rerun, links) {
+			t.Errorf("%v: game-links changed when the stages ran again", name)
+		}
+	}
+}
@@ -1,22 +1,130 @@
 package main
 
+import "time"
+
 type StoreEntryDTO struct {
-	ID   int    `bson:"_id,omitempty"`
-	Name string `bson:"title,omitempty"`
+	ID         int            `bson:"_id,omitempty" json:"appid"`
+	Name       string         `bson:"title,omitempty" json:"name"`
+	Genres     []string       `bson:"genres,omitempty" json:"genres,omitempty"`
+	Community  *int           `bson:"community,omitempty" json:"community,omitempty"`
+	Centrality *CentralityDTO `bson:"centrality,omitempty" json:"centrality,omitempty"`
+}
+
+type CentralityDTO struct {
+	PageRank       float64 `bson:"pagerank" json:"pagerank"`
+	WeightedDegree float64 `bson:"weightedDegree" json:"weightedDegree"`
+	Betweenness    float64 `bson:"betweenness" json:"betweenness"`
+	Clustering     float64 `bson:"clustering" json:"clustering"`
 }
 
 type GameReviewDTO struct {
 	AppId int      `bson:"_id,omitempty"`
 	Users []string `bson:"users,omitempty"`
+	// Playtimes at review in minutes, aligned with Users
+	Playtimes []int `bson:"playtimes,omitempty"`
 	//Reviews []string `bson:"reviews,omitempty"`
 }
 
 type UserLinkDTO struct {
 	UserId        string `bson:"_id,omitempty"`
 	GamesReviewed []int  `bson:"gamesReviewed,omitempty"`
+	// Playtimes at review in minutes, aligned with GamesReviewed
+	Playtimes []int `bson:"playtimes,omitempty"`
 }
 
 type GameLinkDTO struct {
 	GameId       int              `bson:"_id,omitempty"`
 	SimilarGames []GameSimilarity `bson:"similarGames,omitempty"`
+	// Neighbours estimated with MinHash/LSH
+	ApproxSimilarGames []GameSimilarity `bson:"approxSimilarGames,omitempty"`
+}
+
+type UserFactorsDTO struct {
+	UserId  string    `bson:"_id"`
+	Factors []float64 `bson:"factors"`
+}
+
+type GameFactorsDTO struct {
+	GameId  int       `bson:"_id"`
+	Factors []float64 `bson:"factors"`
+}
+
+type ALSModelDTO struct {
+	ID             string    `bson:"_id"`
+	Factors        int       `bson:"factors"`
+	Iterations     int       `bson:"iterations"`
+	Regularization float64   `bson:"regularization"`
+	Alpha          float64   `bson:"alpha"`
+	Seed           int64     `bson:"seed"`
+	Users          int       `bson:"users"`
+	Games          int       `bson:"games"`
+	TrainedAt      time.Time `bson:"trainedAt"`
+}
+
+type MinHashSignatureDTO struct {
+	GameId    int     `bson:"_id"`
+	Signature []int64 `bson:"signature"`
+	Reviewers int     `bson:"reviewers"`
+}
+
+type CommunityGame struct {
+	GameId  int    `bson:"appid" json:"appid"`
+	Name    string `bson:"name" json:"name"`
+	Reviews int    `bson:"reviews" json:"reviews"`
+}
+
+type GenreCount struct {
+	Genre string `bson:"genre" json:"genre"`
+	Count int    `bson:"count" json:"count"`
+}
+
+type CommunityDTO struct {
+	ID             int             `bson:"_id" json:"id"`
+	Size           int             `bson:"size" json:"size"`
+	TopGames       []CommunityGame `bson:"topGames" json:"topGames"`
+	DominantGenres []GenreCount    `bson:"dominantGenres" json:"dominantGenres"`
+}
+
+// PlantedCommunityDTO is the cluster a synthetic game was generated in.
+type PlantedCommunityDTO struct {
+	GameId    int `bson:"_id"`
+	Community int `bson:"community"`
+}
+
+type GraphDTO struct {
+	Key              string    `bson:"_id"`
+	Seed             int       `bson:"seed"`
+	Depth            int       `bson:"depth"`
+	Fanout           []int     `bson:"fanout"`
+	LinkNeighbors    int       `bson:"linkNeighbors"`
+	AlgorithmVersion int       `bson:"algorithmVersion"`
+	CreatedAt        time.Time `bson:"createdAt"`
+	Graph            Graph     `bson:"graph"`
+}
+
+type CollectionMetadataDTO struct {
+	Collection string    `bson:"_id"`
+	UpdatedAt  time.Time `bson:"updatedAt"`
+}
+
+const stageRunning = "running"
+const stageFinished = "finished"
+
+// StageStatus is the progress of the latest run of a pipeline stage.
+type StageStatus struct {
+	Stage               string     `bson:"_id" json:"stage"`
+	State               string     `bson:"state" json:"state"`
+	Total               int        `bson:"total" json:"total"`
+	Processed           int        `bson:"processed" json:"processed"`
+	Skipped             int        `bson:"skipped" json:"skipped"`
+	Failed              int        `bson:"failed" json:"failed"`
+	Retries             int        `bson:"retries" json:"retries"`
+	Remaining           int        `bson:"remaining" json:"remaining"`
+	Percent             float64    `bson:"percent" json:"percent"`
+	RatePerMinute       float64    `bson:"ratePerMinute" json:"ratePerMinute"`
+	ETASeconds          *float64   `bson:"etaSeconds,omitempty" json:"etaSeconds,omitempty"`
+	EstimatedCompletion *time.Time `bson:"estimatedCompletion,omitempty" json:"estimatedCompletion,omitempty"`
+	StartedAt           time.Time  `bson:"startedAt" json:"startedAt"`
+	UpdatedAt           time.Time  `bson:"updatedAt" json:"updatedAt"`
+	FinishedAt          *time.Time `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
 }
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+type evaluationParams struct {
+	algorithm        string
+	holdout          float64
+	k                int
+	users            int
+	neighborsPerSeed int
+	seed             int64
+	als              alsParams
+}
+
+// heldOutUser is a user whose reviewed games were split for evaluation.
+type heldOutUser struct {
+	train []int
+	test  []int
+}
+
+// evaluate hides a fraction of every user's reviewed games, trains the
+// selected algorithm on what is left and scores its top K against the
+// hidden games.
+func evaluate(params evaluationParams) EvaluationReport {
+	defer timeTrack(time.Now(), "evaluate")
+
+	random := rand.New(rand.NewSource(params.seed))
+
+	slog.Info("loading user links", "stage", "evaluate")
+	var trainLinks []UserLinkDTO
+	var candidates []heldOutUser
+
+	cursor := database.findAllUserLinks()
+	for cursor.Next(context.TODO()) {
+		var userLink UserLinkDTO
+		err := cursor.Decode(&userLink)
+		check(err)
+
+		train, test := holdOut(userLink, params.holdout, random)
+		trainLinks = append(trainLinks, train)
+		if len(test.GamesReviewed) > 0 {
+			candidates = append(candidates, heldOutUser{train: train.GamesReviewed, test: test.GamesReviewed})
+		}
+	}
+
+	random.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if params.users > 0 && len(candidates) > params.users {
+		candidates = candidates[:params.users]
+	}
+	slog.Info("evaluating users", "stage", "evaluate", "users", len(candidates), "total", len(trainLinks))
+
+	popularity := make(map[int]int)
+	for _, link := range trainLinks {
+		for _, gameId := range link.GamesReviewed {
+			popularity[gameId]++
+		}
+	}
+
+	recommend := trainRecommender(params, trainLinks, popularity)
+
+	return scoreRecommendations(params, candidates, popularity, len(trainLinks), recommend)
+}
+
+// holdOut splits a user's reviews, always keeping at least one game to train on.
+func holdOut(link UserLinkDTO, fraction float64, random *rand.Rand) (UserLinkDTO, UserLinkDTO) {
+	playtimes := alignPlaytimes(link)
+	order := random.Perm(len(link.GamesReviewed))
+
+	testCount := int(math.Round(fraction * float64(len(order))))
+	if testCount >= len(order) {
+		testCount = len(order) - 1
+	}
+
+	train := UserLinkDTO{UserId: link.UserId}
+	test := UserLinkDTO{UserId: link.UserId}
+	for n, i := range order {
+		if n < testCount {
+			test.GamesReviewed = append(test.GamesReviewed, link.GamesReviewed[i])
+			test.Playtimes = append(test.Playtimes, playtimes[i])
+		} else {
+			train.GamesReviewed = append(train.GamesReviewed, link.GamesReviewed[i])
+			train.Playtimes = append(train.Playtimes, playtimes[i])
+		}
+	}
+	return train, test
+}
+
+func trainRecommender(params evaluationParams, trainLinks []UserLinkDTO, popularity map[int]int) func(seeds []int) []int {
+	switch params.algorithm {
+	case "itemcf":
+		neighbors := cooccurrenceNeighbors(trainLinks)
+		return func(seeds []int) []int {
+			return recommendationIds(rankNeighbors(seeds, neighbors, params.neighborsPerSeed, params.k))
+		}
+
+	case "als":
+		gameIndex := make(map[int]int)
+		var gameIds []int
+		rows := make([][]int, len(trainLinks))
+		for u, link := range trainLinks {
+			for _, gameId := range link.GamesReviewed {
+				index, ok := gameIndex[gameId]
+				if !ok {
+					index = len(gameIds)
+					gameIndex[gameId] = index
+					gameIds = append(gameIds, gameId)
+				}
+				rows[u] = append(rows[u], index)
+			}
+		}
+
+		_, factors := trainALS(rows, len(gameIds), params.als)
+		gameFactors := make(map[int][]float64)
+		for i, gameId := range gameIds {
+			gameFactors[gameId] = factors[i]
+		}
+
+		// The user factors of a held out user were fitted on their training
+		// games only, so they are solved again the same way ALS would.
+		gramian := gramianOf(factors, params.als.factors)
+		return func(seeds []int) []int {
+			var observed []int
+			for _, seed := range seeds {
+				observed = append(observed, gameIndex[seed])
+			}
+			userFactors := alsSolve(gramian, factors, observed, params.als, newMatrix(params.als.factors), make([]float64, params.als.factors))
+			return recommendationIds(rankFactors(userFactors, seeds, gameFactors, params.k))
+		}
+
+	case "popularity":
+		var ranked []int
+		for gameId := range popularity {
+			ranked = append(ranked, gameId)
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			if popularity[ranked[i]] != popularity[ranked[j]] {
+				return popularity[ranked[i]] > popularity[ranked[j]]
+			}
+			return ranked[i] < ranked[j]
+		})
+		return func(seeds []int) []int {
+			reviewed := make(map[int]bool)
+			for _, seed := range seeds {
+				reviewed[seed] = true
+			}
+			var ids []int
+			for _, gameId := range ranked {
+				if len(ids) == params.k {
+					break
+				}
+				if !reviewed[gameId] {
+					ids = append(ids, gameId)
+				}
+			}
+			return ids
+		}
+	}
+
+	fatal("unknown algorithm", "algorithm", params.algorithm)
+	return nil
+}
+
+// cooccurrenceNeighbors ranks what findSimilarGames would store in
+// game-links for every game, from the given links only.
+func cooccurrenceNeighbors(links []UserLinkDTO) map[int][]GameSimilarity {
+	defer timeTrack(time.Now(), "cooccurrenceNeighbors")
+
+	reviewerLinks := make(map[int][]UserLinkDTO)
+	for _, link := range links {
+		for _, gameId := range link.GamesReviewed {
+			reviewerLinks[gameId] = append(reviewerLinks[gameId], link)
+		}
+	}
+
+	neighbors := make(map[int][]GameSimilarity)
+	for gameId, gameLinks := range reviewerLinks {
+		neighbors[gameId] = rankSimilarGames(gameId, gameLinks)
+	}
+	return neighbors
+}
+
+func recommendationIds(recommendations []Recommendation) []int {
+	var ids []int
+	for _, recommendation := range recommendations {
+		ids = append(ids, recommendation.GameId)
+	}
+	return ids
+}
+
+func scoreRecommendations(params evaluationParams, users []heldOutUser, popularity map[int]int, trainUsers int, recommend func(seeds []int) []int) EvaluationReport {
+	var precision, recall, averagePrecision, ndcg float64
+	var recommendedPopularity, heldOutPopularity float64
+	var recommendedCount, heldOutCount int
+	recommended := make(map[int]bool)
+
+	for n, user := range users {
+		ids := recommend(user.train)
+
+		relevant := make(map[int]bool)
+		for _, gameId := range user.test {
+			relevant[gameId] = true
+			heldOutPopularity += float64(popularity[gameId]) / float64(trainUsers)
+			heldOutCount++
+		}
+
+		var hits int
+		var precisionSum, dcg, idcg float64
+		for rank, gameId := range ids {
+			recommended[gameId] = true
+			recommendedPopularity += float64(popularity[gameId]) / float64(trainUsers)
+			recommendedCount++
+
+			if relevant[gameId] {
+				hits++
+				precisionSum += float64(hits) / float64(rank+1)
+				dcg += 1 / math.Log2(float64(rank+2))
+			}
+		}
+		for rank := 0; rank < len(user.test) && rank < params.k; rank++ {
+			idcg += 1 / math.Log2(float64(rank+2))
+		}
+
+		precision += float64(hits) / float64(params.k)
+		recall += float64(hits) / float64(len(user.test))
+		averagePrecision += precisionSum / math.Min(float64(len(user.test)), float64(params.k))
+		ndcg += dcg / idcg
+
+		if (n+1)%1000 == 0 {
+			slog.Info("evaluated users", "stage", "evaluate", "users", n+1, "total", len(users))
+		}
+	}
+
+	report := EvaluationReport{
+		Algorithm: params.algorithm,
+		Weighting: weighting.name(),
+		K:         params.k,
+		Holdout:   params.holdout,
+		Users:     len(users),
+		Seed:      params.seed,
+		CreatedAt: time.Now(),
+	}
+	if params.algorithm == "als" {
+		report.ALS = &ALSParamsReport{
+			Factors:        params.als.factors,
+			Iterations:     params.als.iterations,
+			Regularization: params.als.regularization,
+			Alpha:          params.als.alpha,
+		}
+	}
+	if len(users) == 0 {
+		return report
+	}
+
+	count := float64(len(users))
+	report.PrecisionAtK = precision / count
+	report.RecallAtK = recall / count
+	report.MAP = averagePrecision / count
+	report.NDCG = ndcg / count
+	report.CatalogCoverage = float64(len(recommended)) / float64(len(popularity))
+	if recommendedCount > 0 && heldOutCount > 0 {
+		report.AveragePopularity = recommendedPopularity / float64(recommendedCount)
+		report.PopularityBias = report.AveragePopularity / (heldOutPopularity / float64(heldOutCount))
+	}
+	return report
+}
+
+func writeEvaluationReport(report EvaluationReport, path string) {
+	if path == "" {
+		path = fmt.Sprintf("evaluation-%v-%v.json", report.Algorithm, report.CreatedAt.Format("20060102-150405"))
+	}
+
+	file, err := json.MarshalIndent(report, "", " ")
+	check(err)
+
+	err = ioutil.WriteFile(path, file, 0644)
+	check(err)
+
+	slog.Info("wrote evaluation report", "stage", "evaluate", "path", path)
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Collections are exported as flat tables. Arrays are exploded into one row
+// per element, so game-reviews has a row per app and reviewer.
+
+const (
+	exportInt    = "int"
+	exportFloat  = "float"
+	exportString = "string"
+)
+
+type exportColumn struct {
+	name     string
+	kind     string
+	nullable bool
+}
+
+type exportTable struct {
+	name    string
+	columns []exportColumn
+	// rows streams the table, values are int64, float64, string or nil
+	rows func(emit func(row []interface{}) error) error
+}
+
+type rowWriter interface {
+	write(row []interface{}) error
+	close() error
+}
+
+type exportFormat struct {
+	extension string
+	open      func(w io.Writer, columns []exportColumn, compression string) (rowWriter, error)
+	// Formats that compress internally get the compression, the others are
+	// compressed as a whole
+	compressesItself bool
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":     {"csv", newCSVRowWriter, false},
+	"jsonl":   {"jsonl", newJSONLRowWriter, false},
+	"parquet": {"parquet", newParquetWriter, true},
+}
+
+var exportCompressions = []string{"none", "gzip"}
+
+func isExportCompression(compression string) bool {
+	for _, c := range exportCompressions {
+		if c == compression {
+			return true
+		}
+	}
+	return false
+}
+
+var exportTables = []exportTable{
+	{
+		name: storeEntriesCollection,
+		columns: []exportColumn{
+			{"appid", exportInt, false},
+			{"name", exportString, false},
+		},
+		rows: func(emit func([]interface{}) error) error {
+			return exportCursor(database.findStoreEntries(), func(decode func(interface{}) error) error {
+				var entry StoreEntryDTO
+				if err := decode(&entry); err != nil {
+					return err
+				}
+				return emit([]interface{}{int64(entry.ID), entry.Name})
+			})
+		},
+	},
+	{
+		name: gamesCollectionName,
+		columns: []exportColumn{
+			{"appid", exportInt, false},
+			{"name", exportString, false},
+			{"genres", exportString, false},
+			{"community", exportInt, true},
+			{"pagerank", exportFloat, true},
+			{"weighted_degree", exportFloat, true},
+			{"betweenness", exportFloat, true},
+			{"clustering", exportFloat, true},
+		},
+		rows: func(emit func([]interface{}) error) error {
+			return exportCursor(database.findGames(), func(decode func(interface{}) error) error {
+				var game StoreEntryDTO
+				if err := decode(&game); err != nil {
+					return err
+				}
+				row := []interface{}{int64(game.ID), game.Name, strings.Join(game.Genres, "|"), nil, nil, nil, nil, nil}
+				if game.Community != nil {
+					row[3] = int64(*game.Community)
+				}
+				if c := game.Centrality; c != nil {
+					row[4], row[5], row[6], row[7] = c.PageRank, c.WeightedDegree, c.Betweenness, c.Clustering
+				}
+				return emit(row)
+			})
+		},
+	},
+	{
+		name: gameReviewsCollection,
+		columns: []exportColumn{
+			{"appid", exportInt, false},
+			{"steamid", exportString, false},
+			{"playtime", exportInt, false},
+		},
+		rows: func(emit func([]interface{}) error) error {
+			return exportCursor(database.findGameReviews(), func(decode func(interface{}) error) error {
+				var review GameReviewDTO
+				if err := decode(&review); err != nil {
+					return err
+				}
+				for i, userId := range review.Users {
+					if err := emit([]interface{}{int64(review.AppId), userId, int64(playtimeAt(review.Playtimes, i))}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	},
+	{
+		name: userLinksCollection,
+		columns: []exportColumn{
+			{"steamid", exportString, false},
+			{"appid", exportInt, false},
+			{"playtime", exportInt, false},
+		},
+		rows: func(emit func([]interface{}) error) error {
+			return exportCursor(database.findAllUserLinks(), func(decode func(interface{}) error) error {
+				var link UserLinkDTO
+				if err := decode(&link); err != nil {
+					return err
+				}
+				for i, gameId := range link.GamesReviewed {
+					if err := emit([]interface{}{link.UserId, int64(gameId), int64(playtimeAt(link.Playtimes, i))}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	},
+	{
+		name: gameLinksCollection,
+		columns: []exportColumn{
+			{"appid", exportInt, false},
+			{"similar_appid", exportInt, false},
+			// exact from co-reviews, or minhash for the LSH estimate
+			{"method", exportString, false},
+			{"rank", exportInt, false},
+			{"count", exportInt, false},
+			{"score", exportFloat, false},
+			{"weighting", exportString, false},
+			{"estimated_jaccard", exportFloat, true},
+		},
+		rows: func(emit func([]interface{}) error) error {
+			return exportCursor(database.findAllGameLinks(), func(decode func(interface{}) error) error {
+				var link GameLinkDTO
+				if err := decode(&link); err != nil {
+					return err
+				}
+				methods := []struct {
+					name         string
+					similarGames []GameSimilarity
+				}{
+					{"exact", link.SimilarGames},
+					{"minhash", link.ApproxSimilarGames},
+				}
+				for _, method := range methods {
+					for i, similarGame := range method.similarGames {
+						row := []interface{}{
+							int64(link.GameId), int64(similarGame.GameId), method.name, int64(i + 1),
+							int64(similarGame.Count), similarityScore(similarGame), similarGame.Weighting, nil,
+						}
+						if method.name == "minhash" {
+							row[7] = similarGame.EstimatedJaccard
+						}
+						if err := emit(row); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			})
+		},
+	},
+}
+
+// exportCursor calls each for every document of the cursor, with a function
+// decoding the document.
+func exportCursor(cursor *mongo.Cursor, each func(decode func(interface{}) error) error) error {
+	defer cursor.Close(context.TODO())
+	decode := func(value interface{}) error {
+		return cursor.Decode(value)
+	}
+	for cursor.Next(context.TODO()) {
+		if err := each(decode); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func findExportTable(name string) (exportTable, bool) {
+	for _, table := range exportTables {
+		if table.name == name {
+			return table, true
+		}
+	}
+	return exportTable{}, false
+}
+
+func exportTableNames() []string {
+	var names []string
+	for _, table := range exportTables {
+		names = append(names, table.name)
+	}
+	return names
+}
+
+func exportFormatNames() []string {
+	var names []string
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectColumns returns the indexes of the named columns, or of every
+// column when names is empty.
+func selectColumns(table exportTable, names []string) ([]int, error) {
+	if len(names) == 0 {
+		var all []int
+		for i := range table.columns {
+			all = append(all, i)
+		}
+		return all, nil
+	}
+
+	var indexes []int
+	for _, name := range names {
+		found := false
+		for i, column := range table.columns {
+			if column.name == name {
+				indexes = append(indexes, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			var available []string
+			for _, column := range table.columns {
+				available = append(available, column.name)
+			}
+			return nil, fmt.Errorf("%v has no column %q, only %v", table.name, name, strings.Join(available, ", "))
+		}
+	}
+	return indexes, nil
+}
+
+// exportTo streams the selected columns of the table to w and returns the
+// number of rows written.
+func exportTo(w io.Writer, table exportTable, columns []int, format exportFormat, compression string) (int64, error) {
+	selected := make([]exportColumn, len(columns))
+	for i, column := range columns {
+		selected[i] = table.columns[column]
+	}
+
+	var compressor *gzip.Writer
+	if compression == "gzip" && !format.compressesItself {
+		compressor = gzip.NewWriter(w)
+		w = compressor
+	}
+
+	writer, err := format.open(w, selected, compression)
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int64
+	row := make([]interface{}, len(columns))
+	err = table.rows(func(values []interface{}) error {
+		for i, column := range columns {
+			row[i] = values[column]
+		}
+		rows++
+		return writer.write(row)
+	})
+	if err != nil {
+		return rows, err
+	}
+	if err := writer.close(); err != nil {
+		return rows, err
+	}
+	if compressor != nil {
+		return rows, compressor.Close()
+	}
+	return rows, nil
+}
+
+func exportFileName(dir string, table string, format exportFormat, compression string) string {
+	name := table + "." + format.extension
+	if compression == "gzip" && !format.compressesItself {
+		name += ".gz"
+	}
+	return filepath.Join(dir, name)
+}
+
+func exportCollections(tables []string, dir string, formatName string, compression string, columnNames []string) {
+	defer timeTrack(time.Now(), "exportCollections")
+
+	format := exportFormats[formatName]
+	err := os.MkdirAll(dir, 0755)
+	check(err)
+
+	for _, name := range tables {
+		table, _ := findExportTable(name)
+		columns, err := selectColumns(table, columnNames)
+		if err != nil {
+			fatal("invalid columns", "error", err)
+		}
+
+		path := exportFileName(dir, name, format, compression)
+		file, err := os.Create(path)
+		check(err)
+
+		rows, err := exportTo(file, table, columns, format, compression)
+		if err != nil {
+			file.Close()
+			fatal("export failed", "stage", "export", "collection", name, "error", err)
+		}
+		check(file.Close())
+		slog.Info("exported collection", "stage", "export", "collection", name, "rows", rows, "path", path)
+	}
+}
+
+type csvRowWriter struct {
+	w      *csv.Writer
+	fields []string
+}
+
+func newCSVRowWriter(w io.Writer, columns []exportColumn, compression string) (rowWriter, error) {
+	writer := &csvRowWriter{w: csv.NewWriter(w), fields: make([]string, len(columns))}
+	for i, column := range columns {
+		writer.fields[i] = column.name
+	}
+	return writer, writer.w.Write(writer.fields)
+}
+
+// write leaves nulls empty.
+func (c *csvRowWriter) write(row []interface{}) error {
+	for i, value := range row {
+		switch v := value.(type) {
+		case nil:
+			c.fields[i] = ""
+		case int64:
+			c.fields[i] = strconv.FormatInt(v, 10)
+		case float64:
+			c.fields[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		case string:
+			c.fields[i] = v
+		default:
+			return fmt.Errorf("can't write %T as csv", value)
+		}
+	}
+	return c.w.Write(c.fields)
+}
+
+func (c *csvRowWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlRowWriter writes one object per row with keys in column order.
+type jsonlRowWriter struct {
+	w    *bufio.Writer
+	keys [][]byte
+}
+
+func newJSONLRowWriter(w io.Writer, columns []exportColumn, compression string) (rowWriter, error) {
+	writer := &jsonlRowWriter{w: bufio.NewWriter(w)}
+	for _, column := range columns {
+		key, err := json.Marshal(column.name)
+		if err != nil {
+			return nil, err
+		}
+		writer.keys = append(writer.keys, key)
+	}
+	return writer, nil
+}
+
+func (j *jsonlRowWriter) write(row []interface{}) error {
+	j.w.WriteByte('{')
+	for i, value := range row {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		j.w.Write(j.keys[i])
+		j.w.WriteByte(':')
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		j.w.Write(encoded)
+	}
+	j.w.WriteByte('}')
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlRowWriter) close() error {
+	return j.w.Flush()
+}
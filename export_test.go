@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testExportTable = exportTable{
+	name: "test",
+	columns: []exportColumn{
+		{"appid", exportInt, false},
+		{"name", exportString, false},
+		{"score", exportFloat, true},
+	},
+	rows: func(emit func([]interface{}) error) error {
+		rows := [][]interface{}{
+			{int64(10), "Counter-Strike", 0.5},
+			{int64(440), "Team, \"Fortress\" 2", nil},
+			{int64(-3), "", 1e-9},
+			{int64(1 << 40), "Ünïcode", nil},
+			{int64(570), "Dota 2", math.Inf(1)},
+		}
+		for _, row := range rows {
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func exportBytes(t *testing.T, table exportTable, columns []string, format string, compression string) []byte {
+	t.Helper()
+	indexes, err := selectColumns(table, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := exportTo(&out, table, indexes, exportFormats[format], compression); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestExportCSV(t *testing.T) {
+	table := testExportTable
+	table.rows = func(emit func([]interface{}) error) error {
+		return testExportTable.rows(func(row []interface{}) error {
+			if row[2] != nil && math.IsInf(row[2].(float64), 0) {
+				return nil
+			}
+			return emit(row)
+		})
+	}
+
+	got := string(exportBytes(t, table, nil, "csv", "none"))
+	want := "appid,name,score\n" +
+		"10,Counter-Strike,0.5\n" +
+		"440,\"Team, \"\"Fortress\"\" 2\",\n" +
+		"-3,,1e-09\n" +
+		"1099511627776,Ünïcode,\n"
+	if got != want {
+		t.Errorf("csv export = %q, want %q", got, want)
+	}
+
+	got = string(exportBytes(t, table, []string{"name", "appid"}, "csv", "none"))
+	if want := "name,appid\nCounter-Strike,10\n"; got[:len(want)] != want {
+		t.Errorf("csv export of name and appid starts %q, want %q", got, want)
+	}
+}
+
+func TestExportJSONLCompressed(t *testing.T) {
+	table := testExportTable
+	table.rows = func(emit func([]interface{}) error) error {
+		return emit([]interface{}{int64(440), "Team Fortress 2", nil})
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(exportBytes(t, table, nil, "jsonl", "gzip")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"appid":440,"name":"Team Fortress 2","score":null}` + "\n"; string(got) != want {
+		t.Errorf("jsonl export = %q, want %q", got, want)
+	}
+}
+
+func TestSelectColumnsRejectsUnknownColumns(t *testing.T) {
+	if _, err := selectColumns(testExportTable, []string{"appid", "steamid"}); err == nil {
+		t.Error("selectColumns with an unknown column succeeded, want an error")
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	size := parquetRowGroupSize
+	parquetRowGroupSize = 2
+	defer func() { parquetRowGroupSize = size }()
+
+	var want [][]interface{}
+	testExportTable.rows(func(row []interface{}) error {
+		want = append(want, append([]interface{}{}, row...))
+		return nil
+	})
+
+	for _, compression := range []string{"none", "gzip"} {
+		data := exportBytes(t, testExportTable, nil, "parquet", compression)
+		names, rows, groups := readTestParquet(t, data)
+
+		if !reflect.DeepEqual(names, []string{"appid", "name", "score"}) {
+			t.Errorf("%v: parquet schema = %v", compression, names)
+		}
+		if groups != 3 {
+			t.Errorf("%v: parquet has %v row groups, want 3", compression, groups)
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("%v: parquet rows = %v, want %v", compression, rows, want)
+		}
+	}
+
+	// Column selection applies to the schema too
+	names, rows, _ := readTestParquet(t, exportBytes(t, testExportTable, []string{"score"}, "parquet", "none"))
+	if !reflect.DeepEqual(names, []string{"score"}) || len(rows) != len(want) || rows[1][0] != nil {
+		t.Errorf("parquet of score = %v %v", names, rows)
+	}
+}
+
+// The golden files were checked to open with an independent Parquet reader,
+// parquet-go v0.23.0, when they were written, so that a mistake shared by the
+// writer and readTestParquet can't pass. A change to the writer's output
+// needs the files rechecked the same way.
+func TestExportParquetMatchesGoldenFiles(t *testing.T) {
+	size := parquetRowGroupSize
+	parquetRowGroupSize = 2
+	defer func() { parquetRowGroupSize = size }()
+
+	golden := map[string]string{
+		"none": "test.parquet",
+		"gzip": "test.gzip.parquet",
+	}
+	for compression, name := range golden {
+		want, err := ioutil.ReadFile(filepath.Join("testdata", "export", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data := exportBytes(t, testExportTable, nil, "parquet", compression); !bytes.Equal(data, want) {
+			t.Errorf("%v: parquet differs from testdata/export/%v", compression, name)
+		}
+	}
+}
+
+// readTestParquet decodes the files the writer produces, following the
+// footer to every page, and returns the column names, rows and number of
+// row groups.
+func readTestParquet(t *testing.T, data []byte) ([]string, [][]interface{}, int) {
+	t.Helper()
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatal("missing parquet magic")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := &testThriftReader{data: data[len(data)-8-footerLength : len(data)-8]}
+	meta := footer.readStruct()
+	if footer.pos != len(footer.data) {
+		t.Fatalf("footer has %v trailing bytes", len(footer.data)-footer.pos)
+	}
+
+	var names []string
+	var types, repetitions []int64
+	for _, element := range meta[2].([]interface{})[1:] {
+		fields := element.(map[int16]interface{})
+		names = append(names, fields[4].(string))
+		types = append(types, fields[1].(int64))
+		repetitions = append(repetitions, fields[3].(int64))
+	}
+
+	var rows [][]interface{}
+	groups := meta[4].([]interface{})
+	for _, group := range groups {
+		groupFields := group.(map[int16]interface{})
+		groupRows := int(groupFields[3].(int64))
+		start := len(rows)
+		for i := 0; i < groupRows; i++ {
+			rows = append(rows, make([]interface{}, len(names)))
+		}
+
+		for c, chunk := range groupFields[1].([]interface{}) {
+			columnMeta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			page := &testThriftReader{data: data, pos: int(columnMeta[9].(int64))}
+			header := page.readStruct()
+			values := data[page.pos : page.pos+int(header[3].(int64))]
+			if columnMeta[4].(int64) == parquetCodecGzip {
+				reader, err := gzip.NewReader(bytes.NewReader(values))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if values, err = ioutil.ReadAll(reader); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(values) != int(header[2].(int64)) {
+				t.Fatalf("page of %v has %v bytes, header says %v", names[c], len(values), header[2])
+			}
+
+			defined := make([]bool, groupRows)
+			for i := range defined {
+				defined[i] = true
+			}
+			if repetitions[c] == parquetOptional {
+				length := int(binary.LittleEndian.Uint32(values))
+				levels := &testThriftReader{data: values[4 : 4+length]}
+				for i := 0; levels.pos < len(levels.data); {
+					run := int(levels.uvarint() >> 1)
+					level := levels.byte()
+					for ; run > 0; run-- {
+						defined[i] = level == 1
+						i++
+					}
+				}
+				values = values[4+length:]
+			}
+
+			for i := 0; i < groupRows; i++ {
+				if !defined[i] {
+					continue
+				}
+				switch types[c] {
+				case parquetInt64:
+					rows[start+i][c] = int64(binary.LittleEndian.Uint64(values))
+					values = values[8:]
+				case parquetDouble:
+					rows[start+i][c] = math.Float64frombits(binary.LittleEndian.Uint64(values))
+					values = values[8:]
+				case parquetByteArray:
+					n := int(binary.LittleEndian.Uint32(values))
+					rows[start+i][c] = string(values[4 : 4+n])
+					values = values[4+n:]
+				}
+			}
+			if len(values) != 0 {
+				t.Fatalf("page of %v has %v bytes left over", names[c], len(values))
+			}
+		}
+	}
+	if int(meta[3].(int64)) != len(rows) {
+		t.Errorf("footer says %v rows, row groups hold %v", meta[3], len(rows))
+	}
+	return names, rows, len(groups)
+}
+
+// testThriftReader decodes the Thrift compact protocol into maps of field
+// ids to values.
+type testThriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *testThriftReader) byte() byte {
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *testThriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *testThriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *testThriftReader) value(kind byte) interface{} {
+	switch kind {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, 5, 6:
+		return r.zigzag()
+	case 7:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+		return v
+	case 8:
+		n := int(r.uvarint())
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case 9, 10:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		var list []interface{}
+		for i := 0; i < size; i++ {
+			list = append(list, r.value(header&0x0f))
+		}
+		return list
+	case 12:
+		return r.readStruct()
+	}
+	panic("unsupported thrift type")
+}
+
+func (r *testThriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		last = id
+		fields[id] = r.value(header & 0x0f)
+	}
+}
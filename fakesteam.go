@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fake-steam serves synthetic versions of the three Steam endpoints the
+// crawler uses, so the pipeline can run offline against a known dataset.
+
+var fakeSteamGenres = []EntryGenre{
+	{"1", "Action"},
+	{"2", "Strategy"},
+	{"3", "RPG"},
+	{"9", "Racing"},
+	{"18", "Sports"},
+	{"23", "Indie"},
+	{"25", "Adventure"},
+	{"28", "Simulation"},
+}
+
+// Share of a game's reviewers drawn from players of its primary genre, the
+// rest come from everyone. This is what gives game-links some structure.
+const fakeSteamGenreAffinity = 0.8
+
+type fakeSteamParams struct {
+	apps int
+	// gameShare is the fraction of apps that are games, the rest are dlc
+	gameShare float64
+	reviewers int
+	// Reviews per game are drawn between minReviews and maxReviews, either
+	// uniformly or following a zipf curve over a random ranking of games
+	minReviews   int
+	maxReviews   int
+	distribution string
+	exponent     float64
+	pageSize     int
+	// rateLimit is the probability of answering appdetails and appreviews
+	// with a 429
+	rateLimit float64
+	latency   time.Duration
+	seed      int64
+}
+
+var defaultFakeSteamParams = fakeSteamParams{
+	apps:         200,
+	gameShare:    0.7,
+	reviewers:    50000,
+	minReviews:   500,
+	maxReviews:   20000,
+	distribution: "zipf",
+	exponent:     0.8,
+	pageSize:     100,
+	seed:         1,
+}
+
+type fakeApp struct {
+	id      int
+	name    string
+	kind    string
+	genres  []EntryGenre
+	reviews int
+}
+
+type fakeSteam struct {
+	params fakeSteamParams
+	apps   []fakeApp
+	byId   map[int]int
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	reviews map[int][]GameReview
+}
+
+func newFakeSteam(params fakeSteamParams) (*fakeSteam, error) {
+	if params.apps < 0 || params.reviewers < 1 || params.pageSize < 1 {
+		return nil, fmt.Errorf("apps, reviewers and page size must be positive")
+	}
+	if params.minReviews < 0 || params.maxReviews < params.minReviews {
+		return nil, fmt.Errorf("reviews must satisfy 0 <= min <= max")
+	}
+	if params.distribution != "zipf" && params.distribution != "uniform" {
+		return nil, fmt.Errorf("unknown review distribution %q", params.distribution)
+	}
+
+	s := &fakeSteam{
+		params:  params,
+		byId:    make(map[int]int),
+		rand:    rand.New(rand.NewSource(params.seed)),
+		reviews: make(map[int][]GameReview),
+	}
+
+	rng := rand.New(rand.NewSource(params.seed))
+	ranks := rng.Perm(params.apps)
+	for i := 0; i < params.apps; i++ {
+		app := fakeApp{id: 10 * (i + 1)}
+		primary := fakeSteamGenres[i%len(fakeSteamGenres)]
+		app.genres = []EntryGenre{primary}
+		if rng.Float64() < 0.5 {
+			if second := fakeSteamGenres[rng.Intn(len(fakeSteamGenres))]; second != primary {
+				app.genres = append(app.genres, second)
+			}
+		}
+
+		if rng.Float64() < params.gameShare {
+			app.kind = "game"
+			app.name = fmt.Sprintf("%v Game %v", primary.Description, app.id)
+			app.reviews = s.reviewCount(ranks[i]+1, rng)
+		} else {
+			app.kind = "dlc"
+			app.name = fmt.Sprintf("%v Soundtrack %v", primary.Description, app.id)
+		}
+		s.byId[app.id] = len(s.apps)
+		s.apps = append(s.apps, app)
+	}
+	return s, nil
+}
+
+func (s *fakeSteam) reviewCount(rank int, rng *rand.Rand) int {
+	p := s.params
+	var n int
+	switch p.distribution {
+	case "uniform":
+		n = p.minReviews + rng.Intn(p.maxReviews-p.minReviews+1)
+	case "zipf":
+		n = p.minReviews + int(float64(p.maxReviews-p.minReviews)/math.Pow(float64(rank), p.exponent))
+	}
+	if n > p.reviewers {
+		n = p.reviewers
+	}
+	return n
+}
+
+func fakeSteamId(reviewer int) string {
+	return fmt.Sprintf("7656119%010d", reviewer+1)
+}
+
+// appReviews generates the reviews of a game on first use. Each game has its
+// own random source so the result doesn't depend on the order of requests.
+func (s *fakeSteam) appReviews(app fakeApp) []GameReview {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reviews, ok := s.reviews[app.id]; ok {
+		return reviews
+	}
+
+	rng := rand.New(rand.NewSource(s.params.seed*1000003 + int64(app.id)))
+	genre := s.byId[app.id] % len(fakeSteamGenres)
+
+	// Reviewers play the genre of their index, modulo the genre count
+	var fans, others []int
+	for _, reviewer := range rng.Perm(s.params.reviewers) {
+		if reviewer%len(fakeSteamGenres) == genre {
+			fans = append(fans, reviewer)
+		} else {
+			others = append(others, reviewer)
+		}
+	}
+
+	reviews := make([]GameReview, 0, app.reviews)
+	for len(reviews) < app.reviews {
+		var reviewer int
+		if len(fans) > 0 && (len(others) == 0 || rng.Float64() < fakeSteamGenreAffinity) {
+			reviewer, fans = fans[0], fans[1:]
+		} else {
+			reviewer, others = others[0], others[1:]
+		}
+		reviews = append(reviews, GameReview{
+			Author: ReviewAuthor{
+				SteamId:          fakeSteamId(reviewer),
+				PlaytimeAtReview: 1 + int(rng.ExpFloat64()*600),
+			},
+			Review: "synthetic review",
+		})
+	}
+	s.reviews[app.id] = reviews
+	return reviews
+}
+
+func (s *fakeSteam) rateLimited() bool {
+	if s.params.rateLimit <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rand.Float64() < s.params.rateLimit
+}
+
+func (s *fakeSteam) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.params.latency > 0 {
+		time.Sleep(s.params.latency)
+	}
+	slog.Debug("fake steam request", "url", r.URL.String())
+
+	switch {
+	case r.URL.Path == "/ISteamApps/GetAppList/v0002/":
+		s.serveAppList(w)
+	case r.URL.Path == "/api/appdetails":
+		if s.rateLimited() {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		s.serveAppDetails(w, r)
+	case strings.HasPrefix(r.URL.Path, "/appreviews/"):
+		if s.rateLimited() {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		s.serveAppReviews(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeSteam) serveAppList(w http.ResponseWriter) {
+	response := StoreEntriesResponse{}
+	response.AppList.Apps = []StoreEntry{}
+	for _, app := range s.apps {
+		response.AppList.Apps = append(response.AppList.Apps, StoreEntry{AppId: app.id, Name: app.name})
+	}
+	writeFakeSteamJSON(w, response)
+}
+
+func (s *fakeSteam) serveAppDetails(w http.ResponseWriter, r *http.Request) {
+	appids := r.URL.Query().Get("appids")
+	id, err := strconv.Atoi(appids)
+	i, ok := s.byId[id]
+	if err != nil || !ok {
+		writeFakeSteamJSON(w, map[string]interface{}{appids: map[string]bool{"success": false}})
+		return
+	}
+
+	app := s.apps[i]
+	writeFakeSteamJSON(w, map[string]interface{}{
+		appids: map[string]interface{}{
+			"success": true,
+			"data":    EntryDetailsData{Type: app.kind, Name: app.name, Genres: app.genres},
+		},
+	})
+}
+
+// serveAppReviews pages through a game's reviews. Cursors encode the offset
+// of the next page, and the page past the end returns no reviews and the
+// cursor it was asked for, which is how Steam signals the end.
+func (s *fakeSteam) serveAppReviews(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/appreviews/"))
+	i, ok := s.byId[id]
+	if err != nil || !ok || s.apps[i].kind != "game" {
+		writeFakeSteamJSON(w, map[string]interface{}{"success": 2})
+		return
+	}
+
+	query := r.URL.Query()
+	cursor := query.Get("cursor")
+	offset, ok := decodeFakeSteamCursor(cursor)
+	if !ok {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+	perPage := s.params.pageSize
+	if n, err := strconv.Atoi(query.Get("num_per_page")); err == nil && n > 0 && n < perPage {
+		perPage = n
+	}
+
+	reviews := s.appReviews(s.apps[i])
+	response := map[string]interface{}{"success": 1}
+	summary := map[string]int{}
+
+	page := []GameReview{}
+	next := cursor
+	if offset < len(reviews) {
+		end := offset + perPage
+		if end > len(reviews) {
+			end = len(reviews)
+		}
+		page = reviews[offset:end]
+		next = encodeFakeSteamCursor(end)
+	}
+	summary["num_reviews"] = len(page)
+	if cursor == "*" {
+		summary["total_reviews"] = len(reviews)
+	}
+	response["query_summary"] = summary
+	response["reviews"] = page
+	response["cursor"] = next
+	writeFakeSteamJSON(w, response)
+}
+
+// Cursors look like Steam's, base64 with the odd '+', '/' and '='.
+func encodeFakeSteamCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("fake:" + strconv.Itoa(offset)))
+}
+
+func decodeFakeSteamCursor(cursor string) (int, bool) {
+	if cursor == "*" {
+		return 0, true
+	}
+	data, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(data), "fake:") {
+		return 0, false
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(data), "fake:"))
+	return offset, err == nil && offset >= 0
+}
+
+func writeFakeSteamJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("writing fake steam response failed", "error", err)
+	}
+}
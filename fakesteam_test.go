@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Enough reviews per game for getReviews to crawl every game
+var testFakeSteamParams = fakeSteamParams{
+	apps:         16,
+	gameShare:    0.75,
+	reviewers:    3000,
+	minReviews:   2600,
+	maxReviews:   3000,
+	distribution: "uniform",
+	pageSize:     100,
+	seed:         7,
+}
+
+// useFakeSteam points the crawler at an in-process fake-steam server with
+// no delays between requests.
+func useFakeSteam(t *testing.T, params fakeSteamParams) *fakeSteam {
+	t.Helper()
+
+	fake, err := newFakeSteam(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(fake)
+
+	client := steamClient
+	urls := []string{SteamBaseUrl, steamEntryDetailsUrl, steamStoreEntriesUrl}
+	delays := []time.Duration{reviewPageDelay, reviewGameDelay, entryDetailsDelay, reviewErrorBackoff, entryDetailsBackoff}
+
+	steamClient = server.Client()
+	setSteamUrl(server.URL)
+	reviewPageDelay, reviewGameDelay, entryDetailsDelay, reviewErrorBackoff, entryDetailsBackoff = 0, 0, 0, 0, 0
+
+	t.Cleanup(func() {
+		server.Close()
+		steamClient = client
+		SteamBaseUrl, steamEntryDetailsUrl, steamStoreEntriesUrl = urls[0], urls[1], urls[2]
+		reviewPageDelay, reviewGameDelay, entryDetailsDelay, reviewErrorBackoff, entryDetailsBackoff = delays[0], delays[1], delays[2], delays[3], delays[4]
+	})
+	return fake
+}
+
+func TestFakeSteamServesTheCrawler(t *testing.T) {
+	fake := useFakeSteam(t, testFakeSteamParams)
+
+	entries := fetchStoreEntries()
+	if len(entries) != testFakeSteamParams.apps {
+		t.Fatalf("fetchStoreEntries() returned %v entries, want %v", len(entries), testFakeSteamParams.apps)
+	}
+
+	games := 0
+	for _, app := range fake.apps {
+		details, err := getStoreEntryDetails(app.id)
+		if err != nil {
+			t.Fatalf("getStoreEntryDetails(%v) failed: %v", app.id, err)
+		}
+		if details.Data.Type != app.kind || details.Data.Name != app.name || len(details.Data.Genres) == 0 {
+			t.Errorf("getStoreEntryDetails(%v) = %+v, want a %v named %q", app.id, details.Data, app.kind, app.name)
+		}
+		if app.kind != "game" {
+			continue
+		}
+		games++
+
+		review, err := getReviews(app.id)
+		if err != nil {
+			t.Fatalf("getReviews(%v) failed: %v", app.id, err)
+		}
+		users := make(map[string]bool)
+		for _, user := range review.Users {
+			users[user] = true
+		}
+		if len(review.Users) != app.reviews || len(users) != app.reviews || len(review.Playtimes) != app.reviews {
+			t.Errorf("getReviews(%v) returned %v reviews by %v users, want %v", app.id, len(review.Users), len(users), app.reviews)
+		}
+	}
+	if games == 0 {
+		t.Fatal("fake steam served no games")
+	}
+
+	if _, err := getStoreEntryDetails(5); err != nil {
+		t.Errorf("getStoreEntryDetails of an unknown app failed: %v", err)
+	}
+}
+
+func TestFakeSteamIsDeterministic(t *testing.T) {
+	first, err := newFakeSteam(testFakeSteamParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newFakeSteam(testFakeSteamParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first.apps, second.apps) {
+		t.Fatal("apps differ between servers with the same seed")
+	}
+
+	// Reviews don't depend on the order games are requested in
+	for i := len(first.apps) - 1; i >= 0; i-- {
+		first.appReviews(first.apps[i])
+	}
+	for _, app := range second.apps {
+		if !reflect.DeepEqual(second.appReviews(app), first.appReviews(app)) {
+			t.Errorf("reviews of %v differ between servers with the same seed", app.id)
+		}
+	}
+}
+
+func TestFakeSteamRateLimits(t *testing.T) {
+	params := testFakeSteamParams
+	params.rateLimit = 1
+	fake := useFakeSteam(t, params)
+
+	id := fake.apps[0].id
+	if _, err := getStoreEntryDetails(id); err == nil {
+		t.Errorf("getStoreEntryDetails(%v) succeeded, want the rate limit error", id)
+	}
+	if _, err := getReviews(id); err == nil {
+		t.Errorf("getReviews(%v) succeeded, want the rate limit error", id)
+	}
+
+	res, err := http.Get(steamStoreEntriesUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("app list returned %v, want it never rate limited", res.StatusCode)
+	}
+}
+
+// TestPipelineAgainstFakeSteam runs every stage from store-entries to the
+// graph in a scratch database. It needs DATABASE_URL to point at MongoDB.
+func TestPipelineAgainstFakeSteam(t *testing.T) {
+	useTestDatabase(t)
+	fake := useFakeSteam(t, testFakeSteamParams)
+
+	initStoreEntries()
+	filterGames()
+	processReviews()
+	processUserLinks()
+	populateGameSimilarities()
+
+	var games []int
+	for _, app := range fake.apps {
+		if app.kind == "game" {
+			games = append(games, app.id)
+		}
+	}
+	if n := database.countDocuments(gamesCollectionName); int(n) != len(games) {
+		t.Errorf("filter-games kept %v games, want %v", n, len(games))
+	}
+	if n := database.countDocuments(gameReviewsCollection); int(n) != len(games) {
+		t.Errorf("reviews saved %v games, want %v", n, len(games))
+	}
+	if n := database.countDocuments(userLinksCollection); n == 0 || int(n) > testFakeSteamParams.reviewers {
+		t.Errorf("user-links has %v users, want between 1 and %v", n, testFakeSteamParams.reviewers)
+	}
+	if n := database.countDocuments(gameLinksCollection); int(n) != len(games) {
+		t.Errorf("similarities saved %v game-links, want %v", n, len(games))
+	}
+
+	out := filepath.Join(t.TempDir(), "graph.json")
+	generateGraph(games[0], defaultGraphParams, true, graphWriters["json"], out)
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var graph Graph
+	if err := json.Unmarshal(data, &graph); err != nil {
+		t.Fatal(err)
+	}
+	if graph.Seed != games[0] || len(graph.Data) < 2 || len(graph.Edges) == 0 {
+		t.Errorf("graph of %v has %v nodes and %v edges, want it connected to similar games", games[0], len(graph.Data), len(graph.Edges))
+	}
+
+	for _, stage := range []string{"store-entries", "filter-games", "reviews", "user-links", "similarities"} {
+		statuses, ok := stageStatuses(stage)
+		if !ok || statuses[0].State != stageFinished || statuses[0].Failed != 0 {
+			t.Errorf("status of %v = %+v, want finished without failures", stage, statuses)
+		}
+	}
+}
+
+// TestBackfillGenres needs DATABASE_URL to point at MongoDB.
+func TestBackfillGenres(t *testing.T) {
+	useTestDatabase(t)
+	fake := useFakeSteam(t, testFakeSteamParams)
+
+	for _, app := range fake.apps {
+		if app.kind == "game" {
+			database.saveGame(StoreEntryDTO{ID: app.id, Name: app.name})
+		}
+	}
+	backfillGenres()
+
+	for _, app := range fake.apps {
+		if app.kind != "game" {
+			continue
+		}
+		var want []string
+		for _, genre := range app.genres {
+			want = append(want, genre.Description)
+		}
+		game := database.findGamesByIds([]int{app.id})
+		if len(game) != 1 || strings.Join(game[0].Genres, "|") != strings.Join(want, "|") {
+			t.Errorf("genres of %v = %+v, want %v", app.id, game, want)
+		}
+	}
+	if games := database.findGamesWithoutGenres(); len(games) != 0 {
+		t.Errorf("%v games still have no genres", len(games))
+	}
+}
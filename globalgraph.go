@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+var similarityMetrics = []string{"count", "score", "cosine", "jaccard"}
+
+// similarityGraph is the undirected, weighted graph of every game in game-links.
+type similarityGraph struct {
+	ids       []int
+	index     map[int]int
+	adjacency []map[int]float64
+}
+
+func (g *similarityGraph) node(gameId int) int {
+	i, ok := g.index[gameId]
+	if !ok {
+		i = len(g.ids)
+		g.index[gameId] = i
+		g.ids = append(g.ids, gameId)
+		g.adjacency = append(g.adjacency, make(map[int]float64))
+	}
+	return i
+}
+
+func (g *similarityGraph) addEdge(a int, b int, weight float64) {
+	if a != b && weight > g.adjacency[a][b] {
+		g.adjacency[a][b] = weight
+		g.adjacency[b][a] = weight
+	}
+}
+
+func (g *similarityGraph) edgeCount() int {
+	var count int
+	for _, neighbors := range g.adjacency {
+		count += len(neighbors)
+	}
+	return count / 2
+}
+
+func isSimilarityMetric(metric string) bool {
+	for _, m := range similarityMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// metricValue is the weight of a link under the given metric. cosine
+// normalises by the reviewer counts of both games and jaccard reads the
+// MinHash estimate, so it only makes sense on approxSimilarGames.
+func metricValue(s GameSimilarity, metric string, reviewsA int, reviewsB int) float64 {
+	switch metric {
+	case "count":
+		return float64(s.Count)
+	case "cosine":
+		return normalizedSimilarity(similarityScore(s), reviewsA, reviewsB)
+	case "jaccard":
+		return s.EstimatedJaccard
+	}
+	return similarityScore(s)
+}
+
+// buildSimilarityGraph keeps the topK strongest links of every game under
+// the metric, dropping those weaker than minWeight.
+func buildSimilarityGraph(topK int, metric string, minWeight float64) similarityGraph {
+	defer timeTrack(time.Now(), "buildSimilarityGraph")
+
+	reviewCounts := database.findAllReviewCounts()
+	graph := similarityGraph{index: make(map[int]int)}
+
+	cursor := database.findAllGameLinks()
+	for cursor.Next(context.TODO()) {
+		var gameLink GameLinkDTO
+		err := cursor.Decode(&gameLink)
+		check(err)
+
+		similarGames := gameLink.SimilarGames
+		if metric == "jaccard" {
+			similarGames = gameLink.ApproxSimilarGames
+		}
+
+		type weighted struct {
+			gameId int
+			weight float64
+		}
+		var candidates []weighted
+		for _, similarGame := range similarGames {
+			weight := metricValue(similarGame, metric, reviewCounts[gameLink.GameId], reviewCounts[similarGame.GameId])
+			if weight > 0 && weight >= minWeight {
+				candidates = append(candidates, weighted{similarGame.GameId, weight})
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].weight > candidates[j].weight
+		})
+		if len(candidates) > topK {
+			candidates = candidates[:topK]
+		}
+
+		a := graph.node(gameLink.GameId)
+		for _, candidate := range candidates {
+			graph.addEdge(a, graph.node(candidate.gameId), candidate.weight)
+		}
+	}
+
+	slog.Info("built similarity graph", "games", len(graph.ids), "edges", graph.edgeCount())
+	return graph
+}
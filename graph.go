@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxGraphDepth = 3
+
+// graphAlgorithmVersion is part of every stored graph's key, bump it
+// whenever buildGraph changes what it produces.
+const graphAlgorithmVersion = 3
+
+type graphParams struct {
+	depth int
+	// fanout is the number of neighbours followed per node at each hop,
+	// the last value is reused for deeper hops
+	fanout []int
+	// linkNeighbors is how far down a node's game-links to look for edges
+	// to other nodes already in the graph
+	linkNeighbors int
+}
+
+var defaultGraphParams = graphParams{
+	depth:         1,
+	fanout:        []int{20},
+	linkNeighbors: 50,
+}
+
+func (p graphParams) fanoutAt(hop int) int {
+	if hop-1 < len(p.fanout) {
+		return p.fanout[hop-1]
+	}
+	return p.fanout[len(p.fanout)-1]
+}
+
+func generateGraph(gameId int, params graphParams, refresh bool, writer graphWriter, out string) {
+	defer timeTrack(time.Now(), "generateGraph")
+
+	graph := findOrBuildGraph(gameId, params, refresh)
+
+	file, err := os.Create(out)
+	check(err)
+	defer file.Close()
+
+	err = writer.write(file, graph)
+	check(err)
+
+	slog.Info("wrote graph", "stage", "graph", "path", out)
+}
+
+func graphKey(gameId int, params graphParams) string {
+	var fanout []string
+	for hop := 1; hop <= params.depth; hop++ {
+		fanout = append(fanout, strconv.Itoa(params.fanoutAt(hop)))
+	}
+	return fmt.Sprintf("%v:depth=%v:fanout=%v:links=%v:v%v",
+		gameId, params.depth, strings.Join(fanout, ","), params.linkNeighbors, graphAlgorithmVersion)
+}
+
+// graphSources are the collections a graph's edges and node attributes are
+// read from. Stages rewriting any of them touch it, which outdates the
+// stored graphs.
+var graphSources = []string{gameLinksCollection, gamesCollectionName, gameReviewsCollection}
+
+func graphSourcesUpdatedAt() time.Time {
+	var updatedAt time.Time
+	for _, name := range graphSources {
+		if t := database.collectionUpdatedAt(name); t.After(updatedAt) {
+			updatedAt = t
+		}
+	}
+	return updatedAt
+}
+
+// findOrBuildGraph reuses a stored graph with the same key unless one of its
+// sources was rewritten after it was generated.
+func findOrBuildGraph(gameId int, params graphParams, refresh bool) Graph {
+	key := graphKey(gameId, params)
+
+	if !refresh {
+		stored, ok := database.findGraph(key)
+		if ok && stored.CreatedAt.After(graphSourcesUpdatedAt()) {
+			slog.Info("reusing graph", "stage", "graph", "key", key, "createdAt", stored.CreatedAt)
+			return stored.Graph
+		}
+	}
+
+	slog.Info("generating graph", "stage", "graph", "appid", gameId)
+	graph := buildGraph(gameId, params)
+
+	slog.Info("saving graph", "stage", "graph", "key", key)
+	var fanout []int
+	for hop := 1; hop <= params.depth; hop++ {
+		fanout = append(fanout, params.fanoutAt(hop))
+	}
+	database.saveGraph(GraphDTO{
+		Key:              key,
+		Seed:             gameId,
+		Depth:            params.depth,
+		Fanout:           fanout,
+		LinkNeighbors:    params.linkNeighbors,
+		AlgorithmVersion: graphAlgorithmVersion,
+		CreatedAt:        time.Now(),
+		Graph:            graph,
+	})
+	return graph
+}
+
+// buildGraph walks game-links outwards from the seed, then connects every
+// pair of nodes that appear in each other's game-links.
+func buildGraph(gameId int, params graphParams) Graph {
+	depths := map[int]int{gameId: 0}
+	order := []int{gameId}
+	links := make(map[int][]GameSimilarity)
+
+	frontier := []int{gameId}
+	for hop := 1; hop <= params.depth; hop++ {
+		loadGameLinks(links, frontier)
+
+		var next []int
+		for _, id := range frontier {
+			for _, similarGame := range topSimilarGames(links[id], params.fanoutAt(hop)) {
+				if _, seen := depths[similarGame.GameId]; seen {
+					continue
+				}
+				depths[similarGame.GameId] = hop
+				order = append(order, similarGame.GameId)
+				next = append(next, similarGame.GameId)
+			}
+		}
+		slog.Debug("graph hop", "stage", "graph", "hop", hop, "games", len(next))
+		frontier = next
+	}
+	loadGameLinks(links, frontier)
+
+	position := make(map[int]int)
+	for i, id := range order {
+		position[id] = i
+	}
+
+	var edges []*GraphEdge
+	edgeMap := make(map[[2]int]*GraphEdge)
+
+	for _, id := range order {
+		limit := params.linkNeighbors
+		if depths[id] < params.depth && params.fanoutAt(depths[id]+1) > limit {
+			limit = params.fanoutAt(depths[id] + 1)
+		}
+
+		for _, similarGame := range topSimilarGames(links[id], limit) {
+			otherId := similarGame.GameId
+			if _, ok := depths[otherId]; !ok || otherId == id {
+				continue
+			}
+
+			source, target := id, otherId
+			if position[target] < position[source] {
+				source, target = target, source
+			}
+			key := [2]int{source, target}
+			weight := similarityScore(similarGame)
+
+			if edge, ok := edgeMap[key]; ok {
+				edge.Weight = math.Max(edge.Weight, weight)
+				continue
+			}
+			edge := &GraphEdge{Source: source, Target: target, Weight: weight}
+			edgeMap[key] = edge
+			edges = append(edges, edge)
+		}
+	}
+
+	reviewCounts := database.findReviewCounts(order)
+
+	names := make(map[int]string)
+	for _, entry := range database.findStoreEntriesByIds(order) {
+		names[entry.ID] = entry.Name
+	}
+	games := make(map[int]StoreEntryDTO)
+	for _, game := range database.findGamesByIds(order) {
+		games[game.ID] = game
+	}
+
+	nodes := make(map[int]*GameNode)
+	var graph Graph
+	graph.Seed = gameId
+
+	for _, id := range order {
+		nodes[id] = &GameNode{
+			Id:          id,
+			Name:        names[id],
+			Value:       reviewCounts[id],
+			Depth:       depths[id],
+			Genres:      games[id].Genres,
+			Community:   games[id].Community,
+			Links:       []string{},
+			LinkedIds:   []int{},
+			LinkWeights: []float64{},
+		}
+	}
+
+	for _, edge := range edges {
+		edge.Similarity = normalizedSimilarity(edge.Weight, reviewCounts[edge.Source], reviewCounts[edge.Target])
+
+		source := nodes[edge.Source]
+		source.LinkedIds = append(source.LinkedIds, edge.Target)
+		source.Links = append(source.Links, names[edge.Target])
+		source.LinkWeights = append(source.LinkWeights, edge.Weight)
+
+		graph.Edges = append(graph.Edges, *edge)
+	}
+
+	for _, id := range order {
+		graph.Data = append(graph.Data, *nodes[id])
+	}
+	slog.Info("built graph", "stage", "graph", "nodes", len(graph.Data), "edges", len(graph.Edges))
+
+	return graph
+}
+
+func loadGameLinks(links map[int][]GameSimilarity, ids []int) {
+	var missing []int
+	for _, id := range ids {
+		if _, ok := links[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	for _, id := range missing {
+		links[id] = []GameSimilarity{}
+	}
+	for _, gameLink := range database.findGameLinks(missing) {
+		links[gameLink.GameId] = gameLink.SimilarGames
+	}
+}
+
+func topSimilarGames(similarGames []GameSimilarity, limit int) []GameSimilarity {
+	if len(similarGames) > limit {
+		return similarGames[:limit]
+	}
+	return similarGames
+}
+
+// normalizedSimilarity scales a co-occurrence by the size of both games,
+// the cosine between their reviewer sets for unweighted links.
+func normalizedSimilarity(score float64, reviewsA int, reviewsB int) float64 {
+	if reviewsA == 0 || reviewsB == 0 {
+		return 0
+	}
+	return math.Min(score/math.Sqrt(float64(reviewsA)*float64(reviewsB)), 1)
+}
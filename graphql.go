@@ -0,0 +1,758 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A small GraphQL executor: queries with variables, aliases, arguments and
+// fragments. There is no introspection and no mutations. Fields are resolved
+// breadth first, one call per field for all parent objects at once, which is
+// what lets the resolvers batch their database lookups.
+
+type gqlSelection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	selections []gqlSelection
+}
+
+type gqlVariable string
+
+type gqlEnum string
+
+type gqlDocument struct {
+	operations []gqlOperation
+	fragments  map[string][]gqlSelection
+}
+
+type gqlOperation struct {
+	name       string
+	variables  map[string]interface{}
+	selections []gqlSelection
+}
+
+// gqlFieldDef describes a field of an object type. typ is the object type of
+// the field's values, empty for scalars.
+type gqlFieldDef struct {
+	typ     string
+	resolve gqlResolver
+}
+
+// gqlResolver returns one value per parent. List fields return []interface{}.
+type gqlResolver func(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error)
+
+type gqlSchema map[string]map[string]gqlFieldDef
+
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type gqlError struct {
+	Message string `json:"message"`
+}
+
+type gqlResponse struct {
+	Data   interface{} `json:"data"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// gqlObject keeps the fields of a result in query order.
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newGqlObject() *gqlObject {
+	return &gqlObject{values: make(map[string]interface{})}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func executeGraphQL(schema gqlSchema, ctx *gqlContext, request gqlRequest) gqlResponse {
+	document, err := parseGraphQL(request.Query)
+	if err != nil {
+		return gqlResponse{Errors: []gqlError{{err.Error()}}}
+	}
+
+	var operation *gqlOperation
+	for i := range document.operations {
+		if request.OperationName == "" || document.operations[i].name == request.OperationName {
+			operation = &document.operations[i]
+			break
+		}
+	}
+	if operation == nil {
+		return gqlResponse{Errors: []gqlError{{"operation not found"}}}
+	}
+	if request.OperationName == "" && len(document.operations) > 1 {
+		return gqlResponse{Errors: []gqlError{{"operationName is required for documents with several operations"}}}
+	}
+
+	variables := make(map[string]interface{})
+	for name, fallback := range operation.variables {
+		variables[name] = fallback
+	}
+	for name, value := range request.Variables {
+		variables[name] = value
+	}
+
+	selections, err := expandFragments(operation.selections, document.fragments, 0)
+	if err != nil {
+		return gqlResponse{Errors: []gqlError{{err.Error()}}}
+	}
+	if err := bindVariables(selections, variables); err != nil {
+		return gqlResponse{Errors: []gqlError{{err.Error()}}}
+	}
+	if selectionDepth(selections) > maxQueryDepth {
+		return gqlResponse{Errors: []gqlError{{fmt.Sprintf("query is nested deeper than %v levels", maxQueryDepth)}}}
+	}
+
+	budget := maxQueryObjects
+	results, err := executeSelections(schema, ctx, "Query", []interface{}{nil}, selections, &budget)
+	if err != nil {
+		return gqlResponse{Errors: []gqlError{{err.Error()}}}
+	}
+	return gqlResponse{Data: results[0]}
+}
+
+// executeSelections takes the objects it resolves out of budget and fails
+// before resolving the fields of more objects than are left.
+func executeSelections(schema gqlSchema, ctx *gqlContext, typeName string, parents []interface{}, selections []gqlSelection, budget *int) ([]*gqlObject, error) {
+	objects := make([]*gqlObject, len(parents))
+	for i := range objects {
+		objects[i] = newGqlObject()
+	}
+
+	for _, selection := range selections {
+		key := selection.alias
+		if key == "" {
+			key = selection.name
+		}
+
+		if selection.name == "__typename" {
+			for _, object := range objects {
+				object.set(key, typeName)
+			}
+			continue
+		}
+
+		field, ok := schema[typeName][selection.name]
+		if !ok {
+			return nil, fmt.Errorf("cannot query field %q on type %q", selection.name, typeName)
+		}
+
+		values, err := field.resolve(ctx, parents, selection.args)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", selection.name, err)
+		}
+
+		if field.typ == "" {
+			if len(selection.selections) > 0 {
+				return nil, fmt.Errorf("field %q of type %q has no subfields", selection.name, typeName)
+			}
+			for i, object := range objects {
+				object.set(key, values[i])
+			}
+			continue
+		}
+		if len(selection.selections) == 0 {
+			return nil, fmt.Errorf("field %q of type %q must have a selection of subfields", selection.name, typeName)
+		}
+
+		// Flatten every child of every parent into one batch for the next level.
+		var children []interface{}
+		for _, value := range values {
+			if list, ok := value.([]interface{}); ok {
+				children = append(children, list...)
+			} else if value != nil {
+				children = append(children, value)
+			}
+		}
+
+		*budget -= len(children)
+		if *budget < 0 {
+			return nil, fmt.Errorf("query resolves more than %v objects", maxQueryObjects)
+		}
+		resolved, err := executeSelections(schema, ctx, field.typ, children, selection.selections, budget)
+		if err != nil {
+			return nil, err
+		}
+
+		next := 0
+		for i, value := range values {
+			if list, ok := value.([]interface{}); ok {
+				items := make([]*gqlObject, len(list))
+				copy(items, resolved[next:next+len(list)])
+				next += len(list)
+				objects[i].set(key, items)
+			} else if value != nil {
+				objects[i].set(key, resolved[next])
+				next++
+			} else {
+				objects[i].set(key, nil)
+			}
+		}
+	}
+	return objects, nil
+}
+
+const maxFragmentDepth = 10
+
+// Limits on what a single query can make the server resolve
+const (
+	maxQueryDepth   = 10
+	maxQueryObjects = 10000
+)
+
+// selectionDepth is the number of nested selection sets, once fragments are
+// expanded.
+func selectionDepth(selections []gqlSelection) int {
+	depth := 0
+	for _, selection := range selections {
+		if d := selectionDepth(selection.selections); d > depth {
+			depth = d
+		}
+	}
+	if len(selections) == 0 {
+		return 0
+	}
+	return depth + 1
+}
+
+// expandFragments inlines fragment spreads, which the parser keeps as
+// selections named "..." with the fragment name as alias.
+func expandFragments(selections []gqlSelection, fragments map[string][]gqlSelection, depth int) ([]gqlSelection, error) {
+	if depth > maxFragmentDepth {
+		return nil, fmt.Errorf("fragments nested too deeply")
+	}
+
+	var expanded []gqlSelection
+	for _, selection := range selections {
+		if selection.name == "..." {
+			inner := selection.selections
+			if selection.alias != "" {
+				fragment, ok := fragments[selection.alias]
+				if !ok {
+					return nil, fmt.Errorf("unknown fragment %q", selection.alias)
+				}
+				inner = fragment
+			}
+			inner, err := expandFragments(inner, fragments, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, inner...)
+			continue
+		}
+
+		children, err := expandFragments(selection.selections, fragments, depth)
+		if err != nil {
+			return nil, err
+		}
+		selection.selections = children
+		expanded = append(expanded, selection)
+	}
+	return expanded, nil
+}
+
+func bindVariables(selections []gqlSelection, variables map[string]interface{}) error {
+	for i := range selections {
+		for name, value := range selections[i].args {
+			bound, err := bindValue(value, variables)
+			if err != nil {
+				return err
+			}
+			selections[i].args[name] = bound
+		}
+		if err := bindVariables(selections[i].selections, variables); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bindValue(value interface{}, variables map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case gqlVariable:
+		bound, ok := variables[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%v is not defined", v)
+		}
+		return bound, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			bound, err := bindValue(item, variables)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = bound
+		}
+		return list, nil
+	case gqlEnum:
+		return string(v), nil
+	}
+	return value, nil
+}
+
+func argInt(args map[string]interface{}, name string, fallback int) (int, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return fallback, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return int(n), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %q must be an Int", name)
+}
+
+func argString(args map[string]interface{}, name string, fallback string) (string, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return fallback, nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("argument %q must be a String", name)
+}
+
+func argInts(args map[string]interface{}, name string) ([]int, error) {
+	list, ok := args[name].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("argument %q must be a list of Int", name)
+	}
+	var ints []int
+	for i := range list {
+		n, err := argInt(map[string]interface{}{name: list[i]}, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+type gqlParser struct {
+	source string
+	pos    int
+}
+
+func parseGraphQL(source string) (gqlDocument, error) {
+	p := &gqlParser{source: source}
+	document := gqlDocument{fragments: make(map[string][]gqlSelection)}
+
+	for {
+		p.skipIgnored()
+		if p.pos >= len(p.source) {
+			break
+		}
+
+		switch {
+		case p.peek() == '{':
+			selections, err := p.selectionSet()
+			if err != nil {
+				return document, err
+			}
+			document.operations = append(document.operations, gqlOperation{selections: selections})
+
+		case p.keyword("query"):
+			operation, err := p.operation()
+			if err != nil {
+				return document, err
+			}
+			document.operations = append(document.operations, operation)
+
+		case p.keyword("fragment"):
+			name, err := p.name()
+			if err != nil {
+				return document, err
+			}
+			if !p.keyword("on") {
+				return document, p.errorf("expected \"on\"")
+			}
+			if _, err := p.name(); err != nil {
+				return document, err
+			}
+			selections, err := p.selectionSet()
+			if err != nil {
+				return document, err
+			}
+			document.fragments[name] = selections
+
+		case p.keyword("mutation"), p.keyword("subscription"):
+			return document, p.errorf("only queries are supported")
+
+		default:
+			return document, p.errorf("unexpected %q", p.peek())
+		}
+	}
+
+	if len(document.operations) == 0 {
+		return document, fmt.Errorf("document has no operation")
+	}
+	return document, nil
+}
+
+func (p *gqlParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("syntax error at %v: %v", p.pos, fmt.Sprintf(format, a...))
+}
+
+func (p *gqlParser) skipIgnored() {
+	for p.pos < len(p.source) {
+		c := p.source[p.pos]
+		switch {
+		case c == '#':
+			for p.pos < len(p.source) && p.source[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ',' || unicode.IsSpace(rune(c)) || c == 0xEF || c == 0xBB || c == 0xBF:
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *gqlParser) peek() byte {
+	p.skipIgnored()
+	if p.pos >= len(p.source) {
+		return 0
+	}
+	return p.source[p.pos]
+}
+
+func (p *gqlParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func (p *gqlParser) name() (string, error) {
+	if !isNameStart(p.peek()) {
+		return "", p.errorf("expected a name")
+	}
+	start := p.pos
+	for p.pos < len(p.source) && isNameChar(p.source[p.pos]) {
+		p.pos++
+	}
+	return p.source[start:p.pos], nil
+}
+
+// keyword consumes the given name if it comes next.
+func (p *gqlParser) keyword(word string) bool {
+	p.skipIgnored()
+	end := p.pos + len(word)
+	if end > len(p.source) || p.source[p.pos:end] != word {
+		return false
+	}
+	if end < len(p.source) && isNameChar(p.source[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *gqlParser) operation() (gqlOperation, error) {
+	operation := gqlOperation{variables: make(map[string]interface{})}
+
+	if isNameStart(p.peek()) {
+		name, err := p.name()
+		if err != nil {
+			return operation, err
+		}
+		operation.name = name
+	}
+
+	if p.peek() == '(' {
+		p.pos++
+		for p.peek() != ')' {
+			if err := p.expect('$'); err != nil {
+				return operation, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return operation, err
+			}
+			if err := p.expect(':'); err != nil {
+				return operation, err
+			}
+			if err := p.skipType(); err != nil {
+				return operation, err
+			}
+			if p.peek() == '=' {
+				p.pos++
+				value, err := p.value()
+				if err != nil {
+					return operation, err
+				}
+				operation.variables[name] = value
+			}
+		}
+		p.pos++
+	}
+
+	selections, err := p.selectionSet()
+	operation.selections = selections
+	return operation, err
+}
+
+// skipType reads a variable type such as [Int!]! without checking it.
+func (p *gqlParser) skipType() error {
+	if p.peek() == '[' {
+		p.pos++
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if err := p.expect(']'); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	if p.peek() == '!' {
+		p.pos++
+	}
+	return nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+
+	var selections []gqlSelection
+	for p.peek() != '}' {
+		if p.peek() == 0 {
+			return nil, p.errorf("unexpected end of query")
+		}
+		if p.peek() == '@' {
+			return nil, p.errorf("directives are not supported")
+		}
+
+		if strings.HasPrefix(p.source[p.pos:], "...") {
+			p.pos += 3
+			if p.keyword("on") {
+				if _, err := p.name(); err != nil {
+					return nil, err
+				}
+			}
+			if p.peek() == '{' {
+				inner, err := p.selectionSet()
+				if err != nil {
+					return nil, err
+				}
+				selections = append(selections, gqlSelection{name: "...", selections: inner})
+				continue
+			}
+			fragment, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			selections = append(selections, gqlSelection{name: "...", alias: fragment})
+			continue
+		}
+
+		selection, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	p.pos++
+	return selections, nil
+}
+
+func (p *gqlParser) field() (gqlSelection, error) {
+	selection := gqlSelection{args: make(map[string]interface{})}
+
+	name, err := p.name()
+	if err != nil {
+		return selection, err
+	}
+	if p.peek() == ':' {
+		p.pos++
+		selection.alias = name
+		if name, err = p.name(); err != nil {
+			return selection, err
+		}
+	}
+	selection.name = name
+
+	if p.peek() == '(' {
+		p.pos++
+		for p.peek() != ')' {
+			argName, err := p.name()
+			if err != nil {
+				return selection, err
+			}
+			if err := p.expect(':'); err != nil {
+				return selection, err
+			}
+			value, err := p.value()
+			if err != nil {
+				return selection, err
+			}
+			selection.args[argName] = value
+		}
+		p.pos++
+	}
+
+	if p.peek() == '{' {
+		selection.selections, err = p.selectionSet()
+	}
+	return selection, err
+}
+
+func (p *gqlParser) value() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '$':
+		p.pos++
+		name, err := p.name()
+		return gqlVariable(name), err
+
+	case c == '"':
+		return p.stringValue()
+
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.source) && strings.IndexByte("0123456789.eE+-", p.source[p.pos]) >= 0 {
+			p.pos++
+		}
+		literal := p.source[start:p.pos]
+		if n, err := strconv.ParseInt(literal, 10, 64); err == nil {
+			return n, nil
+		}
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", literal)
+		}
+		return f, nil
+
+	case c == '[':
+		p.pos++
+		list := []interface{}{}
+		for p.peek() != ']' {
+			if p.peek() == 0 {
+				return nil, p.errorf("unexpected end of list")
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		p.pos++
+		return list, nil
+
+	case isNameStart(c):
+		name, _ := p.name()
+		switch name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(name), nil
+	}
+	return nil, p.errorf("expected a value")
+}
+
+func (p *gqlParser) stringValue() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.source) {
+		c := p.source[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\\':
+			if p.pos+1 >= len(p.source) {
+				return "", p.errorf("unterminated string")
+			}
+			escape := p.source[p.pos+1]
+			p.pos += 2
+			switch escape {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'u':
+				if p.pos+4 > len(p.source) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(p.source[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid unicode escape")
+				}
+				b.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				b.WriteByte(escape)
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testGraphQLSchema serves numbers without a database: number(n) is n, and
+// the multiples of a number are the next limit multiples after it.
+var testGraphQLSchema = gqlSchema{
+	"Query": {
+		"number": {typ: "Number", resolve: func(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+			n, err := argInt(args, "n", 1)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{n}, nil
+		}},
+	},
+	"Number": {
+		"value": {resolve: gqlScalar(func(p interface{}) interface{} { return p })},
+		"name": {resolve: func(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+			prefix, err := argString(args, "prefix", "n")
+			if err != nil {
+				return nil, err
+			}
+			values := make([]interface{}, len(parents))
+			for i, parent := range parents {
+				values[i] = fmt.Sprintf("%v%v", prefix, parent)
+			}
+			return values, nil
+		}},
+		"multiples": {typ: "Number", resolve: func(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+			limit, err := argInt(args, "limit", 2)
+			if err != nil {
+				return nil, err
+			}
+			values := make([]interface{}, len(parents))
+			for i, parent := range parents {
+				var multiples []interface{}
+				for k := 2; k < limit+2; k++ {
+					multiples = append(multiples, parent.(int)*k)
+				}
+				values[i] = multiples
+			}
+			return values, nil
+		}},
+	},
+}
+
+func executeTestGraphQL(t *testing.T, query string, variables map[string]interface{}) (string, []gqlError) {
+	t.Helper()
+	response := executeGraphQL(testGraphQLSchema, newGqlContext(), gqlRequest{Query: query, Variables: variables})
+	if response.Data == nil {
+		return "", response.Errors
+	}
+	data, err := json.Marshal(response.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), response.Errors
+}
+
+func TestParseGraphQL(t *testing.T) {
+	document, err := parseGraphQL(`
+		# a comment
+		query Named($id: Int! = 440, $ids: [Int!]) {
+			first: game(id: $id, metric: cosine) { name, ...Fields }
+			games(ids: [10, -20, 3.5], query: "a \"quoted\"\né") { ... on Game { id } }
+		}
+		fragment Fields on Game { genres }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(document.operations) != 1 || document.operations[0].name != "Named" {
+		t.Fatalf("operations = %+v", document.operations)
+	}
+	operation := document.operations[0]
+	if !reflect.DeepEqual(operation.variables, map[string]interface{}{"id": int64(440)}) {
+		t.Errorf("variable defaults = %v", operation.variables)
+	}
+
+	first := operation.selections[0]
+	if first.alias != "first" || first.name != "game" {
+		t.Errorf("first selection = %v: %v", first.alias, first.name)
+	}
+	if !reflect.DeepEqual(first.args, map[string]interface{}{"id": gqlVariable("id"), "metric": gqlEnum("cosine")}) {
+		t.Errorf("game args = %v", first.args)
+	}
+	if len(first.selections) != 2 || first.selections[1].name != "..." || first.selections[1].alias != "Fields" {
+		t.Errorf("game selections = %+v", first.selections)
+	}
+
+	games := operation.selections[1]
+	wantArgs := map[string]interface{}{
+		"ids":   []interface{}{int64(10), int64(-20), 3.5},
+		"query": "a \"quoted\"\né",
+	}
+	if !reflect.DeepEqual(games.args, wantArgs) {
+		t.Errorf("games args = %#v, want %#v", games.args, wantArgs)
+	}
+	if len(games.selections) != 1 || games.selections[0].name != "..." || games.selections[0].selections[0].name != "id" {
+		t.Errorf("inline fragment = %+v", games.selections)
+	}
+
+	if fields := document.fragments["Fields"]; len(fields) != 1 || fields[0].name != "genres" {
+		t.Errorf("fragment Fields = %+v", fields)
+	}
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	tests := map[string]string{
+		"":                                 "document has no operation",
+		"{ game(id: 1) { name }":           "unexpected end of query",
+		"mutation { game }":                "only queries are supported",
+		"{ game @skip(if: true) }":         "directives are not supported",
+		`{ search(query: "unterminated) }`: "unterminated string",
+		"{ game(id: 1-2) }":                "invalid number",
+		"fragment F { name } { game }":     `expected "on"`,
+		"query ($id) { game }":             "expected ':'",
+	}
+	for query, want := range tests {
+		_, err := parseGraphQL(query)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseGraphQL(%q) = %v, want an error containing %q", query, err, want)
+		}
+	}
+}
+
+func TestExecuteGraphQL(t *testing.T) {
+	query := `
+		query ($n: Int = 2, $prefix: String) {
+			number(n: $n) {
+				...Named
+				__typename
+				multiples(limit: 3) { value, twice: multiples(limit: 1) { value } }
+			}
+		}
+		fragment Named on Number { value, label: name(prefix: $prefix) }
+	`
+	data, errors := executeTestGraphQL(t, query, map[string]interface{}{"prefix": "#"})
+	if errors != nil {
+		t.Fatal(errors)
+	}
+	want := `{"number":{"value":2,"label":"#2","__typename":"Number","multiples":[` +
+		`{"value":4,"twice":[{"value":8}]},{"value":6,"twice":[{"value":12}]},{"value":8,"twice":[{"value":16}]}]}}`
+	if data != want {
+		t.Errorf("data = %v, want %v", data, want)
+	}
+
+	// Variables from the request override the defaults
+	data, _ = executeTestGraphQL(t, query, map[string]interface{}{"n": 5.0, "prefix": "n"})
+	if !strings.HasPrefix(data, `{"number":{"value":5,"label":"n5"`) {
+		t.Errorf("data with n = 5 is %v", data)
+	}
+}
+
+func TestExecuteGraphQLErrors(t *testing.T) {
+	tests := map[string]string{
+		"{ number { square } }":                                     `cannot query field "square" on type "Number"`,
+		"{ number { value { digits } } }":                           `field "value" of type "Number" has no subfields`,
+		"{ number { multiples } }":                                  `field "multiples" of type "Number" must have a selection of subfields`,
+		"{ number(n: $missing) { value } }":                         "variable $missing is not defined",
+		"{ number { ...Missing } }":                                 `unknown fragment "Missing"`,
+		`{ number(n: "two") { value } }`:                            `argument "n" must be an Int`,
+		"fragment A on Number { ...A } { number { ...A } }":         "fragments nested too deeply",
+		"query A { number { value } } query B { number { value } }": "operationName is required",
+	}
+	for query, want := range tests {
+		_, errors := executeTestGraphQL(t, query, nil)
+		if len(errors) != 1 || !strings.Contains(errors[0].Message, want) {
+			t.Errorf("errors of %q = %v, want one containing %q", query, errors, want)
+		}
+	}
+}
+
+func TestExecuteGraphQLLimits(t *testing.T) {
+	nested := func(depth int, limit int) string {
+		query := "value"
+		for i := 0; i < depth; i++ {
+			query = fmt.Sprintf("multiples(limit: %v) { %v }", limit, query)
+		}
+		return "{ number { " + query + " } }"
+	}
+
+	if _, errors := executeTestGraphQL(t, nested(maxQueryDepth-2, 1), nil); errors != nil {
+		t.Errorf("query %v levels deep failed: %v", maxQueryDepth, errors)
+	}
+	_, errors := executeTestGraphQL(t, nested(maxQueryDepth-1, 1), nil)
+	if len(errors) != 1 || !strings.Contains(errors[0].Message, "nested deeper than") {
+		t.Errorf("errors of a query %v levels deep = %v", maxQueryDepth+1, errors)
+	}
+
+	// 200 multiples of 200 multiples each fan out past the budget
+	_, errors = executeTestGraphQL(t, nested(2, 200), nil)
+	if len(errors) != 1 || !strings.Contains(errors[0].Message, "resolves more than") {
+		t.Errorf("errors of a query fanning out to 40000 objects = %v", errors)
+	}
+	if _, errors := executeTestGraphQL(t, nested(2, 90), nil); errors != nil {
+		t.Errorf("query fanning out to 8190 objects failed: %v", errors)
+	}
+}
+
+func TestGraphQLRejectsOutOfRangeArguments(t *testing.T) {
+	tests := []struct {
+		resolve gqlResolver
+		args    map[string]interface{}
+	}{
+		{resolveUserRecommendations, map[string]interface{}{"neighbors": int64(-1)}},
+		{resolveUserRecommendations, map[string]interface{}{"neighbors": int64(0)}},
+		{resolveUserRecommendations, map[string]interface{}{"limit": int64(-5)}},
+		{resolveGameSimilar, map[string]interface{}{"limit": int64(maxPageLimit + 1)}},
+		{resolveQuerySearch, map[string]interface{}{"query": "portal", "limit": int64(-1)}},
+		{resolveQueryGraph, map[string]interface{}{"id": int64(440), "fanout": []interface{}{int64(maxPageLimit + 1)}}},
+		{resolveQueryGraph, map[string]interface{}{"id": int64(440), "fanout": []interface{}{}}},
+		{resolveQueryGraph, map[string]interface{}{"id": int64(440), "fanout": []interface{}{int64(1), int64(1), int64(1), int64(1)}}},
+	}
+	for _, test := range tests {
+		if _, err := test.resolve(newGqlContext(), nil, test.args); err == nil {
+			t.Errorf("resolving with %v succeeded, want an error", test.args)
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const defaultGraphQLListLimit = 10
+
+// gqlContext caches what a request has loaded, so that every level of a
+// query issues at most one lookup per collection for all its objects.
+type gqlContext struct {
+	entries      map[int]StoreEntryDTO
+	games        map[int]StoreEntryDTO
+	reviewCounts map[int]int
+	gameLinks    map[int]GameLinkDTO
+	userLinks    map[string]UserLinkDTO
+}
+
+func newGqlContext() *gqlContext {
+	return &gqlContext{
+		entries:      make(map[int]StoreEntryDTO),
+		games:        make(map[int]StoreEntryDTO),
+		reviewCounts: make(map[int]int),
+		gameLinks:    make(map[int]GameLinkDTO),
+		userLinks:    make(map[string]UserLinkDTO),
+	}
+}
+
+// missingIds returns the distinct ids not yet in the cache.
+func missingIds(ids []int, loaded func(id int) bool) []int {
+	seen := make(map[int]bool)
+	var missing []int
+	for _, id := range ids {
+		if !seen[id] && !loaded(id) {
+			seen[id] = true
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+func (ctx *gqlContext) loadEntries(ids []int) {
+	missing := missingIds(ids, func(id int) bool { _, ok := ctx.entries[id]; return ok })
+	if len(missing) == 0 {
+		return
+	}
+	for _, id := range missing {
+		ctx.entries[id] = StoreEntryDTO{}
+	}
+	for _, entry := range database.findStoreEntriesByIds(missing) {
+		ctx.entries[entry.ID] = entry
+	}
+}
+
+func (ctx *gqlContext) loadGames(ids []int) {
+	missing := missingIds(ids, func(id int) bool { _, ok := ctx.games[id]; return ok })
+	if len(missing) == 0 {
+		return
+	}
+	for _, id := range missing {
+		ctx.games[id] = StoreEntryDTO{}
+	}
+	for _, game := range database.findGamesByIds(missing) {
+		ctx.games[game.ID] = game
+	}
+}
+
+func (ctx *gqlContext) loadReviewCounts(ids []int) {
+	missing := missingIds(ids, func(id int) bool { _, ok := ctx.reviewCounts[id]; return ok })
+	if len(missing) == 0 {
+		return
+	}
+	counts := database.findReviewCounts(missing)
+	for _, id := range missing {
+		ctx.reviewCounts[id] = counts[id]
+	}
+}
+
+func (ctx *gqlContext) loadGameLinks(ids []int) {
+	missing := missingIds(ids, func(id int) bool { _, ok := ctx.gameLinks[id]; return ok })
+	if len(missing) == 0 {
+		return
+	}
+	for _, id := range missing {
+		ctx.gameLinks[id] = GameLinkDTO{GameId: id}
+	}
+	for _, gameLink := range database.findGameLinks(missing) {
+		ctx.gameLinks[gameLink.GameId] = gameLink
+	}
+}
+
+func (ctx *gqlContext) loadUserLinks(ids []string) {
+	var missing []string
+	for _, id := range ids {
+		if _, ok := ctx.userLinks[id]; !ok {
+			ctx.userLinks[id] = UserLinkDTO{}
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	for _, userLink := range database.findUserLinks(missing) {
+		ctx.userLinks[userLink.UserId] = userLink
+	}
+}
+
+// Parent values of the object types: Game is an app id, User a steam id.
+type gqlSimilarGame struct {
+	gameId     int
+	similarity GameSimilarity
+	value      float64
+}
+
+type gqlGraphNode struct {
+	node GameNode
+}
+
+func gameIds(parents []interface{}) []int {
+	ids := make([]int, len(parents))
+	for i, parent := range parents {
+		ids[i] = parent.(int)
+	}
+	return ids
+}
+
+func userIds(parents []interface{}) []string {
+	ids := make([]string, len(parents))
+	for i, parent := range parents {
+		ids[i] = parent.(string)
+	}
+	return ids
+}
+
+func intList(ids []int) []interface{} {
+	list := make([]interface{}, len(ids))
+	for i, id := range ids {
+		list[i] = id
+	}
+	return list
+}
+
+// gqlScalar resolves each parent on its own, for fields that need no lookup.
+func gqlScalar(get func(parent interface{}) interface{}) gqlResolver {
+	return func(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		values := make([]interface{}, len(parents))
+		for i, parent := range parents {
+			values[i] = get(parent)
+		}
+		return values, nil
+	}
+}
+
+var graphqlSchema = gqlSchema{
+	"Query": {
+		"game":   {typ: "Game", resolve: resolveQueryGame},
+		"games":  {typ: "Game", resolve: resolveQueryGames},
+		"user":   {typ: "User", resolve: resolveQueryUser},
+		"graph":  {typ: "Graph", resolve: resolveQueryGraph},
+		"search": {typ: "Game", resolve: resolveQuerySearch},
+	},
+	"Game": {
+		"id":          {resolve: gqlScalar(func(p interface{}) interface{} { return p })},
+		"name":        {resolve: resolveGameName},
+		"reviewCount": {resolve: resolveGameReviewCount},
+		"genres":      {resolve: resolveGameGenres},
+		"community":   {resolve: resolveGameCommunity},
+		"centrality":  {typ: "Centrality", resolve: resolveGameCentrality},
+		"similar":     {typ: "SimilarGame", resolve: resolveGameSimilar},
+	},
+	"Centrality": {
+		"pagerank":       {resolve: gqlScalar(func(p interface{}) interface{} { return p.(*CentralityDTO).PageRank })},
+		"weightedDegree": {resolve: gqlScalar(func(p interface{}) interface{} { return p.(*CentralityDTO).WeightedDegree })},
+		"betweenness":    {resolve: gqlScalar(func(p interface{}) interface{} { return p.(*CentralityDTO).Betweenness })},
+		"clustering":     {resolve: gqlScalar(func(p interface{}) interface{} { return p.(*CentralityDTO).Clustering })},
+	},
+	"SimilarGame": {
+		"game":       {typ: "Game", resolve: gqlScalar(func(p interface{}) interface{} { return p.(gqlSimilarGame).gameId })},
+		"count":      {resolve: gqlScalar(func(p interface{}) interface{} { return p.(gqlSimilarGame).similarity.Count })},
+		"score":      {resolve: gqlScalar(func(p interface{}) interface{} { return p.(gqlSimilarGame).similarity.Score })},
+		"similarity": {resolve: gqlScalar(func(p interface{}) interface{} { return p.(gqlSimilarGame).value })},
+	},
+	"User": {
+		"steamId":         {resolve: gqlScalar(func(p interface{}) interface{} { return p })},
+		"reviewedGames":   {typ: "Game", resolve: resolveUserReviewedGames},
+		"recommendations": {typ: "Recommendation", resolve: resolveUserRecommendations},
+	},
+	"Recommendation": {
+		"game":    {typ: "Game", resolve: gqlScalar(func(p interface{}) interface{} { return p.(Recommendation).GameId })},
+		"score":   {resolve: gqlScalar(func(p interface{}) interface{} { return p.(Recommendation).Score })},
+		"because": {typ: "Game", resolve: resolveRecommendationBecause},
+	},
+	"Graph": {
+		"seed":  {typ: "Game", resolve: gqlScalar(func(p interface{}) interface{} { return p.(Graph).Seed })},
+		"nodes": {typ: "GraphNode", resolve: resolveGraphNodes},
+		"edges": {typ: "GraphEdge", resolve: resolveGraphEdges},
+	},
+	"GraphNode": {
+		"game":  {typ: "Game", resolve: gqlScalar(func(p interface{}) interface{} { return p.(gqlGraphNode).node.Id })},
+		"depth": {resolve: gqlScalar(func(p interface{}) interface{} { return p.(gqlGraphNode).node.Depth })},
+		"value": {resolve: gqlScalar(func(p interface{}) interface{} { return p.(gqlGraphNode).node.Value })},
+	},
+	"GraphEdge": {
+		"source":     {typ: "Game", resolve: gqlScalar(func(p interface{}) interface{} { return p.(GraphEdge).Source })},
+		"target":     {typ: "Game", resolve: gqlScalar(func(p interface{}) interface{} { return p.(GraphEdge).Target })},
+		"weight":     {resolve: gqlScalar(func(p interface{}) interface{} { return p.(GraphEdge).Weight })},
+		"similarity": {resolve: gqlScalar(func(p interface{}) interface{} { return p.(GraphEdge).Similarity })},
+	},
+}
+
+// knownGames keeps the ids that have a store entry and nils out the rest.
+func knownGames(ctx *gqlContext, ids []int) []interface{} {
+	ctx.loadEntries(ids)
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		if ctx.entries[id].ID != 0 {
+			values[i] = id
+		}
+	}
+	return values
+}
+
+func resolveQueryGame(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	id, err := argInt(args, "id", 0)
+	if err != nil {
+		return nil, err
+	}
+	return knownGames(ctx, []int{id}), nil
+}
+
+func resolveQueryGames(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	ids, err := argInts(args, "ids")
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > maxPageLimit {
+		return nil, fmt.Errorf("at most %v ids can be queried", maxPageLimit)
+	}
+
+	var games []interface{}
+	for _, game := range knownGames(ctx, ids) {
+		if game != nil {
+			games = append(games, game)
+		}
+	}
+	return []interface{}{append([]interface{}{}, games...)}, nil
+}
+
+func resolveQueryUser(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	steamId, err := argString(args, "steamId", "")
+	if err != nil {
+		return nil, err
+	}
+	ctx.loadUserLinks([]string{steamId})
+	if ctx.userLinks[steamId].UserId == "" {
+		return []interface{}{nil}, nil
+	}
+	return []interface{}{steamId}, nil
+}
+
+func resolveQueryGraph(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	params := defaultGraphParams
+
+	id, err := argInt(args, "id", 0)
+	if err != nil {
+		return nil, err
+	}
+	if params.depth, err = argInt(args, "depth", params.depth); err != nil {
+		return nil, err
+	}
+	if params.depth < 1 || params.depth > maxGraphDepth {
+		return nil, fmt.Errorf("depth must be between 1 and %v", maxGraphDepth)
+	}
+	if _, ok := args["fanout"]; ok {
+		if params.fanout, err = argInts(args, "fanout"); err != nil {
+			return nil, err
+		}
+		if len(params.fanout) < 1 || len(params.fanout) > maxGraphDepth {
+			return nil, fmt.Errorf("fanout must have between 1 and %v values", maxGraphDepth)
+		}
+		for _, n := range params.fanout {
+			if n <= 0 || n > maxPageLimit {
+				return nil, fmt.Errorf("fanout must be between 1 and %v", maxPageLimit)
+			}
+		}
+	}
+
+	if knownGames(ctx, []int{id})[0] == nil {
+		return []interface{}{nil}, nil
+	}
+	return []interface{}{findOrBuildGraph(id, params, false)}, nil
+}
+
+func resolveQuerySearch(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	query, err := argString(args, "query", "")
+	if err != nil {
+		return nil, err
+	}
+	limit, err := argInt(args, "limit", defaultGraphQLListLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > maxPageLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %v", maxPageLimit)
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+
+	gameSearch.lazy.ensure(func() {
+		gameSearch.index = buildSearchIndex()
+	})
+
+	games := []interface{}{}
+	for _, result := range gameSearch.index.search(query) {
+		if len(games) == limit {
+			break
+		}
+		games = append(games, result.GameId)
+	}
+	return []interface{}{games}, nil
+}
+
+func resolveGameName(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	ids := gameIds(parents)
+	ctx.loadEntries(ids)
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = ctx.entries[id].Name
+	}
+	return values, nil
+}
+
+func resolveGameReviewCount(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	ids := gameIds(parents)
+	ctx.loadReviewCounts(ids)
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = ctx.reviewCounts[id]
+	}
+	return values, nil
+}
+
+func resolveGameGenres(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	ids := gameIds(parents)
+	ctx.loadGames(ids)
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		genres := ctx.games[id].Genres
+		if genres == nil {
+			genres = []string{}
+		}
+		values[i] = genres
+	}
+	return values, nil
+}
+
+func resolveGameCommunity(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	ids := gameIds(parents)
+	ctx.loadGames(ids)
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		if community := ctx.games[id].Community; community != nil {
+			values[i] = *community
+		}
+	}
+	return values, nil
+}
+
+func resolveGameCentrality(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	ids := gameIds(parents)
+	ctx.loadGames(ids)
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		if centrality := ctx.games[id].Centrality; centrality != nil {
+			values[i] = centrality
+		}
+	}
+	return values, nil
+}
+
+// resolveGameSimilar reads the game-links of every parent in one lookup and,
+// for cosine, the review counts of all their neighbours in another.
+func resolveGameSimilar(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	limit, err := argInt(args, "limit", defaultGraphQLListLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > maxPageLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %v", maxPageLimit)
+	}
+	metric, err := argString(args, "metric", "score")
+	if err != nil {
+		return nil, err
+	}
+	if !isSimilarityMetric(metric) {
+		return nil, fmt.Errorf("metric must be one of %v", strings.Join(similarityMetrics, ", "))
+	}
+
+	ids := gameIds(parents)
+	ctx.loadGameLinks(ids)
+
+	if metric == "cosine" {
+		countIds := append([]int{}, ids...)
+		for _, id := range ids {
+			for _, similarGame := range ctx.gameLinks[id].SimilarGames {
+				countIds = append(countIds, similarGame.GameId)
+			}
+		}
+		ctx.loadReviewCounts(countIds)
+	}
+
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		similarGames := ctx.gameLinks[id].SimilarGames
+		if metric == "jaccard" {
+			similarGames = ctx.gameLinks[id].ApproxSimilarGames
+		}
+
+		items := make([]gqlSimilarGame, 0, len(similarGames))
+		for _, similarGame := range similarGames {
+			items = append(items, gqlSimilarGame{
+				gameId:     similarGame.GameId,
+				similarity: similarGame,
+				value:      metricValue(similarGame, metric, ctx.reviewCounts[id], ctx.reviewCounts[similarGame.GameId]),
+			})
+		}
+		sort.SliceStable(items, func(a, b int) bool {
+			return items[a].value > items[b].value
+		})
+		if len(items) > limit {
+			items = items[:limit]
+		}
+
+		list := make([]interface{}, len(items))
+		for j, item := range items {
+			list[j] = item
+		}
+		values[i] = list
+	}
+	return values, nil
+}
+
+func resolveUserReviewedGames(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	ids := userIds(parents)
+	ctx.loadUserLinks(ids)
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = intList(ctx.userLinks[id].GamesReviewed)
+	}
+	return values, nil
+}
+
+// resolveUserRecommendations ranks item-CF neighbours like recommendForUser,
+// but reads the game-links of all users' reviewed games in one lookup.
+func resolveUserRecommendations(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	limit, err := argInt(args, "limit", defaultRecommendationLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > maxPageLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %v", maxPageLimit)
+	}
+	neighborsPerSeed, err := argInt(args, "neighbors", defaultNeighborsPerSeed)
+	if err != nil {
+		return nil, err
+	}
+	if neighborsPerSeed < 1 || neighborsPerSeed > maxPageLimit {
+		return nil, fmt.Errorf("neighbors must be between 1 and %v", maxPageLimit)
+	}
+
+	ids := userIds(parents)
+	ctx.loadUserLinks(ids)
+
+	var seeds []int
+	for _, id := range ids {
+		seeds = append(seeds, ctx.userLinks[id].GamesReviewed...)
+	}
+	ctx.loadGameLinks(seeds)
+
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		reviewed := ctx.userLinks[id].GamesReviewed
+		neighbors := make(map[int][]GameSimilarity)
+		for _, seed := range reviewed {
+			neighbors[seed] = ctx.gameLinks[seed].SimilarGames
+		}
+
+		recommendations := rankNeighbors(reviewed, neighbors, neighborsPerSeed, limit)
+		list := make([]interface{}, len(recommendations))
+		for j, recommendation := range recommendations {
+			list[j] = recommendation
+		}
+		values[i] = list
+	}
+	return values, nil
+}
+
+func resolveRecommendationBecause(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	values := make([]interface{}, len(parents))
+	for i, parent := range parents {
+		var seeds []int
+		for _, contribution := range parent.(Recommendation).Because {
+			seeds = append(seeds, contribution.GameId)
+		}
+		values[i] = intList(seeds)
+	}
+	return values, nil
+}
+
+func resolveGraphNodes(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	values := make([]interface{}, len(parents))
+	for i, parent := range parents {
+		var nodes []interface{}
+		for _, node := range parent.(Graph).Data {
+			nodes = append(nodes, gqlGraphNode{node})
+		}
+		values[i] = append([]interface{}{}, nodes...)
+	}
+	return values, nil
+}
+
+func resolveGraphEdges(ctx *gqlContext, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	values := make([]interface{}, len(parents))
+	for i, parent := range parents {
+		var edges []interface{}
+		for _, edge := range parent.(Graph).Edges {
+			edges = append(edges, edge)
+		}
+		values[i] = append([]interface{}{}, edges...)
+	}
+	return values, nil
+}
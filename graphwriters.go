@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type graphWriter interface {
+	extension() string
+	write(w io.Writer, graph Graph) error
+}
+
+var graphWriters = map[string]graphWriter{
+	"json":      legacyJSONWriter{},
+	"graphml":   graphMLWriter{},
+	"gexf":      gexfWriter{},
+	"dot":       dotWriter{},
+	"cytoscape": cytoscapeWriter{},
+	"d3":        d3Writer{},
+}
+
+func graphFormats() []string {
+	var formats []string
+	for format := range graphWriters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// legacyJSONWriter writes the {data:[{name,value,linkWith}]} shape the
+// front-end has always read.
+type legacyJSONWriter struct{}
+
+func (legacyJSONWriter) extension() string { return "json" }
+
+func (legacyJSONWriter) write(w io.Writer, graph Graph) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(graph)
+}
+
+type graphMLWriter struct{}
+
+func (graphMLWriter) extension() string { return "graphml" }
+
+func (graphMLWriter) write(w io.Writer, graph Graph) error {
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintln(b, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(b, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(b, `  <key id="name" for="node" attr.name="name" attr.type="string"/>`)
+	fmt.Fprintln(b, `  <key id="reviews" for="node" attr.name="reviews" attr.type="int"/>`)
+	fmt.Fprintln(b, `  <key id="depth" for="node" attr.name="depth" attr.type="int"/>`)
+	fmt.Fprintln(b, `  <key id="genres" for="node" attr.name="genres" attr.type="string"/>`)
+	fmt.Fprintln(b, `  <key id="weight" for="edge" attr.name="weight" attr.type="double"/>`)
+	fmt.Fprintln(b, `  <key id="similarity" for="edge" attr.name="similarity" attr.type="double"/>`)
+	fmt.Fprintln(b, `  <graph id="G" edgedefault="undirected">`)
+
+	for _, node := range graph.Data {
+		fmt.Fprintf(b, "    <node id=\"%v\">\n", node.Id)
+		fmt.Fprintf(b, "      <data key=\"name\">%s</data>\n", xmlEscape(node.Name))
+		fmt.Fprintf(b, "      <data key=\"reviews\">%v</data>\n", node.Value)
+		fmt.Fprintf(b, "      <data key=\"depth\">%v</data>\n", node.Depth)
+		fmt.Fprintf(b, "      <data key=\"genres\">%s</data>\n", xmlEscape(strings.Join(node.Genres, ";")))
+		fmt.Fprintln(b, "    </node>")
+	}
+	for i, edge := range graph.Edges {
+		fmt.Fprintf(b, "    <edge id=\"e%v\" source=\"%v\" target=\"%v\">\n", i, edge.Source, edge.Target)
+		fmt.Fprintf(b, "      <data key=\"weight\">%v</data>\n", edge.Weight)
+		fmt.Fprintf(b, "      <data key=\"similarity\">%v</data>\n", edge.Similarity)
+		fmt.Fprintln(b, "    </edge>")
+	}
+
+	fmt.Fprintln(b, "  </graph>")
+	fmt.Fprintln(b, "</graphml>")
+	return b.Flush()
+}
+
+type gexfWriter struct{}
+
+func (gexfWriter) extension() string { return "gexf" }
+
+func (gexfWriter) write(w io.Writer, graph Graph) error {
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintln(b, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(b, `<gexf xmlns="http://gexf.net/1.3" version="1.3">`)
+	fmt.Fprintln(b, `  <graph defaultedgetype="undirected">`)
+	fmt.Fprintln(b, `    <attributes class="node">`)
+	fmt.Fprintln(b, `      <attribute id="reviews" title="reviews" type="integer"/>`)
+	fmt.Fprintln(b, `      <attribute id="depth" title="depth" type="integer"/>`)
+	fmt.Fprintln(b, `      <attribute id="genres" title="genres" type="liststring"/>`)
+	fmt.Fprintln(b, `    </attributes>`)
+	fmt.Fprintln(b, `    <attributes class="edge">`)
+	fmt.Fprintln(b, `      <attribute id="similarity" title="similarity" type="double"/>`)
+	fmt.Fprintln(b, `    </attributes>`)
+
+	fmt.Fprintln(b, "    <nodes>")
+	for _, node := range graph.Data {
+		fmt.Fprintf(b, "      <node id=\"%v\" label=\"%s\">\n", node.Id, xmlEscape(node.Name))
+		fmt.Fprintln(b, "        <attvalues>")
+		fmt.Fprintf(b, "          <attvalue for=\"reviews\" value=\"%v\"/>\n", node.Value)
+		fmt.Fprintf(b, "          <attvalue for=\"depth\" value=\"%v\"/>\n", node.Depth)
+		fmt.Fprintf(b, "          <attvalue for=\"genres\" value=\"%s\"/>\n", xmlEscape("["+strings.Join(node.Genres, ",")+"]"))
+		fmt.Fprintln(b, "        </attvalues>")
+		fmt.Fprintln(b, "      </node>")
+	}
+	fmt.Fprintln(b, "    </nodes>")
+
+	fmt.Fprintln(b, "    <edges>")
+	for i, edge := range graph.Edges {
+		fmt.Fprintf(b, "      <edge id=\"%v\" source=\"%v\" target=\"%v\" weight=\"%v\">\n", i, edge.Source, edge.Target, edge.Weight)
+		fmt.Fprintf(b, "        <attvalues><attvalue for=\"similarity\" value=\"%v\"/></attvalues>\n", edge.Similarity)
+		fmt.Fprintln(b, "      </edge>")
+	}
+	fmt.Fprintln(b, "    </edges>")
+
+	fmt.Fprintln(b, "  </graph>")
+	fmt.Fprintln(b, "</gexf>")
+	return b.Flush()
+}
+
+type dotWriter struct{}
+
+func (dotWriter) extension() string { return "dot" }
+
+func (dotWriter) write(w io.Writer, graph Graph) error {
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintln(b, "graph games {")
+	for _, node := range graph.Data {
+		fmt.Fprintf(b, "  %v [label=%s, reviews=%v, depth=%v, genres=%s];\n",
+			node.Id, strconv.Quote(node.Name), node.Value, node.Depth, strconv.Quote(strings.Join(node.Genres, ";")))
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(b, "  %v -- %v [weight=%v, similarity=%v];\n", edge.Source, edge.Target, edge.Weight, edge.Similarity)
+	}
+	fmt.Fprintln(b, "}")
+	return b.Flush()
+}
+
+// cytoscapeWriter writes the elements JSON accepted by cytoscape.js and
+// the Cytoscape desktop importer.
+type cytoscapeWriter struct{}
+
+func (cytoscapeWriter) extension() string { return "cyjs" }
+
+func (cytoscapeWriter) write(w io.Writer, graph Graph) error {
+	type nodeData struct {
+		Id      string   `json:"id"`
+		Name    string   `json:"name"`
+		Reviews int      `json:"reviews"`
+		Depth   int      `json:"depth"`
+		Genres  []string `json:"genres"`
+	}
+	type edgeData struct {
+		Id         string  `json:"id"`
+		Source     string  `json:"source"`
+		Target     string  `json:"target"`
+		Weight     float64 `json:"weight"`
+		Similarity float64 `json:"similarity"`
+	}
+	type element struct {
+		Data interface{} `json:"data"`
+	}
+	var elements struct {
+		Elements struct {
+			Nodes []element `json:"nodes"`
+			Edges []element `json:"edges"`
+		} `json:"elements"`
+	}
+
+	elements.Elements.Nodes = []element{}
+	elements.Elements.Edges = []element{}
+	for _, node := range graph.Data {
+		genres := node.Genres
+		if genres == nil {
+			genres = []string{}
+		}
+		elements.Elements.Nodes = append(elements.Elements.Nodes, element{nodeData{
+			Id:      strconv.Itoa(node.Id),
+			Name:    node.Name,
+			Reviews: node.Value,
+			Depth:   node.Depth,
+			Genres:  genres,
+		}})
+	}
+	for i, edge := range graph.Edges {
+		elements.Elements.Edges = append(elements.Elements.Edges, element{edgeData{
+			Id:         "e" + strconv.Itoa(i),
+			Source:     strconv.Itoa(edge.Source),
+			Target:     strconv.Itoa(edge.Target),
+			Weight:     edge.Weight,
+			Similarity: edge.Similarity,
+		}})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(elements)
+}
+
+// d3Writer writes the {nodes, links} shape used by d3-force examples.
+type d3Writer struct{}
+
+func (d3Writer) extension() string { return "d3.json" }
+
+func (d3Writer) write(w io.Writer, graph Graph) error {
+	type node struct {
+		Id     int      `json:"id"`
+		Name   string   `json:"name"`
+		Value  int      `json:"value"`
+		Depth  int      `json:"depth"`
+		Genres []string `json:"genres"`
+	}
+	type link struct {
+		Source     int     `json:"source"`
+		Target     int     `json:"target"`
+		Value      float64 `json:"value"`
+		Similarity float64 `json:"similarity"`
+	}
+	d3 := struct {
+		Nodes []node `json:"nodes"`
+		Links []link `json:"links"`
+	}{Nodes: []node{}, Links: []link{}}
+
+	for _, n := range graph.Data {
+		genres := n.Genres
+		if genres == nil {
+			genres = []string{}
+		}
+		d3.Nodes = append(d3.Nodes, node{Id: n.Id, Name: n.Name, Value: n.Value, Depth: n.Depth, Genres: genres})
+	}
+	for _, edge := range graph.Edges {
+		d3.Links = append(d3.Links, link{Source: edge.Source, Target: edge.Target, Value: edge.Weight, Similarity: edge.Similarity})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(d3)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Logging is configured from the environment:
+//
+//	LOG_LEVEL        debug, info, warn or error (info)
+//	LOG_FORMAT       json or text (json)
+//	LOG_OUTPUT       stdout, stderr or a file path (stdout)
+//	LOG_MAX_SIZE_MB  size at which a log file is rotated (100)
+//	LOG_MAX_BACKUPS  rotated files kept next to it (5)
+
+const defaultLogMaxSizeMB = 100
+const defaultLogMaxBackups = 5
+
+func initLogs() {
+	level, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	output, err := logOutput(os.Getenv("LOG_OUTPUT"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "json":
+		handler = slog.NewJSONHandler(output, handlerOptions)
+	case "text":
+		handler = slog.NewTextHandler(output, handlerOptions)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown LOG_FORMAT %q\n", format)
+		os.Exit(2)
+	}
+
+	// Also routes anything still written through the log package
+	slog.SetDefault(slog.New(handler))
+}
+
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown LOG_LEVEL %q", value)
+}
+
+func logOutput(value string) (io.Writer, error) {
+	switch value {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+
+	maxSizeMB, err := envInt("LOG_MAX_SIZE_MB", defaultLogMaxSizeMB)
+	if err != nil {
+		return nil, err
+	}
+	maxBackups, err := envInt("LOG_MAX_BACKUPS", defaultLogMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return openRotatingFile(value, int64(maxSizeMB)<<20, maxBackups)
+}
+
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%v must be a non negative integer", name)
+	}
+	return n, nil
+}
+
+// fatal logs at error level and exits, in place of log.Fatal.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// rotatingFile renames the file to path.1, shifting older backups up to
+// path.<backups>, once it grows past maxBytes.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	backups  int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxBytes int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxBytes: maxBytes, backups: backups}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	if r.backups == 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return r.open()
+	}
+
+	for i := r.backups - 1; i >= 1; i-- {
+		from := r.path + "." + strconv.Itoa(i)
+		if fileExists(from) {
+			if err := os.Rename(from, r.path+"."+strconv.Itoa(i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
@@ -4,9 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
@@ -20,7 +19,7 @@ import (
 
 var savedGames int32
 
-const progressfilename = "progress.txt"
+var progressfilename = "progress.txt"
 
 func getMe() int32 {
 	return atomic.LoadInt32(&savedGames)
@@ -30,103 +29,64 @@ func setMe(me int32) {
 	atomic.StoreInt32(&savedGames, me)
 }
 
-const SteamBaseUrl = "https://store.steampowered.com/appreviews/"
-const steamEntryDetailsUrl = "https://store.steampowered.com/api/appdetails?appids="
-const steamStoreEntriesUrl = "http://api.steampowered.com/ISteamApps/GetAppList/v0002/?key=STEAMKEY&format=json"
+var SteamBaseUrl = "https://store.steampowered.com/appreviews/"
+var steamEntryDetailsUrl = "https://store.steampowered.com/api/appdetails?appids="
+var steamStoreEntriesUrl = "http://api.steampowered.com/ISteamApps/GetAppList/v0002/?key=STEAMKEY&format=json"
+
+// setSteamUrl points every Steam endpoint at one host, such as fake-steam.
+func setSteamUrl(base string) {
+	base = strings.TrimSuffix(base, "/")
+	SteamBaseUrl = base + "/appreviews/"
+	steamEntryDetailsUrl = base + "/api/appdetails?appids="
+	steamStoreEntriesUrl = base + "/ISteamApps/GetAppList/v0002/?key=STEAMKEY&format=json"
+}
 
 var database DataBase
+
+// Waits that keep the crawler under the Steam rate limit
+var reviewPageDelay = 3 * time.Second
+var reviewGameDelay = 5 * time.Minute
+var entryDetailsDelay = time.Second
+
+// Waits after Steam refuses a request
+var reviewErrorBackoff = 15 * time.Minute
+var entryDetailsBackoff = 150 * time.Second
+
+// Games with fewer reviews on Steam aren't crawled, and crawls that got no
+// more reviews than minSavedReviewers aren't saved
+const minReviewCount = 2500
+const minSavedReviewers = 100
+
 var databaseUrl = os.Getenv("DATABASE_URL")
+var databaseName = "valkyrie"
 
 //csgo 730
 //siege 359550
 //dota 2 570
 //dota 2 570
 
-func init() {
-	database.initDatabase(databaseUrl)
-
-	initLogs()
-}
-
-func initLogs() {
-	logFile, err := os.OpenFile("log.txt", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
-	if err != nil {
-		panic(err)
-	}
-	mw := io.MultiWriter(os.Stdout, logFile)
-	log.SetOutput(mw)
-}
-
 func main() {
-	log.Println("Starting application")
-	//initStoreEntries()
-
-	//filterGames()
-
-	//processReviews()
-
-	//processUserLinks()
-
-	//populateGameSimilarities()
-
-	generateGraph(892970)
-}
-
-func generateGraph(gameId int) {
-	defer timeTrack(time.Now(), "generateGraph")
-
-	log.Println("Generating graph")
-
-	gameNodesMap := make(map[int]*GameNode)
-	reviewCountMap := make(map[int]int)
+	initLogs()
+	slog.Info("starting application")
 
-	reviews := getAllGameReviews()
-	for _, review := range reviews {
-		reviewCountMap[review.AppId] = len(review.Users)
+	name, args := "graph", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
 
-	gameLink := database.findGameLink(gameId)
-	gameLink.SimilarGames = gameLink.SimilarGames[:20]
-
-	var relatedGames []int
-	for _, similarGame := range gameLink.SimilarGames {
-		relatedGames = append(relatedGames, similarGame.GameId)
-		gameNodesMap[similarGame.GameId] = &GameNode{}
-		gameNodesMap[similarGame.GameId].Links = []string{}
-		gameNodesMap[similarGame.GameId].LinkedIds = []int{}
+	cmd, ok := findCommand(name)
+	if !ok {
+		printUsage()
+		os.Exit(2)
 	}
 
-	gameNameMap := populateGameNameMap()
-
-	gameNodesMap[gameId] = &GameNode{}
-	gameNodesMap[gameId].LinkedIds = relatedGames
-
-	//Fill in all names, size
-	for gameId, node := range gameNodesMap {
-		node.Name = gameNameMap[gameId]
-
-		for _, linkedId := range node.LinkedIds {
-			node.Links = append(node.Links, gameNameMap[linkedId])
-		}
-
-		node.Value = reviewCountMap[gameId]
+	if dbName := os.Getenv("DATABASE_NAME"); dbName != "" {
+		databaseName = dbName
 	}
-
-	var graph Graph
-	var nodesList []GameNode
-	for _, node := range gameNodesMap {
-		nodesList = append(nodesList, *node)
+	if !offlineCommands[name] {
+		database.initDatabase(databaseUrl)
 	}
-
-	graph.Data = nodesList
-
-	log.Println("Saving graph")
-	//database.saveGraph(graph)
-
-	file, _ := json.MarshalIndent(graph, "", " ")
-
-	_ = ioutil.WriteFile("test.json", file, 0644)
-
+	cmd.run(args)
 }
 
 func populateGameNameMap() map[int]string {
@@ -179,13 +139,19 @@ func populateGameSimilarities() {
 	err := cursor.All(context.TODO(), &gameReviewsList)
 	check(err)
 
+	// Touched on both ends so that a partial run also invalidates cached graphs
+	database.touchCollection(gameLinksCollection)
+	progress := newProgressTracker("similarities", len(gameReviewsList))
 	for _, review := range gameReviewsList {
 		processGameLink(review.AppId)
+		progress.processed()
 	}
+	progress.finish()
+	database.touchCollection(gameLinksCollection)
 }
 
 func processGameLink(gameId int) {
-	log.Printf("Saving game link for %v \n", gameId)
+	slog.Debug("saving game link", "stage", "similarities", "appid", gameId)
 
 	similarities := findSimilarGames(gameId)
 
@@ -198,13 +164,16 @@ func addWeightedScore(similarities []GameSimilarity) []GameSimilarity {
 	var result []GameSimilarity
 	for _, game := range similarities {
 		reviewCount := len(database.findGameReview(game.GameId).Users)
-		log.Printf("\n\n no of reviews %v \n\n", reviewCount)
+		slog.Debug("review count", "appid", game.GameId, "reviews", reviewCount)
 		game.WeightedCount = float32(game.Count) / float32(reviewCount)
 
 		result = append(result, game)
 	}
-	sort.Slice(similarities, func(i, j int) bool {
-		return similarities[i].WeightedCount > similarities[j].WeightedCount
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].WeightedCount != result[j].WeightedCount {
+			return result[i].WeightedCount > result[j].WeightedCount
+		}
+		return result[i].GameId < result[j].GameId
 	})
 
 	return result
@@ -219,33 +188,55 @@ func findSimilarGames(gameId int) []GameSimilarity {
 	if len(userIds) == 0 {
 		return []GameSimilarity{}
 	}
-	userLinks := database.findUserLinks(userIds)
+	return rankSimilarGames(gameId, database.findUserLinks(userIds))
+}
 
-	similarGameMap := make(map[int]int)
+// rankSimilarGames counts the other games reviewed by the reviewers of a
+// game, strongest first.
+func rankSimilarGames(gameId int, userLinks []UserLinkDTO) []GameSimilarity {
+	similarGameMap := make(map[int]*GameSimilarity)
 
 	for _, userLink := range userLinks {
-		for _, game := range userLink.GamesReviewed {
-			similarGameMap[game]++
+		seedPlaytime := reviewedPlaytime(userLink, gameId)
+
+		for i, game := range userLink.GamesReviewed {
+			//Exclude self
+			if game == gameId {
+				continue
+			}
+			similarity, ok := similarGameMap[game]
+			if !ok {
+				similarity = &GameSimilarity{GameId: game, Weighting: weighting.name()}
+				similarGameMap[game] = similarity
+			}
+			similarity.Count++
+			similarity.Score += weighting.pairWeight(seedPlaytime, playtimeAt(userLink.Playtimes, i))
 		}
 	}
 
-	var sortedSlice []GameSimilarity
-	for k, v := range similarGameMap {
-		sortedSlice = append(sortedSlice, GameSimilarity{GameId: k, Count: v})
+	sortedSlice := []GameSimilarity{}
+	for _, similarity := range similarGameMap {
+		sortedSlice = append(sortedSlice, *similarity)
 	}
 
 	sort.Slice(sortedSlice, func(i, j int) bool {
-		return sortedSlice[i].Count > sortedSlice[j].Count
+		if sortedSlice[i].Score != sortedSlice[j].Score {
+			return sortedSlice[i].Score > sortedSlice[j].Score
+		}
+		if sortedSlice[i].Count != sortedSlice[j].Count {
+			return sortedSlice[i].Count > sortedSlice[j].Count
+		}
+		return sortedSlice[i].GameId < sortedSlice[j].GameId
 	})
-	//Exclude self
-	return sortedSlice[1:]
+	return sortedSlice
 }
 
 func processUserLinks() {
 	defer timeTrack(time.Now(), "processUserLinks")
 
-	log.Println("Processing User links")
+	slog.Info("processing user links", "stage", "user-links")
 
+	progress := newProgressTracker("user-links", int(database.countDocuments(gameReviewsCollection)))
 	cursor := database.findGameReviews()
 
 	for cursor.Next(context.TODO()) {
@@ -253,11 +244,13 @@ func processUserLinks() {
 		err := cursor.Decode(&review)
 		check(err)
 
-		log.Printf("\n\nProcessing %v\n\n", review.AppId)
+		slog.Info("processing game", "stage", "user-links", "appid", review.AppId)
 
 		saveUserGameLinks(review)
+		progress.processed()
 
 	}
+	progress.finish()
 }
 
 func saveUserGameLinks(review GameReviewDTO) {
@@ -265,27 +258,28 @@ func saveUserGameLinks(review GameReviewDTO) {
 
 	userIds := review.Users
 
-	log.Printf("Processing %v users\n", len(userIds))
+	slog.Debug("processing users", "stage", "user-links", "appid", review.AppId, "users", len(userIds))
 
-	userIdsMap := make(map[string]bool)
-	for _, userId := range userIds {
-		userIdsMap[userId] = true
+	userIdsMap := make(map[string]int)
+	for i, userId := range userIds {
+		userIdsMap[userId] = playtimeAt(review.Playtimes, i)
 	}
-	log.Printf("Processing %v distinct users\n", len(userIdsMap))
+	slog.Debug("processing distinct users", "stage", "user-links", "appid", review.AppId, "users", len(userIdsMap))
 
 	var wg sync.WaitGroup
 
-	for userId := range userIdsMap {
+	for userId, playtime := range userIdsMap {
 		wg.Add(1)
-		go saveUserGameLink(review.AppId, userId, &wg)
+		workerStarted("user-links")
+		go saveUserGameLink(review.AppId, userId, playtime, &wg)
 	}
 
 	wg.Wait()
-	log.Println()
 }
 
-func saveUserGameLink(gameId int, userId string, wg *sync.WaitGroup) {
+func saveUserGameLink(gameId int, userId string, playtime int, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer workerDone("user-links")
 
 	userLink := database.findUserLink(userId)
 
@@ -294,10 +288,12 @@ func saveUserGameLink(gameId int, userId string, wg *sync.WaitGroup) {
 		if alreadyExists {
 			return
 		}
+		userLink.Playtimes = append(alignPlaytimes(userLink), playtime)
 		userLink.GamesReviewed = append(userLink.GamesReviewed, gameId)
 	} else {
 		userLink.UserId = userId
 		userLink.GamesReviewed = []int{gameId}
+		userLink.Playtimes = []int{playtime}
 	}
 
 	database.updateUserLink(userLink)
@@ -320,40 +316,60 @@ func processReviews() {
 	check(err)
 
 	lastProcessedGame := database.findLastProcessedReview()
+	progress := newProgressTracker("reviews", len(games))
+
+	attempt := 1
+	for i := 0; i < len(games); i++ {
+		game := games[i]
 
-	for i, game := range games {
 		if game.ID <= lastProcessedGame.AppId {
+			progress.skipped()
 			continue
 		}
-		log.Printf("Processing reviews for %v %v\n\n", game.Name, game.ID)
+		slog.Info("processing reviews", "stage", "reviews", "appid", game.ID, "name", game.Name, "attempt", attempt)
 
 		gameReview, apiError := getReviews(game.ID)
+		if errors.Is(apiError, errNotCached) {
+			slog.Warn("reviews not cached, skipping offline", "stage", "reviews", "appid", game.ID)
+			progress.failed()
+			continue
+		}
 		if apiError != nil {
-			log.Printf("\n Error while processing reviews for %v\n", game.Name)
-
-			time.Sleep(time.Minute * 15)
+			if attempt == maxAttempts {
+				slog.Error("giving up on game", "stage", "reviews", "appid", game.ID, "attempt", attempt, "error", apiError)
+				progress.failed()
+				attempt = 1
+				continue
+			}
+			wait := reviewErrorBackoff
+			slog.Warn("steam API error, backing off", "stage", "reviews", "appid", game.ID, "attempt", attempt, "error", apiError, "wait", wait)
+			progress.retried()
+
+			time.Sleep(wait)
+			attempt++
 			i--
 			continue
 		}
+		attempt = 1
 		var gameSaved bool
 
-		if len(gameReview.Users) > 100 {
+		if len(gameReview.Users) > minSavedReviewers {
 			saveGameReviews(gameReview)
 			gameSaved = true
 		}
 
-		log.Printf("Finished processing reviews for %v %v\n\n", game.Name, game.ID)
-		log.Printf("\n %.2f percent done\n", (float32(i)/float32(len(games)))*100)
+		slog.Info("finished processing reviews", "stage", "reviews", "appid", game.ID, "saved", gameSaved)
+		progress.processed()
 
 		if gameSaved {
-			time.Sleep(time.Minute * 5)
+			time.Sleep(reviewGameDelay)
 		}
 	}
-
+	progress.finish()
 }
 
 func saveGameReviews(review GameReviewDTO) {
-	log.Printf("\n Saving reviews \n\n")
+	slog.Info("saving reviews", "stage", "reviews", "appid", review.AppId, "users", len(review.Users))
 	database.saveGameReview(review)
 }
 
@@ -374,60 +390,79 @@ func filterGames() {
 		processedGamesMap[entry.ID] = true
 	}
 
-	log.Printf("Fetched entries from db \n")
+	slog.Info("fetched entries", "stage", "filter-games", "entries", len(storeEntriesList), "processed", len(processedGamesList))
 
 	lastProcessedId := findLastProcessedAppId()
-	log.Printf("Last processed id %v\n\n\n", lastProcessedId)
+	slog.Info("resuming", "stage", "filter-games", "appid", lastProcessedId)
 
 	savedGamesCount := 0
+	progress := newProgressTracker("filter-games", len(storeEntriesList))
 
+	attempt := 1
 	for i := 0; i < len(storeEntriesList); i++ {
 		entry := storeEntriesList[i]
 
 		if processedGamesMap[entry.ID] {
-			log.Printf("\n Already processed game %v \n\n\n", entry)
+			slog.Debug("already processed", "stage", "filter-games", "appid", entry.ID)
+			progress.skipped()
 			continue
 		}
 
 		if entry.ID <= lastProcessedId {
-			log.Printf("Skipping %s %v\n", entry.Name, entry.ID)
+			slog.Debug("skipping", "stage", "filter-games", "appid", entry.ID)
+			progress.skipped()
 			continue
 		}
 
 		isGame, steamError := processStoreEntry(entry)
 
+		if errors.Is(steamError, errNotCached) {
+			slog.Warn("details not cached, skipping offline", "stage", "filter-games", "appid", entry.ID)
+			progress.failed()
+			continue
+		}
 		if steamError != nil {
-			const waitSeconds = 150
-			log.Println("Rate limit reached")
-			log.Printf("\nWaiting for %vs \n", waitSeconds)
-			time.Sleep(time.Second * waitSeconds)
-
+			if attempt == maxAttempts {
+				slog.Error("giving up on entry", "stage", "filter-games", "appid", entry.ID, "attempt", attempt, "error", steamError)
+				progress.failed()
+				attempt = 1
+				continue
+			}
+			wait := entryDetailsBackoff
+			slog.Warn("rate limit reached, backing off", "stage", "filter-games", "appid", entry.ID, "attempt", attempt, "error", steamError, "wait", wait)
+			progress.retried()
+			time.Sleep(wait)
+
+			attempt++
 			i--
 			continue
 		}
+		attempt = 1
 		if isGame {
 			savedGamesCount++
 
-			log.Printf("\n\n\n Saved %v new games \n\n", savedGamesCount)
+			slog.Info("saved game", "stage", "filter-games", "appid", entry.ID, "saved", savedGamesCount)
 		}
 		updateProgress(entry.ID)
-
-		log.Printf("%.2f percent done\n", (float32(i)/float32(len(storeEntriesList)))*100)
+		progress.processed()
 	}
+	progress.finish()
 }
 
 func processStoreEntry(storeEntry StoreEntryDTO) (bool, error) {
-	log.Printf("Processing: %v %v ----------------\n", storeEntry.Name, storeEntry.ID)
+	slog.Debug("processing entry", "stage", "filter-games", "appid", storeEntry.ID, "name", storeEntry.Name)
 
 	details, steamAPIerr := getStoreEntryDetails(storeEntry.ID)
 
 	if steamAPIerr != nil {
 		return false, steamAPIerr
 	}
-	time.Sleep(time.Second * 1)
 
 	if details.Data.Type == "game" {
-		log.Printf("Saving %v %v \n\n", storeEntry.Name, storeEntry.ID)
+		for _, genre := range details.Data.Genres {
+			storeEntry.Genres = append(storeEntry.Genres, genre.Description)
+		}
+		slog.Debug("saving game", "stage", "filter-games", "appid", storeEntry.ID, "name", storeEntry.Name)
 		database.saveGame(storeEntry)
 		return true, nil
 	}
@@ -435,10 +470,59 @@ func processStoreEntry(storeEntry StoreEntryDTO) (bool, error) {
 
 }
 
+// backfillGenres refetches the details of the games saved before genres were
+// recorded, retrying like filterGames. Games without genres on Steam get an
+// empty list, so they aren't fetched again.
+func backfillGenres() {
+	defer timeTrack(time.Now(), "backfillGenres")
+
+	games := database.findGamesWithoutGenres()
+	slog.Info("backfilling genres", "stage", "backfill-genres", "games", len(games))
+	progress := newProgressTracker("backfill-genres", len(games))
+
+	attempt := 1
+	for i := 0; i < len(games); i++ {
+		game := games[i]
+
+		details, steamError := getStoreEntryDetails(game.ID)
+		if errors.Is(steamError, errNotCached) {
+			slog.Warn("details not cached, skipping offline", "stage", "backfill-genres", "appid", game.ID)
+			progress.failed()
+			continue
+		}
+		if steamError != nil {
+			if attempt == maxAttempts {
+				slog.Error("giving up on game", "stage", "backfill-genres", "appid", game.ID, "attempt", attempt, "error", steamError)
+				progress.failed()
+				attempt = 1
+				continue
+			}
+			wait := entryDetailsBackoff
+			slog.Warn("rate limit reached, backing off", "stage", "backfill-genres", "appid", game.ID, "attempt", attempt, "error", steamError, "wait", wait)
+			progress.retried()
+			time.Sleep(wait)
+			attempt++
+			i--
+			continue
+		}
+		attempt = 1
+
+		genres := []string{}
+		for _, genre := range details.Data.Genres {
+			genres = append(genres, genre.Description)
+		}
+		database.setGameGenres(game.ID, genres)
+		slog.Debug("saved genres", "stage", "backfill-genres", "appid", game.ID, "genres", genres)
+		progress.processed()
+	}
+	progress.finish()
+	database.touchCollection(gamesCollectionName)
+}
+
 func findLastProcessedAppId() int {
 
 	if !fileExists(progressfilename) {
-		log.Println("Previous progress not found")
+		slog.Info("previous progress not found", "stage", "filter-games")
 		updateProgress(0)
 		return 0
 	}
@@ -488,38 +572,43 @@ func initStoreEntries() {
 func saveStoreEntries(entries []StoreEntry) {
 	defer timeTrack(time.Now(), "saveStoreEntries")
 
-	log.Printf("Saving %v entries into database\n", len(entries))
+	slog.Info("saving entries", "stage", "store-entries", "entries", len(entries))
 
 	var wg sync.WaitGroup
 
+	progress := newProgressTracker("store-entries", len(entries))
 	for _, entry := range entries {
 		wg.Add(1)
-		go saveEntry(entry, &wg)
+		workerStarted("store-entries")
+		go saveEntry(entry, progress, &wg)
 	}
 	wg.Wait()
+	progress.finish()
 }
 
-func saveEntry(entry StoreEntry, wg *sync.WaitGroup) {
+func saveEntry(entry StoreEntry, progress *progressTracker, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer workerDone("store-entries")
 
 	setMe(getMe() + 1)
-	log.Printf("Saved %v games\n", getMe())
+	slog.Debug("saved entry", "stage", "store-entries", "appid", entry.AppId, "saved", getMe())
 	database.saveStoreEntry(entry)
+	progress.processed()
 
 }
 
 func fetchStoreEntries() []StoreEntry {
 	defer timeTrack(time.Now(), "fetchStoreEntries")
 
-	log.Println("Fetching items from steam store")
+	slog.Info("fetching app list", "stage", "store-entries")
 
-	res, err := http.Get(steamStoreEntriesUrl)
+	res, err := steamClient.Get(steamStoreEntriesUrl)
 	if err != nil {
-		log.Fatal(err)
+		fatal("steam request failed", "error", err)
 	}
 	steamEntriesResponse := StoreEntriesResponse{}
 	parseResponse(res, &steamEntriesResponse)
-	log.Printf("Found %v entries\n", len(steamEntriesResponse.AppList.Apps))
+	slog.Info("fetched app list", "stage", "store-entries", "entries", len(steamEntriesResponse.AppList.Apps))
 	return steamEntriesResponse.AppList.Apps
 }
 
@@ -536,13 +625,15 @@ func getReviews(gameId int) (GameReviewDTO, error) {
 	cursorMap := make(map[string]bool)
 
 	steamUrl := getGameUrl(gameIdString, "*")
-	log.Println("Url is " + steamUrl.String())
+	slog.Debug("fetching reviews", "stage", "reviews", "appid", gameId, "cursor", "*")
 
-	res, err := http.Get(steamUrl.String())
-	check(err)
+	res, err := steamClient.Get(steamUrl.String())
+	if err != nil {
+		return GameReviewDTO{}, err
+	}
 
 	if res.StatusCode != 200 {
-		log.Printf("\nAPI rate limit reached \n\n")
+		slog.Warn("steam API rate limit reached", "stage", "reviews", "appid", gameId, "cursor", "*", "status", res.StatusCode)
 		return GameReviewDTO{}, errors.New("api rate limit exceeded")
 	}
 
@@ -550,15 +641,14 @@ func getReviews(gameId int) (GameReviewDTO, error) {
 	gameResponse := GameResponse{}
 	parseResponse(res, &gameResponse)
 
-	log.Printf("Game has %v reviews\n", gameResponse.QuerySummary.TotalReviews)
-	const minReviewCount = 2500
+	slog.Info("review summary", "stage", "reviews", "appid", gameId, "reviews", gameResponse.QuerySummary.TotalReviews)
 
 	if gameResponse.QuerySummary.TotalReviews < minReviewCount {
-		log.Printf("Skipping game \n")
+		slog.Info("too few reviews, skipping", "stage", "reviews", "appid", gameId)
 		return gameReviews, nil
 	}
 
-	log.Printf("\n Fetched %v reviews \n", len(gameResponse.Reviews))
+	slog.Debug("fetched reviews", "stage", "reviews", "appid", gameId, "cursor", "*", "reviews", len(gameResponse.Reviews))
 
 	gameReviews = appendReviews(gameResponse, gameReviews)
 
@@ -567,26 +657,30 @@ func getReviews(gameId int) (GameReviewDTO, error) {
 
 		steamUrl = getGameUrl(gameIdString, gameResponse.Cursor)
 
-		log.Println("Url is " + steamUrl.String())
+		slog.Debug("fetching reviews", "stage", "reviews", "appid", gameId, "cursor", gameResponse.Cursor)
 
-		res, err := http.Get(steamUrl.String())
-		check(err)
+		res, err := steamClient.Get(steamUrl.String())
+		if err != nil {
+			return GameReviewDTO{}, err
+		}
 		if res.StatusCode != 200 {
-			log.Printf("\nAPI rate limit reached \n\n")
+			slog.Warn("steam API rate limit reached", "stage", "reviews", "appid", gameId, "cursor", gameResponse.Cursor, "status", res.StatusCode)
 			return GameReviewDTO{}, errors.New("api rate limit exceeded")
 		}
 
 		parseResponse(res, &gameResponse)
 
-		log.Printf("\n Fetched %v reviews \n", len(gameResponse.Reviews))
+		slog.Debug("fetched reviews", "stage", "reviews", "appid", gameId, "reviews", len(gameResponse.Reviews))
 
 		gameReviews = appendReviews(gameResponse, gameReviews)
 
-		time.Sleep(time.Second * 3)
+		if !servedFromCache(res) {
+			time.Sleep(reviewPageDelay)
+		}
 	}
 
 	end := time.Now()
-	log.Printf("Total no of reviews: %v in %v s", strconv.Itoa(len(gameReviews.Users)), end.Unix()-start.Unix())
+	slog.Info("fetched all reviews", "stage", "reviews", "appid", gameId, "reviews", len(gameReviews.Users), "duration", end.Sub(start))
 
 	return gameReviews, nil
 }
@@ -594,6 +688,7 @@ func getReviews(gameId int) (GameReviewDTO, error) {
 func appendReviews(gameResponse GameResponse, gameReviews GameReviewDTO) GameReviewDTO {
 	for _, review := range gameResponse.Reviews {
 		gameReviews.Users = append(gameReviews.Users, review.Author.SteamId)
+		gameReviews.Playtimes = append(gameReviews.Playtimes, review.Author.PlaytimeAtReview)
 		//gameReviews.Reviews = append(gameReviews.Reviews, review.Review)
 	}
 	return gameReviews
@@ -602,7 +697,7 @@ func appendReviews(gameResponse GameResponse, gameReviews GameReviewDTO) GameRev
 func parseResponse(res *http.Response, value interface{}) {
 	bodyBytes, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		log.Fatal(err)
+		fatal("reading steam response failed", "error", err)
 	}
 
 	err = json.Unmarshal(bodyBytes, value)
@@ -631,17 +726,21 @@ func getGameUrl(gameId string, cursor string) *url.URL {
 }
 
 func getStoreEntryDetails(id int) (EntryDetails, error) {
-	res, err := http.Get(steamEntryDetailsUrl + strconv.Itoa(id))
+	res, err := steamClient.Get(steamEntryDetailsUrl + strconv.Itoa(id))
 	if err != nil {
-		log.Fatal(err)
+		return EntryDetails{}, err
 	}
 	if res.StatusCode != 200 {
-		log.Printf("\n\n Steam API failed \n\n\n\n")
+		slog.Warn("steam API failed", "stage", "filter-games", "appid", id, "status", res.StatusCode)
 		return EntryDetails{}, errors.New("rate limit exceeded")
 	}
 	entryDetailsResponse := EntryDetailsResponse{}
 
 	parseResponse(res, &entryDetailsResponse)
 
+	if !servedFromCache(res) {
+		time.Sleep(entryDetailsDelay)
+	}
+
 	return entryDetailsResponse[strconv.Itoa(id)], nil
 }
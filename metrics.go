@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exposed in the Prometheus text format on /metrics.
+
+const defaultMetricsAddr = ":2112"
+
+var steamRequestBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+var dbOperationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+var metricFamilies []*metricFamily
+
+var (
+	steamRequests       = newCounter("steam_requests_total", "Steam API requests by endpoint and status code.", "endpoint", "code")
+	steamRequestErrors  = newCounter("steam_request_errors_total", "Steam API requests that failed without a response.", "endpoint")
+	steamRateLimited    = newCounter("steam_rate_limited_total", "Steam API responses with status 429.", "endpoint")
+	steamRequestSeconds = newHistogram("steam_request_duration_seconds", "Latency of Steam API requests.", steamRequestBuckets, "endpoint")
+	steamCacheRequests  = newCounter("steam_cache_requests_total", "Steam API requests looked up in the response cache, by result: hit, miss or expired.", "endpoint", "result")
+
+	dbOperationSeconds = newHistogram("db_operation_duration_seconds", "Latency of MongoDB commands.", dbOperationBuckets, "command", "collection")
+	dbOperationErrors  = newCounter("db_operation_errors_total", "MongoDB commands that failed.", "command", "collection")
+	dbConnectionsInUse = newGauge("db_pool_connections_in_use", "MongoDB connections checked out of the pool.")
+	dbMaxConnections   = newGauge("db_pool_max_connections", "Size of the MongoDB connection pool.")
+
+	stageItems     = newCounter("stage_items_total", "Items done by each pipeline stage, by outcome: processed, skipped or failed.", "stage", "outcome")
+	stageRemaining = newGauge("stage_items_remaining", "Items left for each pipeline stage.", "stage")
+
+	workersActive = newGauge("worker_pool_active", "Goroutines of a worker pool currently running.", "pool")
+	workersTotal  = newCounter("worker_pool_started_total", "Goroutines started by a worker pool.", "pool")
+)
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	// Histograms only, counts[i] is the number of observations <= buckets[i]
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newMetricFamily(name string, help string, kind string, buckets []float64, labels []string) *metricFamily {
+	family := &metricFamily{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*metricSeries),
+	}
+	metricFamilies = append(metricFamilies, family)
+	return family
+}
+
+func newCounter(name string, help string, labels ...string) *metricFamily {
+	return newMetricFamily(name, help, "counter", nil, labels)
+}
+
+func newGauge(name string, help string, labels ...string) *metricFamily {
+	return newMetricFamily(name, help, "gauge", nil, labels)
+}
+
+func newHistogram(name string, help string, buckets []float64, labels ...string) *metricFamily {
+	return newMetricFamily(name, help, "histogram", buckets, labels)
+}
+
+// with returns the series of the label values, creating it. Callers hold mu.
+func (f *metricFamily) with(labelValues []string) *metricSeries {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %v takes %v labels, got %v", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	series, ok := f.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: labelValues}
+		if f.kind == "histogram" {
+			series.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = series
+	}
+	return series
+}
+
+func (f *metricFamily) add(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.with(labelValues).value += value
+}
+
+func (f *metricFamily) inc(labelValues ...string) {
+	f.add(1, labelValues...)
+}
+
+func (f *metricFamily) set(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.with(labelValues).value = value
+}
+
+func (f *metricFamily) observe(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	series := f.with(labelValues)
+	for i, bound := range f.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func (f *metricFamily) since(start time.Time, labelValues ...string) {
+	f.observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (f *metricFamily) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %v %v\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%v%v %v\n", f.name, formatLabels(f.labels, series.labelValues, ""), formatMetricValue(series.value))
+			continue
+		}
+		for i, bound := range f.buckets {
+			le := formatMetricValue(bound)
+			fmt.Fprintf(w, "%v_bucket%v %v\n", f.name, formatLabels(f.labels, series.labelValues, le), series.counts[i])
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", f.name, formatLabels(f.labels, series.labelValues, "+Inf"), series.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", f.name, formatLabels(f.labels, series.labelValues, ""), formatMetricValue(series.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", f.name, formatLabels(f.labels, series.labelValues, ""), series.count)
+	}
+}
+
+// formatLabels renders {a="1",b="2"}, adding le for histogram buckets.
+func formatLabels(names []string, values []string, le string) string {
+	if le != "" {
+		names = append(append([]string{}, names...), "le")
+		values = append(append([]string{}, values...), le)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var processStart = time.Now()
+
+func writeMetrics(w io.Writer) {
+	buffered := bufio.NewWriter(w)
+	for _, family := range metricFamilies {
+		family.write(buffered)
+	}
+
+	fmt.Fprintf(buffered, "# HELP go_goroutines Number of goroutines that currently exist.\n")
+	fmt.Fprintf(buffered, "# TYPE go_goroutines gauge\n")
+	fmt.Fprintf(buffered, "go_goroutines %v\n", runtime.NumGoroutine())
+	fmt.Fprintf(buffered, "# HELP process_start_time_seconds Start time of the process since unix epoch in seconds.\n")
+	fmt.Fprintf(buffered, "# TYPE process_start_time_seconds gauge\n")
+	fmt.Fprintf(buffered, "process_start_time_seconds %v\n", processStart.Unix())
+	buffered.Flush()
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
+
+// metricsAddrFlag adds --metrics-addr to a long running command.
+func metricsAddrFlag(flags *flag.FlagSet) *string {
+	return flags.String("metrics-addr", defaultMetricsAddr, "address serving /metrics, empty to disable")
+}
+
+// startMetrics serves /metrics in the background. A failure to listen is
+// logged but doesn't stop the command.
+func startMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	go func() {
+		slog.Info("serving metrics", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("metrics server stopped", "error", err)
+		}
+	}()
+}
+
+func workerStarted(pool string) {
+	workersTotal.inc(pool)
+	workersActive.add(1, pool)
+}
+
+func workerDone(pool string) {
+	workersActive.add(-1, pool)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const minHashWeighting = "minhash"
+
+type minHashParams struct {
+	permutations int
+	bands        int
+	limit        int
+	minJaccard   float64
+	sample       int
+	seed         int64
+}
+
+var defaultMinHashParams = minHashParams{
+	permutations: 128,
+	bands:        32,
+	limit:        50,
+	minJaccard:   0.01,
+	sample:       50,
+	seed:         1,
+}
+
+type minHashSignature struct {
+	values    []uint64
+	reviewers int
+}
+
+// minHasher simulates the permutations of a MinHash with seeded mixes of a
+// single 64 bit hash of the steam id.
+type minHasher struct {
+	seeds []uint64
+}
+
+func newMinHasher(permutations int, seed int64) minHasher {
+	random := rand.New(rand.NewSource(seed))
+	seeds := make([]uint64, permutations)
+	for i := range seeds {
+		seeds[i] = random.Uint64()
+	}
+	return minHasher{seeds: seeds}
+}
+
+func (m minHasher) signature(users []string) minHashSignature {
+	values := make([]uint64, len(m.seeds))
+	for i := range values {
+		values[i] = math.MaxUint64
+	}
+
+	distinct := make(map[string]bool)
+	for _, user := range users {
+		if distinct[user] {
+			continue
+		}
+		distinct[user] = true
+
+		h := fnv.New64a()
+		h.Write([]byte(user))
+		x := h.Sum64()
+
+		for i, seed := range m.seeds {
+			if v := splitMix64(x ^ seed); v < values[i] {
+				values[i] = v
+			}
+		}
+	}
+	return minHashSignature{values: values, reviewers: len(distinct)}
+}
+
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// estimatedJaccard is the fraction of permutations on which both sets share their minimum.
+func estimatedJaccard(a minHashSignature, b minHashSignature) float64 {
+	var equal int
+	for i := range a.values {
+		if a.values[i] == b.values[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(a.values))
+}
+
+// estimatedOverlap turns a Jaccard estimate back into a reviewer count,
+// from |A n B| = J (|A| + |B|) / (1 + J).
+func estimatedOverlap(jaccard float64, a minHashSignature, b minHashSignature) int {
+	return int(math.Round(jaccard * float64(a.reviewers+b.reviewers) / (1 + jaccard)))
+}
+
+// lshCandidates buckets every signature by each of its bands; games sharing
+// any bucket become candidate pairs.
+func lshCandidates(signatures map[int]minHashSignature, bands int) map[int]map[int]bool {
+	candidates := make(map[int]map[int]bool)
+
+	for band := 0; band < bands; band++ {
+		buckets := make(map[uint64][]int)
+		for gameId, signature := range signatures {
+			rows := len(signature.values) / bands
+			h := fnv.New64a()
+			for _, v := range signature.values[band*rows : (band+1)*rows] {
+				var b [8]byte
+				for i := range b {
+					b[i] = byte(v >> (8 * i))
+				}
+				h.Write(b[:])
+			}
+			key := h.Sum64()
+			buckets[key] = append(buckets[key], gameId)
+		}
+
+		for _, bucket := range buckets {
+			for _, a := range bucket {
+				for _, b := range bucket {
+					if a == b {
+						continue
+					}
+					if candidates[a] == nil {
+						candidates[a] = make(map[int]bool)
+					}
+					candidates[a][b] = true
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+func approximateSimilarGames(gameId int, signatures map[int]minHashSignature, candidates map[int]bool, params minHashParams) []GameSimilarity {
+	similarities := []GameSimilarity{}
+	for otherId := range candidates {
+		jaccard := estimatedJaccard(signatures[gameId], signatures[otherId])
+		if jaccard < params.minJaccard {
+			continue
+		}
+		overlap := estimatedOverlap(jaccard, signatures[gameId], signatures[otherId])
+		similarities = append(similarities, GameSimilarity{
+			GameId:           otherId,
+			Count:            overlap,
+			Score:            float64(overlap),
+			EstimatedJaccard: jaccard,
+			Weighting:        minHashWeighting,
+		})
+	}
+
+	sort.Slice(similarities, func(i, j int) bool {
+		if similarities[i].EstimatedJaccard != similarities[j].EstimatedJaccard {
+			return similarities[i].EstimatedJaccard > similarities[j].EstimatedJaccard
+		}
+		return similarities[i].GameId < similarities[j].GameId
+	})
+	if len(similarities) > params.limit {
+		similarities = similarities[:params.limit]
+	}
+	return similarities
+}
+
+// populateApproxSimilarities computes a signature per game from its
+// reviewers, finds candidates with LSH and stores the estimated neighbours
+// in game-links next to the exact ones.
+func populateApproxSimilarities(params minHashParams) MinHashAccuracyReport {
+	defer timeTrack(time.Now(), "populateApproxSimilarities")
+
+	hasher := newMinHasher(params.permutations, params.seed)
+	signatures := make(map[int]minHashSignature)
+
+	cursor := database.findGameReviews()
+	for cursor.Next(context.TODO()) {
+		var review GameReviewDTO
+		err := cursor.Decode(&review)
+		check(err)
+
+		if len(review.Users) == 0 {
+			continue
+		}
+		signature := hasher.signature(review.Users)
+		signatures[review.AppId] = signature
+		database.saveMinHashSignature(MinHashSignatureDTO{
+			GameId:    review.AppId,
+			Signature: toInt64s(signature.values),
+			Reviewers: signature.reviewers,
+		})
+	}
+	slog.Info("computed signatures", "stage", "minhash", "signatures", len(signatures))
+
+	candidates := lshCandidates(signatures, params.bands)
+
+	approximations := make(map[int][]GameSimilarity)
+	for gameId := range signatures {
+		approximations[gameId] = approximateSimilarGames(gameId, signatures, candidates[gameId], params)
+		database.saveApproxGameLink(gameId, approximations[gameId])
+	}
+
+	return compareWithExact(signatures, approximations, params)
+}
+
+// compareWithExact checks the estimates of a sample of games against their
+// actual reviewer sets, and the candidates against the exact game-links.
+func compareWithExact(signatures map[int]minHashSignature, approximations map[int][]GameSimilarity, params minHashParams) MinHashAccuracyReport {
+	defer timeTrack(time.Now(), "compareWithExact")
+
+	var gameIds []int
+	for gameId := range signatures {
+		gameIds = append(gameIds, gameId)
+	}
+	sort.Ints(gameIds)
+	random := rand.New(rand.NewSource(params.seed))
+	random.Shuffle(len(gameIds), func(i, j int) {
+		gameIds[i], gameIds[j] = gameIds[j], gameIds[i]
+	})
+	if len(gameIds) > params.sample {
+		gameIds = gameIds[:params.sample]
+	}
+
+	report := MinHashAccuracyReport{
+		Permutations: params.permutations,
+		Bands:        params.bands,
+		Games:        len(signatures),
+		SampledGames: len(gameIds),
+	}
+
+	reviewerSets := make(map[int]map[string]bool)
+	reviewerSet := func(gameId int) map[string]bool {
+		if set, ok := reviewerSets[gameId]; ok {
+			return set
+		}
+		set := make(map[string]bool)
+		for _, user := range database.findGameReview(gameId).Users {
+			set[user] = true
+		}
+		reviewerSets[gameId] = set
+		return set
+	}
+
+	var absoluteError float64
+	var exactHits, exactTotal int
+
+	for _, gameId := range gameIds {
+		seedSet := reviewerSet(gameId)
+
+		for _, approximation := range approximations[gameId] {
+			otherSet := reviewerSet(approximation.GameId)
+			var overlap int
+			for user := range otherSet {
+				if seedSet[user] {
+					overlap++
+				}
+			}
+			exact := float64(overlap) / float64(len(seedSet)+len(otherSet)-overlap)
+			absoluteError += math.Abs(approximation.EstimatedJaccard - exact)
+			report.ComparedPairs++
+		}
+
+		// Rank the exact neighbours by Jaccard to compare like with like.
+		exactNeighbors := database.findGameLink(gameId).SimilarGames
+		sort.Slice(exactNeighbors, func(i, j int) bool {
+			return exactJaccard(exactNeighbors[i], signatures[gameId], signatures) > exactJaccard(exactNeighbors[j], signatures[gameId], signatures)
+		})
+		if len(exactNeighbors) > params.limit {
+			exactNeighbors = exactNeighbors[:params.limit]
+		}
+
+		found := make(map[int]bool)
+		for _, approximation := range approximations[gameId] {
+			found[approximation.GameId] = true
+		}
+		for _, neighbor := range exactNeighbors {
+			if exactJaccard(neighbor, signatures[gameId], signatures) < params.minJaccard {
+				continue
+			}
+			exactTotal++
+			if found[neighbor.GameId] {
+				exactHits++
+			}
+		}
+	}
+
+	if report.ComparedPairs > 0 {
+		report.MeanAbsoluteError = absoluteError / float64(report.ComparedPairs)
+	}
+	if exactTotal > 0 {
+		report.RecallOfExactNeighbors = float64(exactHits) / float64(exactTotal)
+	}
+	return report
+}
+
+// exactJaccard uses the exact reviewer overlap of a game-link together with
+// the reviewer counts kept on the signatures.
+func exactJaccard(neighbor GameSimilarity, seed minHashSignature, signatures map[int]minHashSignature) float64 {
+	union := seed.reviewers + signatures[neighbor.GameId].reviewers - neighbor.Count
+	if union <= 0 {
+		return 0
+	}
+	return float64(neighbor.Count) / float64(union)
+}
+
+func toInt64s(values []uint64) []int64 {
+	result := make([]int64, len(values))
+	for i, v := range values {
+		result[i] = int64(v)
+	}
+	return result
+}
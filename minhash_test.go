@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func testReviewers(from int, to int) []string {
+	var users []string
+	for i := from; i < to; i++ {
+		users = append(users, fmt.Sprintf("7656119800%07d", i))
+	}
+	return users
+}
+
+func TestMinHashEstimatesJaccard(t *testing.T) {
+	hasher := newMinHasher(256, 1)
+	a := hasher.signature(testReviewers(0, 1000))
+	// A third of the union is shared
+	b := hasher.signature(append(testReviewers(500, 1500), testReviewers(500, 510)...))
+
+	if b.reviewers != 1000 {
+		t.Errorf("signature counts %v reviewers, want duplicates counted once", b.reviewers)
+	}
+	if jaccard := estimatedJaccard(a, b); math.Abs(jaccard-1.0/3) > 0.1 {
+		t.Errorf("estimated Jaccard = %v, want about 1/3", jaccard)
+	}
+	if overlap := estimatedOverlap(estimatedJaccard(a, b), a, b); math.Abs(float64(overlap)-500) > 100 {
+		t.Errorf("estimated overlap = %v, want about 500", overlap)
+	}
+	if jaccard := estimatedJaccard(a, hasher.signature(testReviewers(0, 1000))); jaccard != 1 {
+		t.Errorf("estimated Jaccard of the same reviewers = %v, want 1", jaccard)
+	}
+	if jaccard := estimatedJaccard(a, hasher.signature(testReviewers(2000, 3000))); jaccard > 0.05 {
+		t.Errorf("estimated Jaccard of disjoint reviewers = %v, want about 0", jaccard)
+	}
+}
+
+func TestLSHCandidatesPairSimilarGames(t *testing.T) {
+	hasher := newMinHasher(128, 1)
+	signatures := map[int]minHashSignature{
+		10: hasher.signature(testReviewers(0, 1000)),
+		20: hasher.signature(testReviewers(0, 950)),
+		30: hasher.signature(testReviewers(5000, 6000)),
+	}
+
+	candidates := lshCandidates(signatures, 32)
+	if !candidates[10][20] || !candidates[20][10] {
+		t.Errorf("games sharing 95%% of reviewers aren't candidates: %v", candidates)
+	}
+	if candidates[10][30] || candidates[30][20] {
+		t.Errorf("games without shared reviewers are candidates: %v", candidates)
+	}
+
+	params := defaultMinHashParams
+	similar := approximateSimilarGames(10, signatures, map[int]bool{20: true, 30: true}, params)
+	if len(similar) != 1 || similar[0].GameId != 20 || similar[0].Score != float64(similar[0].Count) {
+		t.Errorf("approximate similar games of 10 = %+v, want only 20", similar)
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
+import "time"
+
 type ReviewAuthor struct {
-	SteamId string `json:"steamid"`
+	SteamId          string `json:"steamid"`
+	PlaytimeAtReview int    `json:"playtime_at_review"`
 }
 
 type GameReview struct {
@@ -32,9 +35,15 @@ type StoreEntry struct {
 	Name  string `json:"name"`
 }
 
+type EntryGenre struct {
+	Id          string `json:"id"`
+	Description string `json:"description"`
+}
+
 type EntryDetailsData struct {
-	Type string `json:"type"`
-	Name string `json:"name"`
+	Type   string       `json:"type"`
+	Name   string       `json:"name"`
+	Genres []EntryGenre `json:"genres"`
 }
 
 type EntryDetails struct {
@@ -44,17 +53,149 @@ type EntryDetails struct {
 type EntryDetailsResponse map[string]EntryDetails
 
 type GameSimilarity struct {
-	GameId        int
-	Count         int
-	WeightedCount float32
+	GameId           int
+	Count            int
+	WeightedCount    float32
+	Score            float64
+	Weighting        string
+	EstimatedJaccard float64 `bson:",omitempty" json:",omitempty"`
 }
 
 type GameNode struct {
-	Name      string   `json:"name"`
-	Value     int      `json:"value"`
-	Links     []string `json:"linkWith"`
-	LinkedIds []int
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Value       int      `json:"value"`
+	Depth       int      `json:"depth"`
+	Genres      []string `json:"genres,omitempty"`
+	Community   *int     `json:"community,omitempty"`
+	Links       []string `json:"linkWith"`
+	LinkedIds   []int
+	LinkWeights []float64 `json:"linkWeights"`
+}
+
+type GraphEdge struct {
+	Source     int     `json:"source"`
+	Target     int     `json:"target"`
+	Weight     float64 `json:"weight"`
+	Similarity float64 `json:"similarity"`
 }
+
 type Graph struct {
-	Data []GameNode `json:"data"`
+	Seed  int         `json:"seed"`
+	Data  []GameNode  `json:"data"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type SeedContribution struct {
+	GameId int     `json:"appid"`
+	Name   string  `json:"name"`
+	Score  float64 `json:"score"`
+}
+
+type Recommendation struct {
+	GameId  int                `json:"appid"`
+	Name    string             `json:"name"`
+	Score   float64            `json:"score"`
+	Because []SeedContribution `json:"because"`
+}
+
+type ALSParamsReport struct {
+	Factors        int     `json:"factors"`
+	Iterations     int     `json:"iterations"`
+	Regularization float64 `json:"regularization"`
+	Alpha          float64 `json:"alpha"`
+}
+
+type EvaluationReport struct {
+	Algorithm         string           `json:"algorithm"`
+	Weighting         string           `json:"weighting"`
+	ALS               *ALSParamsReport `json:"als,omitempty"`
+	K                 int              `json:"k"`
+	Holdout           float64          `json:"holdout"`
+	Users             int              `json:"users"`
+	Seed              int64            `json:"seed"`
+	PrecisionAtK      float64          `json:"precisionAtK"`
+	RecallAtK         float64          `json:"recallAtK"`
+	MAP               float64          `json:"map"`
+	NDCG              float64          `json:"ndcg"`
+	CatalogCoverage   float64          `json:"catalogCoverage"`
+	AveragePopularity float64          `json:"averagePopularity"`
+	PopularityBias    float64          `json:"popularityBias"`
+	CreatedAt         time.Time        `json:"createdAt"`
+}
+
+type MinHashAccuracyReport struct {
+	Permutations           int     `json:"permutations"`
+	Bands                  int     `json:"bands"`
+	Games                  int     `json:"games"`
+	SampledGames           int     `json:"sampledGames"`
+	ComparedPairs          int     `json:"comparedPairs"`
+	MeanAbsoluteError      float64 `json:"meanAbsoluteError"`
+	RecallOfExactNeighbors float64 `json:"recallOfExactNeighbors"`
+}
+
+type PathGame struct {
+	GameId int    `json:"appid"`
+	Name   string `json:"name"`
+}
+
+type PathEdge struct {
+	From       int     `json:"from"`
+	To         int     `json:"to"`
+	Similarity float64 `json:"similarity"`
+}
+
+type TastePath struct {
+	Games      []PathGame `json:"games"`
+	Edges      []PathEdge `json:"edges"`
+	Similarity float64    `json:"similarity"`
+}
+
+type ErrorBody struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type Page struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// RecommendationPage has no total, as recommenders only rank as many games
+// as the page reaches.
+type RecommendationPage struct {
+	Items  []Recommendation `json:"items"`
+	Limit  int              `json:"limit"`
+	Offset int              `json:"offset"`
+}
+
+type AppResponse struct {
+	GameId     int            `json:"appid"`
+	Name       string         `json:"name"`
+	IsGame     bool           `json:"isGame"`
+	Genres     []string       `json:"genres,omitempty"`
+	Reviews    int            `json:"reviews"`
+	Community  *int           `json:"community,omitempty"`
+	Centrality *CentralityDTO `json:"centrality,omitempty"`
+}
+
+type SimilarGameResponse struct {
+	GameId     int     `json:"appid"`
+	Name       string  `json:"name"`
+	Count      int     `json:"count"`
+	Score      float64 `json:"score"`
+	Similarity float64 `json:"similarity"`
+}
+
+type SearchResult struct {
+	GameId  int     `json:"appid"`
+	Name    string  `json:"name"`
+	Score   float64 `json:"score"`
+	Reviews int     `json:"reviews"`
 }
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The Neo4j export writes the bipartite review graph plus the game-links as
+// CSVs with the typed headers of neo4j-admin import: Game and User nodes,
+// REVIEWED from users to games and SIMILAR_TO between games. The ID columns
+// aren't stored as properties, so every node also has a key property with
+// the same value, which load.cypher merges on for incremental loads of the
+// same files.
+
+const neo4jArrayDelimiter = ";"
+const neo4jCypherFile = "load.cypher"
+const neo4jBatchSize = 10000
+
+type neo4jFile struct {
+	table exportTable
+	// Node label, or relationship type
+	label string
+	// Labels of the start and end nodes of relationships
+	start string
+	end   string
+	// Property a node is merged on, the column holding the same value as its ID
+	key string
+}
+
+func (f neo4jFile) isRelationship() bool {
+	return f.start != ""
+}
+
+func (f neo4jFile) fileName() string {
+	return f.table.name + ".csv"
+}
+
+var neo4jGameColumns = []exportColumn{
+	{":ID(Game)", exportInt, false},
+	{"appid:long", exportInt, false},
+	{"name", exportString, false},
+	{"genres:string[]", exportString, false},
+	{"reviews:int", exportInt, false},
+	{"community:int", exportInt, true},
+	{"pagerank:double", exportFloat, true},
+	{"weighted_degree:double", exportFloat, true},
+	{"betweenness:double", exportFloat, true},
+	{"clustering:double", exportFloat, true},
+}
+
+var neo4jUserColumns = []exportColumn{
+	{":ID(User)", exportString, false},
+	{"steamid", exportString, false},
+}
+
+var neo4jReviewedColumns = []exportColumn{
+	{":START_ID(User)", exportString, false},
+	{":END_ID(Game)", exportInt, false},
+	{"playtime:int", exportInt, true},
+}
+
+var neo4jSimilarToColumns = []exportColumn{
+	{":START_ID(Game)", exportInt, false},
+	{":END_ID(Game)", exportInt, false},
+	{"rank:int", exportInt, false},
+	{"count:int", exportInt, false},
+	{"score:double", exportFloat, false},
+	{"cosine:double", exportFloat, false},
+	{"weighting", exportString, false},
+}
+
+func neo4jGameRow(game StoreEntryDTO, reviews int) []interface{} {
+	var genres []string
+	for _, genre := range game.Genres {
+		genres = append(genres, strings.Replace(genre, neo4jArrayDelimiter, ",", -1))
+	}
+	row := []interface{}{
+		int64(game.ID), int64(game.ID), game.Name, strings.Join(genres, neo4jArrayDelimiter), int64(reviews),
+		nil, nil, nil, nil, nil,
+	}
+	if game.Community != nil {
+		row[5] = int64(*game.Community)
+	}
+	if c := game.Centrality; c != nil {
+		row[6], row[7], row[8], row[9] = c.PageRank, c.WeightedDegree, c.Betweenness, c.Clustering
+	}
+	return row
+}
+
+// neo4jReviewedRows keeps the reviews of exported games, leaving unknown
+// playtimes empty.
+func neo4jReviewedRows(link UserLinkDTO, games map[int]bool) [][]interface{} {
+	var rows [][]interface{}
+	for i, gameId := range link.GamesReviewed {
+		if !games[gameId] {
+			continue
+		}
+		row := []interface{}{link.UserId, int64(gameId), nil}
+		if playtime := playtimeAt(link.Playtimes, i); playtime != unknownPlaytime {
+			row[2] = int64(playtime)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// neo4jSimilarToRows keeps the top links of a game to other exported games.
+// Ranks count the links kept.
+func neo4jSimilarToRows(link GameLinkDTO, top int, games map[int]bool, reviewCounts map[int]int) [][]interface{} {
+	if !games[link.GameId] {
+		return nil
+	}
+	var rows [][]interface{}
+	for _, similarGame := range link.SimilarGames {
+		if top > 0 && len(rows) == top {
+			break
+		}
+		if !games[similarGame.GameId] {
+			continue
+		}
+		rows = append(rows, []interface{}{
+			int64(link.GameId), int64(similarGame.GameId), int64(len(rows) + 1), int64(similarGame.Count),
+			similarityScore(similarGame), metricValue(similarGame, "cosine", reviewCounts[link.GameId], reviewCounts[similarGame.GameId]),
+			similarGame.Weighting,
+		})
+	}
+	return rows
+}
+
+// neo4jFiles reads games first, the relationships only keep edges between
+// the games exported.
+func neo4jFiles(top int) []neo4jFile {
+	reviewCounts := database.findAllReviewCounts()
+	games := make(map[int]bool)
+
+	gameTable := exportTable{name: "games", columns: neo4jGameColumns,
+		rows: func(emit func([]interface{}) error) error {
+			return exportCursor(database.findGames(), func(decode func(interface{}) error) error {
+				var game StoreEntryDTO
+				if err := decode(&game); err != nil {
+					return err
+				}
+				games[game.ID] = true
+				return emit(neo4jGameRow(game, reviewCounts[game.ID]))
+			})
+		},
+	}
+	userTable := exportTable{name: "users", columns: neo4jUserColumns,
+		rows: func(emit func([]interface{}) error) error {
+			return exportCursor(database.findAllUserLinks(), func(decode func(interface{}) error) error {
+				var link UserLinkDTO
+				if err := decode(&link); err != nil {
+					return err
+				}
+				return emit([]interface{}{link.UserId, link.UserId})
+			})
+		},
+	}
+	reviewedTable := exportTable{name: "reviewed", columns: neo4jReviewedColumns,
+		rows: func(emit func([]interface{}) error) error {
+			return exportCursor(database.findAllUserLinks(), func(decode func(interface{}) error) error {
+				var link UserLinkDTO
+				if err := decode(&link); err != nil {
+					return err
+				}
+				return emitRows(emit, neo4jReviewedRows(link, games))
+			})
+		},
+	}
+	similarToTable := exportTable{name: "similar_to", columns: neo4jSimilarToColumns,
+		rows: func(emit func([]interface{}) error) error {
+			return exportCursor(database.findAllGameLinks(), func(decode func(interface{}) error) error {
+				var link GameLinkDTO
+				if err := decode(&link); err != nil {
+					return err
+				}
+				return emitRows(emit, neo4jSimilarToRows(link, top, games, reviewCounts))
+			})
+		},
+	}
+
+	return []neo4jFile{
+		{table: gameTable, label: "Game", key: "appid:long"},
+		{table: userTable, label: "User", key: "steamid"},
+		{table: reviewedTable, label: "REVIEWED", start: "User", end: "Game"},
+		{table: similarToTable, label: "SIMILAR_TO", start: "Game", end: "Game"},
+	}
+}
+
+func emitRows(emit func([]interface{}) error, rows [][]interface{}) error {
+	for _, row := range rows {
+		if err := emit(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// neo4jHeader splits a header like "pagerank:double" into the property and
+// its type, string when untyped.
+func neo4jHeader(header string) (string, string) {
+	if i := strings.LastIndex(header, ":"); i >= 0 {
+		return header[:i], header[i+1:]
+	}
+	return header, "string"
+}
+
+// neo4jValue is the Cypher converting a CSV field to its header's type.
+// LOAD CSV reads empty fields as null, which every conversion keeps.
+func neo4jValue(header string) string {
+	_, kind := neo4jHeader(header)
+	return neo4jConvert(header, kind)
+}
+
+func neo4jConvert(header string, kind string) string {
+	field := "row.`" + header + "`"
+	switch kind {
+	case "int", "long":
+		return "toInteger(" + field + ")"
+	case "double", "float":
+		return "toFloat(" + field + ")"
+	case "string[]":
+		return "split(" + field + ", '" + neo4jArrayDelimiter + "')"
+	}
+	return field
+}
+
+func neo4jProperties(variable string, columns []exportColumn) []string {
+	var properties []string
+	for _, column := range columns {
+		if strings.HasPrefix(column.name, ":") {
+			continue
+		}
+		property, _ := neo4jHeader(column.name)
+		properties = append(properties, variable+"."+property+" = "+neo4jValue(column.name))
+	}
+	return properties
+}
+
+func neo4jImportCommand(files []neo4jFile) string {
+	args := []string{"neo4j-admin database import full"}
+	for _, file := range files {
+		kind := "nodes"
+		if file.isRelationship() {
+			kind = "relationships"
+		}
+		args = append(args, fmt.Sprintf("--%v=%v=%v", kind, file.label, file.fileName()))
+	}
+	args = append(args, "--array-delimiter='"+neo4jArrayDelimiter+"'", "neo4j")
+	return strings.Join(args, " ")
+}
+
+// writeNeo4jCypher writes the script loading the files into a running
+// database. Nodes and relationships are merged, so reloading newer files
+// updates them, but relationships missing from the files aren't deleted.
+func writeNeo4jCypher(w io.Writer, files []neo4jFile) error {
+	var b strings.Builder
+	b.WriteString("// Incremental load of the steam-scraper export, run with\n")
+	b.WriteString("//   cypher-shell -f " + neo4jCypherFile + "\n")
+	b.WriteString("// after copying the CSVs to the import directory of the database.\n")
+	b.WriteString("// Into an empty database, bulk import them instead with\n")
+	b.WriteString("//   " + neo4jImportCommand(files) + "\n")
+	b.WriteString("// and run only the constraints.\n\n")
+
+	keys := make(map[string]string)
+	for _, file := range files {
+		if file.isRelationship() {
+			continue
+		}
+		keys[file.label] = file.key
+		property, _ := neo4jHeader(file.key)
+		fmt.Fprintf(&b, "CREATE CONSTRAINT %v_%v IF NOT EXISTS FOR (n:%v) REQUIRE n.%v IS UNIQUE;\n",
+			strings.ToLower(file.label), property, file.label, property)
+	}
+
+	for _, file := range files {
+		fmt.Fprintf(&b, "\nLOAD CSV WITH HEADERS FROM 'file:///%v' AS row\nCALL {\n  WITH row\n", file.fileName())
+		if file.isRelationship() {
+			startKey, endKey := keys[file.start], keys[file.end]
+			if startKey == "" || endKey == "" {
+				return fmt.Errorf("%v links nodes that aren't exported", file.label)
+			}
+			startProperty, startKind := neo4jHeader(startKey)
+			endProperty, endKind := neo4jHeader(endKey)
+			fmt.Fprintf(&b, "  MATCH (a:%v {%v: %v})\n", file.start, startProperty,
+				neo4jConvert(":START_ID("+file.start+")", startKind))
+			fmt.Fprintf(&b, "  MATCH (b:%v {%v: %v})\n", file.end, endProperty,
+				neo4jConvert(":END_ID("+file.end+")", endKind))
+			fmt.Fprintf(&b, "  MERGE (a)-[r:%v]->(b)\n", file.label)
+			if properties := neo4jProperties("r", file.table.columns); len(properties) > 0 {
+				fmt.Fprintf(&b, "  SET %v\n", strings.Join(properties, ",\n      "))
+			}
+		} else {
+			property, _ := neo4jHeader(file.key)
+			fmt.Fprintf(&b, "  MERGE (n:%v {%v: %v})\n", file.label, property, neo4jValue(file.key))
+			if properties := neo4jProperties("n", file.table.columns); len(properties) > 0 {
+				fmt.Fprintf(&b, "  SET %v\n", strings.Join(properties, ",\n      "))
+			}
+		}
+		fmt.Fprintf(&b, "} IN TRANSACTIONS OF %v ROWS;\n", neo4jBatchSize)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// exportNeo4j writes the CSVs and load.cypher to dir, keeping the top
+// SIMILAR_TO links of every game, all of them when top is 0.
+func exportNeo4j(dir string, top int) {
+	defer timeTrack(time.Now(), "exportNeo4j")
+
+	err := os.MkdirAll(dir, 0755)
+	check(err)
+
+	files := neo4jFiles(top)
+	format := exportFormats["csv"]
+	for _, file := range files {
+		columns, _ := selectColumns(file.table, nil)
+		path := filepath.Join(dir, file.fileName())
+		out, err := os.Create(path)
+		check(err)
+
+		rows, err := exportTo(out, file.table, columns, format, "none")
+		if err != nil {
+			out.Close()
+			fatal("neo4j export failed", "stage", "neo4j-export", "file", file.fileName(), "error", err)
+		}
+		check(out.Close())
+		slog.Info("exported neo4j file", "stage", "neo4j-export", "label", file.label, "rows", rows, "path", path)
+	}
+
+	path := filepath.Join(dir, neo4jCypherFile)
+	out, err := os.Create(path)
+	check(err)
+	err = writeNeo4jCypher(out, files)
+	check(err)
+	check(out.Close())
+	slog.Info("wrote cypher script", "stage", "neo4j-export", "path", path, "import", neo4jImportCommand(files))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testNeo4jFiles(rows map[string][][]interface{}) []neo4jFile {
+	table := func(name string, columns []exportColumn) exportTable {
+		return exportTable{name: name, columns: columns, rows: func(emit func([]interface{}) error) error {
+			return emitRows(emit, rows[name])
+		}}
+	}
+	return []neo4jFile{
+		{table: table("games", neo4jGameColumns), label: "Game", key: "appid:long"},
+		{table: table("users", neo4jUserColumns), label: "User", key: "steamid"},
+		{table: table("reviewed", neo4jReviewedColumns), label: "REVIEWED", start: "User", end: "Game"},
+		{table: table("similar_to", neo4jSimilarToColumns), label: "SIMILAR_TO", start: "Game", end: "Game"},
+	}
+}
+
+func TestNeo4jRows(t *testing.T) {
+	community := 3
+	game := StoreEntryDTO{ID: 440, Name: "Team Fortress 2", Genres: []string{"Action", "Free; to Play"}, Community: &community}
+	row := neo4jGameRow(game, 1200)
+	want := []interface{}{int64(440), int64(440), "Team Fortress 2", "Action;Free, to Play", int64(1200), int64(3), nil, nil, nil, nil}
+	if !reflect.DeepEqual(row, want) {
+		t.Errorf("game row = %v, want %v", row, want)
+	}
+
+	games := map[int]bool{10: true, 440: true, 570: true}
+	link := UserLinkDTO{UserId: "76561198000000001", GamesReviewed: []int{10, 20, 440, 570}, Playtimes: []int{30, 40, unknownPlaytime}}
+	reviewed := neo4jReviewedRows(link, games)
+	wantReviewed := [][]interface{}{
+		{"76561198000000001", int64(10), int64(30)},
+		{"76561198000000001", int64(440), nil},
+		{"76561198000000001", int64(570), nil},
+	}
+	if !reflect.DeepEqual(reviewed, wantReviewed) {
+		t.Errorf("reviewed rows = %v, want %v", reviewed, wantReviewed)
+	}
+
+	gameLink := GameLinkDTO{GameId: 440, SimilarGames: []GameSimilarity{
+		{GameId: 20, Count: 90, Score: 90},
+		{GameId: 570, Count: 60, Score: 60},
+		{GameId: 10, Count: 30, Score: 30},
+	}}
+	reviewCounts := map[int]int{440: 100, 570: 400, 10: 900}
+	similar := neo4jSimilarToRows(gameLink, 1, games, reviewCounts)
+	wantSimilar := [][]interface{}{{int64(440), int64(570), int64(1), int64(60), 60.0, 0.3, ""}}
+	if !reflect.DeepEqual(similar, wantSimilar) {
+		t.Errorf("similar_to rows = %v, want %v", similar, wantSimilar)
+	}
+	if all := neo4jSimilarToRows(gameLink, 0, games, reviewCounts); len(all) != 2 || all[1][2] != int64(2) {
+		t.Errorf("similar_to rows without a limit = %v", all)
+	}
+	if rows := neo4jSimilarToRows(GameLinkDTO{GameId: 20, SimilarGames: gameLink.SimilarGames}, 0, games, reviewCounts); rows != nil {
+		t.Errorf("similar_to rows of a game that isn't exported = %v", rows)
+	}
+}
+
+func TestNeo4jCSVHeaders(t *testing.T) {
+	files := testNeo4jFiles(map[string][][]interface{}{
+		"reviewed": {{"76561198000000001", int64(440), nil}},
+	})
+	var out bytes.Buffer
+	columns, _ := selectColumns(files[2].table, nil)
+	if _, err := exportTo(&out, files[2].table, columns, exportFormats["csv"], "none"); err != nil {
+		t.Fatal(err)
+	}
+	want := ":START_ID(User),:END_ID(Game),playtime:int\n76561198000000001,440,\n"
+	if out.String() != want {
+		t.Errorf("reviewed.csv = %q, want %q", out.String(), want)
+	}
+}
+
+func TestNeo4jCypher(t *testing.T) {
+	files := testNeo4jFiles(nil)
+	var out bytes.Buffer
+	if err := writeNeo4jCypher(&out, files); err != nil {
+		t.Fatal(err)
+	}
+	script := out.String()
+
+	for _, want := range []string{
+		"CREATE CONSTRAINT game_appid IF NOT EXISTS FOR (n:Game) REQUIRE n.appid IS UNIQUE;",
+		"CREATE CONSTRAINT user_steamid IF NOT EXISTS FOR (n:User) REQUIRE n.steamid IS UNIQUE;",
+		"MERGE (n:Game {appid: toInteger(row.`appid:long`)})",
+		"n.genres = split(row.`genres:string[]`, ';')",
+		"n.pagerank = toFloat(row.`pagerank:double`)",
+		"MATCH (a:User {steamid: row.`:START_ID(User)`})",
+		"MATCH (b:Game {appid: toInteger(row.`:END_ID(Game)`)})",
+		"MERGE (a)-[r:SIMILAR_TO]->(b)",
+		"r.weighting = row.`weighting`",
+		"--nodes=Game=games.csv --nodes=User=users.csv --relationships=REVIEWED=reviewed.csv --relationships=SIMILAR_TO=similar_to.csv",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("load.cypher lacks %q", want)
+		}
+	}
+	if n := strings.Count(script, "IN TRANSACTIONS OF"); n != len(files) {
+		t.Errorf("load.cypher loads %v files, want %v", n, len(files))
+	}
+
+	// Every property column of the CSVs is loaded
+	for _, file := range files {
+		for _, column := range file.table.columns {
+			if !strings.HasPrefix(column.name, ":") && !strings.Contains(script, "row.`"+column.name+"`") {
+				t.Errorf("load.cypher doesn't load %v of %v", column.name, file.fileName())
+			}
+		}
+	}
+
+	files[2].start = "Player"
+	if err := writeNeo4jCypher(&out, files); err == nil {
+		t.Error("writeNeo4jCypher of a relationship to a label without nodes succeeded, want an error")
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A minimal Parquet writer: flat schemas of int64, double and UTF-8 string
+// columns, PLAIN encoded with one data page per column chunk. Nullable
+// columns carry RLE definition levels. The footer is Thrift compact
+// protocol, written by hand.
+
+const parquetMagic = "PAR1"
+
+var parquetRowGroupSize = 100000
+
+// Values of the Parquet format's Thrift enums
+const (
+	parquetInt64      = 2
+	parquetDouble     = 5
+	parquetByteArray  = 6
+	parquetRequired   = 0
+	parquetOptional   = 1
+	parquetUTF8       = 0
+	parquetPlain      = 0
+	parquetRLE        = 3
+	parquetDataPage   = 0
+	parquetCodecNone  = 0
+	parquetCodecGzip  = 2
+	parquetFileFormat = 1
+)
+
+type parquetChunk struct {
+	offset           int64
+	values           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+type parquetWriter struct {
+	w       *countingWriter
+	columns []exportColumn
+	codec   int32
+
+	values    [][]interface{}
+	rows      int
+	rowGroups []parquetRowGroup
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newParquetWriter(w io.Writer, columns []exportColumn, compression string) (rowWriter, error) {
+	p := &parquetWriter{w: &countingWriter{w: w}, columns: columns, values: make([][]interface{}, len(columns))}
+	switch compression {
+	case "none":
+		p.codec = parquetCodecNone
+	case "gzip":
+		p.codec = parquetCodecGzip
+	default:
+		return nil, fmt.Errorf("parquet doesn't support %v compression", compression)
+	}
+	if _, err := io.WriteString(p.w, parquetMagic); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *parquetWriter) write(row []interface{}) error {
+	for i, value := range row {
+		if value == nil && !p.columns[i].nullable {
+			return fmt.Errorf("column %v isn't nullable", p.columns[i].name)
+		}
+		p.values[i] = append(p.values[i], value)
+	}
+	p.rows++
+	if p.rows == parquetRowGroupSize {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+func (p *parquetWriter) flushRowGroup() error {
+	if p.rows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: int64(p.rows)}
+	for i, column := range p.columns {
+		chunk, err := p.writeChunk(column, p.values[i])
+		if err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		p.values[i] = p.values[i][:0]
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.rows = 0
+	return nil
+}
+
+func (p *parquetWriter) writeChunk(column exportColumn, values []interface{}) (parquetChunk, error) {
+	var page bytes.Buffer
+	if column.nullable {
+		page.Write(encodeDefinitionLevels(values))
+	}
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		if err := writePlainValue(&page, column, value); err != nil {
+			return parquetChunk{}, err
+		}
+	}
+
+	data := page.Bytes()
+	if p.codec == parquetCodecGzip {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(data); err != nil {
+			return parquetChunk{}, err
+		}
+		if err := writer.Close(); err != nil {
+			return parquetChunk{}, err
+		}
+		data = compressed.Bytes()
+	}
+
+	header := newThriftCompact()
+	header.i32(1, parquetDataPage)
+	header.i32(2, int32(page.Len()))
+	header.i32(3, int32(len(data)))
+	header.beginStruct(5)
+	header.i32(1, int32(len(values)))
+	header.i32(2, parquetPlain)
+	header.i32(3, parquetRLE)
+	header.i32(4, parquetRLE)
+	header.endStruct()
+	header.stop()
+
+	chunk := parquetChunk{
+		offset:           p.w.n,
+		values:           int64(len(values)),
+		uncompressedSize: int64(header.buf.Len() + page.Len()),
+		compressedSize:   int64(header.buf.Len() + len(data)),
+	}
+	if _, err := p.w.Write(header.buf.Bytes()); err != nil {
+		return parquetChunk{}, err
+	}
+	if _, err := p.w.Write(data); err != nil {
+		return parquetChunk{}, err
+	}
+	return chunk, nil
+}
+
+// encodeDefinitionLevels writes the RLE/bit-packed hybrid encoding of the
+// levels with bit width 1, all as RLE runs, behind its length.
+func encodeDefinitionLevels(values []interface{}) []byte {
+	var runs bytes.Buffer
+	for i := 0; i < len(values); {
+		defined := values[i] != nil
+		j := i
+		for j < len(values) && (values[j] != nil) == defined {
+			j++
+		}
+		writeUvarint(&runs, uint64(j-i)<<1)
+		if defined {
+			runs.WriteByte(1)
+		} else {
+			runs.WriteByte(0)
+		}
+		i = j
+	}
+
+	encoded := make([]byte, 4, 4+runs.Len())
+	binary.LittleEndian.PutUint32(encoded, uint32(runs.Len()))
+	return append(encoded, runs.Bytes()...)
+}
+
+func writePlainValue(w *bytes.Buffer, column exportColumn, value interface{}) error {
+	var scratch [8]byte
+	switch column.kind {
+	case exportInt:
+		v, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("column %v holds %T, want int64", column.name, value)
+		}
+		binary.LittleEndian.PutUint64(scratch[:], uint64(v))
+		w.Write(scratch[:])
+	case exportFloat:
+		v, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("column %v holds %T, want float64", column.name, value)
+		}
+		binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(v))
+		w.Write(scratch[:])
+	case exportString:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("column %v holds %T, want string", column.name, value)
+		}
+		binary.LittleEndian.PutUint32(scratch[:4], uint32(len(v)))
+		w.Write(scratch[:4])
+		w.WriteString(v)
+	}
+	return nil
+}
+
+func parquetType(kind string) int32 {
+	switch kind {
+	case exportInt:
+		return parquetInt64
+	case exportFloat:
+		return parquetDouble
+	}
+	return parquetByteArray
+}
+
+// close flushes the last row group and writes the footer.
+func (p *parquetWriter) close() error {
+	if err := p.flushRowGroup(); err != nil {
+		return err
+	}
+
+	var rows int64
+	for _, group := range p.rowGroups {
+		rows += group.rows
+	}
+
+	meta := newThriftCompact()
+	meta.i32(1, parquetFileFormat)
+
+	meta.beginList(2, thriftStruct, len(p.columns)+1)
+	meta.beginElement()
+	meta.str(4, "schema")
+	meta.i32(5, int32(len(p.columns)))
+	meta.endStruct()
+	for _, column := range p.columns {
+		meta.beginElement()
+		meta.i32(1, parquetType(column.kind))
+		if column.nullable {
+			meta.i32(3, parquetOptional)
+		} else {
+			meta.i32(3, parquetRequired)
+		}
+		meta.str(4, column.name)
+		if column.kind == exportString {
+			meta.i32(6, parquetUTF8)
+		}
+		meta.endStruct()
+	}
+
+	meta.i64(3, rows)
+
+	meta.beginList(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		meta.beginElement()
+		meta.beginList(1, thriftStruct, len(group.chunks))
+		var groupSize int64
+		for i, chunk := range group.chunks {
+			column := p.columns[i]
+			groupSize += chunk.uncompressedSize
+
+			meta.beginElement()
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			meta.i32(1, parquetType(column.kind))
+			if column.nullable {
+				meta.beginList(2, thriftI32, 2)
+				meta.listI32(parquetPlain)
+				meta.listI32(parquetRLE)
+			} else {
+				meta.beginList(2, thriftI32, 1)
+				meta.listI32(parquetPlain)
+			}
+			meta.beginList(3, thriftBinary, 1)
+			meta.listString(column.name)
+			meta.i32(4, p.codec)
+			meta.i64(5, chunk.values)
+			meta.i64(6, chunk.uncompressedSize)
+			meta.i64(7, chunk.compressedSize)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64(2, groupSize)
+		meta.i64(3, group.rows)
+		meta.endStruct()
+	}
+
+	meta.str(6, "steam-scraper")
+	meta.stop()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(meta.buf.Len()))
+	for _, part := range [][]byte{meta.buf.Bytes(), length[:], []byte(parquetMagic)} {
+		if _, err := p.w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Type ids of the Thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompact writes Thrift structs in the compact protocol. last holds
+// the previous field id of each struct being written, for the id deltas.
+type thriftCompact struct {
+	buf  bytes.Buffer
+	last []int16
+}
+
+func newThriftCompact() *thriftCompact {
+	return &thriftCompact{last: []int16{0}}
+}
+
+func (t *thriftCompact) field(id int16, kind byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		writeUvarint(&t.buf, zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftCompact) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftCompact) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	writeUvarint(&t.buf, zigzag(v))
+}
+
+func (t *thriftCompact) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listString(s)
+}
+
+func (t *thriftCompact) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+// beginElement starts a struct inside a list, which has no field header.
+func (t *thriftCompact) beginElement() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftCompact) endStruct() {
+	t.stop()
+	t.last = t.last[:len(t.last)-1]
+}
+
+// stop ends the outermost struct.
+func (t *thriftCompact) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftCompact) beginList(id int16, kind byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | kind)
+	} else {
+		t.buf.WriteByte(0xf0 | kind)
+		writeUvarint(&t.buf, uint64(size))
+	}
+}
+
+func (t *thriftCompact) listI32(v int32) {
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftCompact) listString(s string) {
+	writeUvarint(&t.buf, uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func writeUvarint(w *bytes.Buffer, v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	w.Write(scratch[:binary.PutUvarint(scratch[:], v)])
+}
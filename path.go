@@ -0,0 +1,116 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errGameNotInGraph = errors.New("game has no game-links")
+var errNoPath = errors.New("no path between the games")
+
+type pathParams struct {
+	topK      int
+	metric    string
+	minWeight float64
+}
+
+var defaultPathParams = pathParams{
+	topK:   50,
+	metric: "cosine",
+}
+
+// findTastePath returns the path whose product of edge similarities is
+// highest, found with Dijkstra on edge lengths of -log(similarity).
+// The metric must give similarities in (0, 1], so cosine or jaccard.
+func findTastePath(graph similarityGraph, from int, to int, names map[int]string) (TastePath, error) {
+	defer timeTrack(time.Now(), "findTastePath")
+
+	source, ok := graph.index[from]
+	if !ok {
+		return TastePath{}, errGameNotInGraph
+	}
+	target, ok := graph.index[to]
+	if !ok {
+		return TastePath{}, errGameNotInGraph
+	}
+
+	distance := make([]float64, len(graph.ids))
+	previous := make([]int, len(graph.ids))
+	for i := range distance {
+		distance[i] = math.Inf(1)
+		previous[i] = -1
+	}
+	distance[source] = 0
+
+	queue := &distanceQueue{{node: source}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(distanceItem)
+		if item.distance > distance[item.node] {
+			continue
+		}
+		if item.node == target {
+			break
+		}
+
+		for j, similarity := range graph.adjacency[item.node] {
+			d := distance[item.node] - math.Log(math.Min(similarity, 1))
+			if d < distance[j] {
+				distance[j] = d
+				previous[j] = item.node
+				heap.Push(queue, distanceItem{node: j, distance: d})
+			}
+		}
+	}
+
+	if math.IsInf(distance[target], 1) {
+		return TastePath{}, errNoPath
+	}
+
+	var nodes []int
+	for node := target; node != -1; node = previous[node] {
+		nodes = append([]int{node}, nodes...)
+	}
+
+	path := TastePath{Similarity: math.Exp(-distance[target])}
+	for i, node := range nodes {
+		gameId := graph.ids[node]
+		path.Games = append(path.Games, PathGame{GameId: gameId, Name: names[gameId]})
+		if i > 0 {
+			previousId := graph.ids[nodes[i-1]]
+			path.Edges = append(path.Edges, PathEdge{
+				From:       previousId,
+				To:         gameId,
+				Similarity: graph.adjacency[nodes[i-1]][node],
+			})
+		}
+	}
+	return path, nil
+}
+
+// resolveGame accepts an app id or the exact name of a store entry. A name
+// shared by several store entries is an error listing their ids.
+func resolveGame(value string, names map[int]string) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+	var ids []int
+	for id, name := range names {
+		if strings.EqualFold(name, value) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("unknown game %q", value)
+	case 1:
+		return ids[0], nil
+	}
+	return 0, fmt.Errorf("%q is the name of apps %v, use an app id", value, ids)
+}
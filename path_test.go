@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindTastePath(t *testing.T) {
+	graph := testSimilarityGraph([]testEdge{
+		{1, 2, 0.9}, {2, 3, 0.8}, {1, 3, 0.5}, {3, 4, 0.6},
+		{5, 6, 0.9},
+	})
+	names := map[int]string{1: "Portal", 4: "Doom"}
+
+	path, err := findTastePath(graph, 1, 4, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var games []int
+	for _, game := range path.Games {
+		games = append(games, game.GameId)
+	}
+	// 0.9 * 0.8 * 0.6 = 0.432 beats 0.5 * 0.6 = 0.3 through the direct link
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(games, want) {
+		t.Errorf("path = %v, want %v", games, want)
+	}
+	cost := -(math.Log(0.9) + math.Log(0.8) + math.Log(0.6))
+	if math.Abs(path.Similarity-math.Exp(-cost)) > 1e-12 {
+		t.Errorf("path similarity = %v, want %v", path.Similarity, math.Exp(-cost))
+	}
+	if path.Games[0].Name != "Portal" || len(path.Edges) != 3 || path.Edges[2] != (PathEdge{From: 3, To: 4, Similarity: 0.6}) {
+		t.Errorf("path = %+v", path)
+	}
+
+	if _, err := findTastePath(graph, 1, 5, names); err != errNoPath {
+		t.Errorf("path between unconnected games = %v, want errNoPath", err)
+	}
+	if _, err := findTastePath(graph, 1, 99, names); err != errGameNotInGraph {
+		t.Errorf("path to a game without links = %v, want errGameNotInGraph", err)
+	}
+}
+
+func TestResolveGame(t *testing.T) {
+	names := map[int]string{10: "Portal", 20: "Doom", 30: "DOOM", 40: "Half-Life"}
+
+	tests := map[string]int{"440": 440, "portal": 10, "Half-Life": 40}
+	for value, want := range tests {
+		if id, err := resolveGame(value, names); err != nil || id != want {
+			t.Errorf("resolveGame(%q) = %v, %v, want %v", value, id, err, want)
+		}
+	}
+
+	if _, err := resolveGame("doom", names); err == nil || !strings.Contains(err.Error(), "[20 30]") {
+		t.Errorf("resolveGame of a shared name = %v, want an error listing both ids", err)
+	}
+	if _, err := resolveGame("Quake", names); err == nil {
+		t.Error("resolveGame of an unknown name succeeded")
+	}
+}
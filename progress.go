@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+const progressSaveInterval = 30 * time.Second
+
+// Rates are measured over the most recent items of real work, so that a
+// stage waiting on the Steam rate limit reports the rate it gets now.
+const progressRateWindow = 100
+
+// Attempts at an item before a stage gives up on it and counts it failed.
+const maxAttempts = 5
+
+// progressTracker counts the items of a pipeline stage and periodically
+// logs and saves a status snapshot. It is safe for concurrent use.
+type progressTracker struct {
+	mu        sync.Mutex
+	status    StageStatus
+	completed []time.Time
+	lastSave  time.Time
+}
+
+func newProgressTracker(stage string, total int) *progressTracker {
+	now := time.Now()
+	p := &progressTracker{
+		status: StageStatus{
+			Stage:     stage,
+			State:     stageRunning,
+			Total:     total,
+			Remaining: total,
+			StartedAt: now,
+			UpdatedAt: now,
+		},
+		lastSave: now,
+	}
+	stageRemaining.set(float64(total), stage)
+	database.saveStageStatus(p.status)
+	return p
+}
+
+// processed counts an item the stage did its work on.
+func (p *progressTracker) processed() {
+	p.advance("processed")
+}
+
+// skipped counts an item left alone, such as one done by a previous run.
+func (p *progressTracker) skipped() {
+	p.advance("skipped")
+}
+
+// failed counts an item given up on.
+func (p *progressTracker) failed() {
+	p.advance("failed")
+}
+
+// retried counts an attempt that will be repeated.
+func (p *progressTracker) retried() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.Retries++
+}
+
+func (p *progressTracker) advance(outcome string) {
+	stageItems.inc(p.status.Stage, outcome)
+	stageRemaining.add(-1, p.status.Stage)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	switch outcome {
+	case "processed":
+		p.status.Processed++
+	case "skipped":
+		p.status.Skipped++
+	case "failed":
+		p.status.Failed++
+	}
+	if outcome != "skipped" {
+		p.completed = append(p.completed, now)
+		if len(p.completed) > progressRateWindow {
+			p.completed = p.completed[1:]
+		}
+	}
+	p.update(now)
+
+	if now.Sub(p.lastSave) >= progressSaveInterval {
+		p.lastSave = now
+		p.save()
+	}
+}
+
+// update recomputes the derived fields. Callers hold mu.
+func (p *progressTracker) update(now time.Time) {
+	s := &p.status
+	done := s.Processed + s.Skipped + s.Failed
+	s.Remaining = s.Total - done
+	if s.Remaining < 0 {
+		s.Remaining = 0
+	}
+	if s.Total > 0 {
+		s.Percent = math.Round(float64(done)/float64(s.Total)*10000) / 100
+	}
+	s.UpdatedAt = now
+
+	s.RatePerMinute = 0
+	s.ETASeconds = nil
+	s.EstimatedCompletion = nil
+
+	// Until the window fills up, the rate is measured from the start
+	since, items := s.StartedAt, len(p.completed)
+	if items == progressRateWindow {
+		since, items = p.completed[0], items-1
+	}
+	elapsed := now.Sub(since)
+	if items == 0 || elapsed <= 0 {
+		return
+	}
+	s.RatePerMinute = math.Round(float64(items)/elapsed.Minutes()*100) / 100
+
+	if s.RatePerMinute > 0 {
+		eta := math.Round(float64(s.Remaining) / s.RatePerMinute * 60)
+		completion := now.Add(time.Duration(eta) * time.Second)
+		s.ETASeconds = &eta
+		s.EstimatedCompletion = &completion
+	}
+}
+
+// save logs and stores the snapshot. Callers hold mu.
+func (p *progressTracker) save() {
+	s := p.status
+	args := []interface{}{
+		"stage", s.Stage,
+		"processed", s.Processed,
+		"skipped", s.Skipped,
+		"failed", s.Failed,
+		"retries", s.Retries,
+		"remaining", s.Remaining,
+		"percent", s.Percent,
+		"ratePerMinute", s.RatePerMinute,
+	}
+	if s.ETASeconds != nil {
+		args = append(args, "eta", time.Duration(*s.ETASeconds)*time.Second)
+	}
+	slog.Info("progress", args...)
+	database.saveStageStatus(s)
+}
+
+// finish marks the stage done and saves the final snapshot.
+func (p *progressTracker) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.update(now)
+	p.status.State = stageFinished
+	p.status.FinishedAt = &now
+	p.status.ETASeconds = nil
+	p.status.EstimatedCompletion = nil
+	p.save()
+}
+
+// stageStatuses returns the saved snapshots, of every stage when stage is
+// empty. ok is false when the stage never ran.
+func stageStatuses(stage string) ([]StageStatus, bool) {
+	statuses := database.findStageStatuses()
+	if stage == "" {
+		return statuses, true
+	}
+	for _, status := range statuses {
+		if status.Stage == stage {
+			return []StageStatus{status}, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const defaultRecommendationLimit = 20
+const defaultNeighborsPerSeed = 50
+const maxExplanations = 3
+
+var errUserNotFound = errors.New("user not found")
+
+// recommendForUser ranks the game-links neighbours of every game the user
+// reviewed, leaving out the games they already reviewed.
+func recommendForUser(steamId string, limit int, neighborsPerSeed int) ([]Recommendation, error) {
+	defer timeTrack(time.Now(), "recommendForUser")
+
+	userLink := database.findUserLink(steamId)
+	if userLink.UserId == "" {
+		return nil, errUserNotFound
+	}
+	slog.Debug("user reviews", "steamid", steamId, "games", len(userLink.GamesReviewed))
+
+	neighbors := make(map[int][]GameSimilarity)
+	for _, gameLink := range database.findGameLinks(userLink.GamesReviewed) {
+		neighbors[gameLink.GameId] = gameLink.SimilarGames
+	}
+
+	recommendations := rankNeighbors(userLink.GamesReviewed, neighbors, neighborsPerSeed, limit)
+	nameRecommendations(recommendations)
+
+	return recommendations, nil
+}
+
+// rankNeighbors scores each candidate by summing its similarity to every seed.
+// Scores are normalised per seed so that a seed with a huge reviewer base
+// doesn't drown out the others. Limits below zero count as zero.
+func rankNeighbors(seeds []int, neighbors map[int][]GameSimilarity, neighborsPerSeed int, limit int) []Recommendation {
+	if neighborsPerSeed < 0 {
+		neighborsPerSeed = 0
+	}
+	if limit < 0 {
+		limit = 0
+	}
+
+	reviewed := make(map[int]bool)
+	for _, seed := range seeds {
+		reviewed[seed] = true
+	}
+
+	candidates := make(map[int]*Recommendation)
+
+	for _, seed := range seeds {
+		similarGames := neighbors[seed]
+		if len(similarGames) > neighborsPerSeed {
+			similarGames = similarGames[:neighborsPerSeed]
+		}
+
+		var maxScore float64
+		for _, similarGame := range similarGames {
+			if score := similarityScore(similarGame); score > maxScore {
+				maxScore = score
+			}
+		}
+		if maxScore == 0 {
+			continue
+		}
+
+		for _, similarGame := range similarGames {
+			if reviewed[similarGame.GameId] {
+				continue
+			}
+			score := similarityScore(similarGame) / maxScore
+
+			candidate, ok := candidates[similarGame.GameId]
+			if !ok {
+				candidate = &Recommendation{GameId: similarGame.GameId}
+				candidates[similarGame.GameId] = candidate
+			}
+			candidate.Score += score
+			candidate.Because = append(candidate.Because, SeedContribution{GameId: seed, Score: score})
+		}
+	}
+
+	recommendations := []Recommendation{}
+	for _, candidate := range candidates {
+		sort.Slice(candidate.Because, func(i, j int) bool {
+			return candidate.Because[i].Score > candidate.Because[j].Score
+		})
+		if len(candidate.Because) > maxExplanations {
+			candidate.Because = candidate.Because[:maxExplanations]
+		}
+		recommendations = append(recommendations, *candidate)
+	}
+
+	sort.Slice(recommendations, func(i, j int) bool {
+		if recommendations[i].Score != recommendations[j].Score {
+			return recommendations[i].Score > recommendations[j].Score
+		}
+		return recommendations[i].GameId < recommendations[j].GameId
+	})
+	if len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+	return recommendations
+}
+
+func nameRecommendations(recommendations []Recommendation) {
+	var ids []int
+	for _, recommendation := range recommendations {
+		ids = append(ids, recommendation.GameId)
+		for _, seed := range recommendation.Because {
+			ids = append(ids, seed.GameId)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	names := make(map[int]string)
+	for _, entry := range database.findStoreEntriesByIds(ids) {
+		names[entry.ID] = entry.Name
+	}
+
+	for i := range recommendations {
+		recommendations[i].Name = names[recommendations[i].GameId]
+		for j := range recommendations[i].Because {
+			recommendations[i].Because[j].Name = names[recommendations[i].Because[j].GameId]
+		}
+	}
+}
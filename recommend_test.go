@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRankNeighbors(t *testing.T) {
+	neighbors := map[int][]GameSimilarity{
+		10: {{GameId: 20, Score: 8}, {GameId: 30, Score: 4}, {GameId: 40, Score: 2}},
+		20: {{GameId: 30, Score: 5}, {GameId: 10, Score: 5}},
+	}
+
+	recommendations := rankNeighbors([]int{10, 20}, neighbors, 2, 5)
+	want := []Recommendation{
+		{GameId: 30, Score: 1.5, Because: []SeedContribution{{GameId: 20, Score: 1}, {GameId: 10, Score: 0.5}}},
+	}
+	if !reflect.DeepEqual(recommendations, want) {
+		t.Errorf("recommendations = %+v, want %+v", recommendations, want)
+	}
+
+	if recommendations := rankNeighbors([]int{10}, neighbors, 3, 1); len(recommendations) != 1 || recommendations[0].GameId != 20 {
+		t.Errorf("recommendations limited to 1 = %+v", recommendations)
+	}
+
+	for _, limits := range [][2]int{{-1, 5}, {2, -1}, {0, 0}} {
+		if recommendations := rankNeighbors([]int{10, 20}, neighbors, limits[0], limits[1]); len(recommendations) != 0 {
+			t.Errorf("recommendations with %v neighbors and a limit of %v = %+v, want none", limits[0], limits[1], recommendations)
+		}
+	}
+}
+
+func TestRankSimilarGamesBreaksTiesByGameId(t *testing.T) {
+	userLinks := []UserLinkDTO{
+		{UserId: "a", GamesReviewed: []int{10, 50, 40, 30}},
+		{UserId: "b", GamesReviewed: []int{10, 40, 20}},
+		{UserId: "c", GamesReviewed: []int{10, 50, 20}},
+	}
+	var ranked []int
+	for _, similarity := range rankSimilarGames(10, userLinks) {
+		ranked = append(ranked, similarity.GameId)
+	}
+	if want := []int{20, 40, 50, 30}; !reflect.DeepEqual(ranked, want) {
+		t.Errorf("ranked games = %v, want %v", ranked, want)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// httpFixture holds the responses recorded for one request, in order.
+// Bodies that are JSON are kept as JSON so fixtures can be read and edited.
+type httpFixture struct {
+	Method    string             `json:"method"`
+	URL       string             `json:"url"`
+	Responses []recordedResponse `json:"responses"`
+}
+
+type recordedResponse struct {
+	Status   int                 `json:"status"`
+	Header   map[string][]string `json:"header,omitempty"`
+	BodyJSON json.RawMessage     `json:"bodyJson,omitempty"`
+	Body     string              `json:"body,omitempty"`
+}
+
+// fixtureKey identifies a request by method and URL, with the query
+// parameters in a canonical order.
+func fixtureKey(method string, u *url.URL) string {
+	canonical := *u
+	canonical.RawQuery = u.Query().Encode()
+	canonical.Fragment = ""
+	return method + " " + canonical.String()
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func fixtureFileName(key string, u *url.URL) string {
+	sum := sha1.Sum([]byte(key))
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(u.Host+u.Path, "-"), "-")
+	return name + "-" + hex.EncodeToString(sum[:])[:12] + ".json"
+}
+
+// recordingTransport passes requests on to next and appends each response
+// to the fixture file of its request in dir.
+type recordingTransport struct {
+	next http.RoundTripper
+	dir  string
+	mu   sync.Mutex
+}
+
+func newRecordingTransport(dir string, next http.RoundTripper) (*recordingTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &recordingTransport{next: next, dir: dir}, nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	recorded := recordedResponse{Status: res.StatusCode, Header: map[string][]string{}}
+	if contentType := res.Header.Get("Content-Type"); contentType != "" {
+		recorded.Header["Content-Type"] = []string{contentType}
+	}
+	if json.Valid(body) {
+		var indented bytes.Buffer
+		json.Indent(&indented, body, "", "  ")
+		recorded.BodyJSON = indented.Bytes()
+	} else {
+		recorded.Body = string(body)
+	}
+
+	key := fixtureKey(req.Method, req.URL)
+	path := filepath.Join(t.dir, fixtureFileName(key, req.URL))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fixture := httpFixture{Method: req.Method, URL: req.URL.String()}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, fmt.Errorf("reading fixture %v: %v", path, err)
+		}
+	}
+	fixture.Responses = append(fixture.Responses, recorded)
+
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	slog.Debug("recorded response", "url", req.URL.String(), "status", res.StatusCode, "fixture", path)
+	return res, nil
+}
+
+// replayTransport answers requests from the fixtures in a directory. A
+// request repeated more often than it was recorded gets the last response.
+type replayTransport struct {
+	fixtures map[string]httpFixture
+	mu       sync.Mutex
+	served   map[string]int
+}
+
+func newReplayTransport(dir string) (*replayTransport, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &replayTransport{fixtures: make(map[string]httpFixture), served: make(map[string]int)}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var fixture httpFixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, fmt.Errorf("reading fixture %v: %v", path, err)
+		}
+		u, err := url.Parse(fixture.URL)
+		if err != nil {
+			return nil, fmt.Errorf("reading fixture %v: %v", path, err)
+		}
+		if fixture.Method == "" {
+			fixture.Method = http.MethodGet
+		}
+		t.fixtures[fixtureKey(fixture.Method, u)] = fixture
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := fixtureKey(req.Method, req.URL)
+	fixture, ok := t.fixtures[key]
+	if !ok || len(fixture.Responses) == 0 {
+		return nil, fmt.Errorf("no fixture for %v", key)
+	}
+
+	t.mu.Lock()
+	i := t.served[key]
+	t.served[key]++
+	t.mu.Unlock()
+
+	if i >= len(fixture.Responses) {
+		i = len(fixture.Responses) - 1
+	}
+	recorded := fixture.Responses[i]
+
+	body := []byte(recorded.Body)
+	if len(recorded.BodyJSON) > 0 {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, recorded.BodyJSON); err != nil {
+			return nil, err
+		}
+		body = compact.Bytes()
+	}
+	header := http.Header{}
+	for name, values := range recorded.Header {
+		header[http.CanonicalHeaderKey(name)] = values
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+type steamTransportFlags struct {
+	steamUrl *string
+	record   *string
+	replay   *string
+	archive  *string
+	cache    *string
+	cacheTTL *string
+	offline  *bool
+}
+
+// addSteamTransportFlags adds the flags that choose where a command that
+// calls the Steam API gets its responses from.
+func addSteamTransportFlags(flags *flag.FlagSet) steamTransportFlags {
+	return steamTransportFlags{
+		steamUrl: flags.String("steam-url", "", "base URL serving every Steam endpoint, such as a fake-steam server"),
+		record:   flags.String("record", "", "directory to record Steam responses into"),
+		replay:   flags.String("replay", "", "directory of recorded Steam responses to answer from instead of Steam"),
+		archive:  flags.String("archive", "", "directory archiving every raw Steam response, for reparse"),
+		cache:    flags.String("cache", "", "directory caching Steam responses"),
+		cacheTTL: flags.String("cache-ttl", "", "comma separated endpoint=duration overriding how long cached responses are used, 0 for ever"),
+		offline:  flags.Bool("offline", false, "answer only from the cache, failing requests that aren't cached"),
+	}
+}
+
+// apply sets up the Steam client. The cache sits in front of archiving and
+// recording, so only requests that reach Steam are archived and recorded.
+func (f steamTransportFlags) apply() {
+	if *f.steamUrl != "" {
+		setSteamUrl(*f.steamUrl)
+	}
+
+	var transport http.RoundTripper = meteredTransport{http.DefaultTransport}
+	switch {
+	case *f.record != "" && *f.replay != "":
+		fatal("record and replay can't be used together")
+	case *f.record != "":
+		recorder, err := newRecordingTransport(*f.record, transport)
+		if err != nil {
+			fatal("creating recording transport failed", "error", err)
+		}
+		transport = recorder
+	case *f.replay != "":
+		replayer, err := newReplayTransport(*f.replay)
+		if err != nil {
+			fatal("loading fixtures failed", "error", err)
+		}
+		transport = replayer
+	}
+
+	if *f.archive != "" {
+		archive, err := newArchivingTransport(*f.archive, transport)
+		if err != nil {
+			fatal("creating response archive failed", "error", err)
+		}
+		transport = archive
+	}
+
+	if *f.offline && *f.cache == "" {
+		fatal("offline needs a cache directory")
+	}
+	if *f.cache != "" {
+		ttls, err := parseCacheTTLs(*f.cacheTTL)
+		if err != nil {
+			fatal("invalid cache TTL", "error", err)
+		}
+		cache, err := newCachingTransport(*f.cache, transport, ttls, *f.offline)
+		if err != nil {
+			fatal("creating response cache failed", "error", err)
+		}
+		transport = cache
+		slog.Info("caching steam responses", "dir", *f.cache, "offline", *f.offline)
+	}
+
+	// Nothing reaches Steam, so there is no rate limit to wait for
+	if *f.offline {
+		reviewPageDelay, reviewGameDelay, entryDetailsDelay = 0, 0, 0
+	}
+
+	steamClient.Transport = transport
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const minSearchScore = 0.2
+
+// searchIndex is an in-memory trigram index over store entry names.
+type searchIndex struct {
+	entries  []indexedEntry
+	postings map[string][]int
+}
+
+type indexedEntry struct {
+	gameId     int
+	name       string
+	normalized string
+	trigrams   int
+	reviews    int
+}
+
+// normalizeName lowercases a name and turns every run of symbols, such as
+// ™, ®, colons or dashes, into a single space.
+func normalizeName(name string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteRune(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// trigrams returns the distinct trigrams of every word padded with spaces,
+// as pg_trgm does, so that short words and word starts still match.
+func trigrams(normalized string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(normalized) {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+func buildSearchIndex() *searchIndex {
+	defer timeTrack(time.Now(), "buildSearchIndex")
+
+	return newSearchIndex(getAllStoreEntries(), database.findAllReviewCounts())
+}
+
+func newSearchIndex(entries []StoreEntryDTO, reviewCounts map[int]int) *searchIndex {
+	index := &searchIndex{postings: make(map[string][]int)}
+
+	for _, entry := range entries {
+		normalized := normalizeName(entry.Name)
+		if normalized == "" {
+			continue
+		}
+		grams := trigrams(normalized)
+
+		i := len(index.entries)
+		index.entries = append(index.entries, indexedEntry{
+			gameId:     entry.ID,
+			name:       entry.Name,
+			normalized: normalized,
+			trigrams:   len(grams),
+			reviews:    reviewCounts[entry.ID],
+		})
+		for gram := range grams {
+			index.postings[gram] = append(index.postings[gram], i)
+		}
+	}
+	return index
+}
+
+// search ranks entries by trigram similarity to the query, boosted for exact
+// and prefix matches and corrected by edit distance for typos. Equal scores
+// are ordered by review count.
+func (index *searchIndex) search(query string) []SearchResult {
+	normalized := normalizeName(query)
+	if normalized == "" {
+		return []SearchResult{}
+	}
+	queryGrams := trigrams(normalized)
+
+	shared := make(map[int]int)
+	for gram := range queryGrams {
+		for _, i := range index.postings[gram] {
+			shared[i]++
+		}
+	}
+
+	results := []SearchResult{}
+	for i, count := range shared {
+		entry := index.entries[i]
+		score := float64(count) / float64(len(queryGrams)+entry.trigrams-count)
+
+		switch {
+		case entry.normalized == normalized:
+			score += 1
+		case strings.HasPrefix(entry.normalized, normalized):
+			score += 0.5
+		case strings.Contains(entry.normalized, normalized):
+			score += 0.25
+		default:
+			score = math.Max(score, editSimilarity(normalized, entry.normalized))
+		}
+		if score < minSearchScore {
+			continue
+		}
+
+		results = append(results, SearchResult{
+			GameId:  entry.gameId,
+			Name:    entry.name,
+			Score:   math.Round(score*1000) / 1000,
+			Reviews: entry.reviews,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Reviews != results[j].Reviews {
+			return results[i].Reviews > results[j].Reviews
+		}
+		return results[i].GameId < results[j].GameId
+	})
+	return results
+}
+
+// editSimilarity is 1 minus the Levenshtein distance between the query and
+// the closest prefix of the name, relative to the query's length.
+func editSimilarity(query string, name string) float64 {
+	a := []rune(query)
+	b := []rune(name)
+	if len(b) > len(a)+2 {
+		b = b[:len(a)+2]
+	}
+
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	best := previous[0]
+	for _, d := range previous {
+		best = minInt(best, d)
+	}
+	return 1 - float64(best)/float64(utf8.RuneCountInString(query))
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
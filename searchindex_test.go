@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeNameAndTrigrams(t *testing.T) {
+	if got := normalizeName("  Portal 2™: Deluxe — Edition "); got != "portal 2 deluxe edition" {
+		t.Errorf("normalizeName = %q", got)
+	}
+	want := map[string]bool{"  a": true, " ab": true, "ab ": true, "  c": true, " c ": true}
+	if got := trigrams("ab c"); !reflect.DeepEqual(got, want) {
+		t.Errorf("trigrams = %v, want %v", got, want)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	index := newSearchIndex([]StoreEntryDTO{
+		{ID: 10, Name: "Portal"},
+		{ID: 20, Name: "Portal 2"},
+		{ID: 30, Name: "Portal Knights"},
+		{ID: 40, Name: "Half-Life"},
+		{ID: 50, Name: "™"},
+	}, map[int]int{10: 500, 20: 1000, 30: 50})
+
+	ids := func(results []SearchResult) []int {
+		var ids []int
+		for _, result := range results {
+			ids = append(ids, result.GameId)
+		}
+		return ids
+	}
+
+	// The exact match, then the prefix matches by trigram similarity
+	if got := ids(index.search("PORTAL")); !reflect.DeepEqual(got, []int{10, 20, 30}) {
+		t.Errorf("search(PORTAL) = %v, want [10 20 30]", got)
+	}
+	// A typo is as far from both names starting with portal, so the one
+	// with more reviews comes first
+	if got := ids(index.search("portla")); len(got) < 2 || got[0] != 20 || got[1] != 10 {
+		t.Errorf("search(portla) = %v, want 20 then 10", got)
+	}
+	if got := index.search("half life"); len(got) != 1 || got[0].GameId != 40 || got[0].Score != 2 {
+		t.Errorf("search(half life) = %+v, want only 40 with an exact match score", got)
+	}
+	if got := index.search("zzz"); len(got) != 0 {
+		t.Errorf("search(zzz) = %+v, want none", got)
+	}
+}
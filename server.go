@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultPageLimit = 20
+const maxPageLimit = 200
+
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, a ...interface{}) error {
+	return apiError{http.StatusBadRequest, fmt.Sprintf(format, a...)}
+}
+
+func notFound(format string, a ...interface{}) error {
+	return apiError{http.StatusNotFound, fmt.Sprintf(format, a...)}
+}
+
+//go:embed web
+var webFiles embed.FS
+
+// apiHandler returns the value to encode as the JSON response body.
+type apiHandler func(r *http.Request) (interface{}, error)
+
+// lazyBuild runs a build on first use. A build that panics, such as on a
+// database error, leaves it unbuilt so that the next request tries again.
+type lazyBuild struct {
+	mu    sync.Mutex
+	built bool
+}
+
+func (l *lazyBuild) ensure(build func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.built {
+		build()
+		l.built = true
+	}
+}
+
+// gameSearch is built on the first search, from every store entry.
+var gameSearch struct {
+	lazy  lazyBuild
+	index *searchIndex
+}
+
+// pathGraph is built once per server from every game-link, as /path needs
+// the whole catalog.
+var pathGraph struct {
+	lazy  lazyBuild
+	graph similarityGraph
+	names map[int]string
+}
+
+func serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/apps/", serveJSON(handleApps))
+	mux.Handle("/users/", serveJSON(handleUsers))
+	mux.Handle("/search", serveJSON(handleSearch))
+	mux.Handle("/path", serveJSON(handlePath))
+	mux.Handle("/status", serveJSON(handleStatus))
+	mux.HandleFunc("/graphql", handleGraphQL)
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	web, err := fs.Sub(webFiles, "web")
+	check(err)
+	mux.Handle("/", http.FileServer(http.FS(web)))
+
+	slog.Info("serving", "addr", addr)
+	fatal("server stopped", "error", http.ListenAndServe(addr, mux))
+}
+
+// serveJSON writes the handler's result with an ETag, answering 304 when the
+// client already has it, and turns errors and panics into JSON errors.
+func serveJSON(handler apiHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				slog.Error("panic serving request", "url", r.URL.String(), "panic", recovered)
+				writeError(w, apiError{http.StatusInternalServerError, "internal error"})
+			}
+		}()
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, apiError{http.StatusMethodNotAllowed, "only GET is supported"})
+			return
+		}
+
+		value, err := handler(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		body, err := json.Marshal(value)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		sum := sha1.Sum(body)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/json")
+
+		if matchesETag(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(body)
+	})
+}
+
+func matchesETag(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	message := "internal error"
+
+	var e apiError
+	if errors.As(err, &e) {
+		status, message = e.status, e.message
+	} else {
+		slog.Error("API error", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(ErrorResponse{Error: ErrorBody{Status: status, Message: message}})
+	w.Write(body.Bytes())
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, badRequest("%v must be an integer", name)
+	}
+	return n, nil
+}
+
+func pageParams(r *http.Request) (int, int, error) {
+	limit, err := queryInt(r, "limit", defaultPageLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if limit < 1 || limit > maxPageLimit {
+		return 0, 0, badRequest("limit must be between 1 and %v", maxPageLimit)
+	}
+	if offset < 0 {
+		return 0, 0, badRequest("offset must not be negative")
+	}
+	return limit, offset, nil
+}
+
+// pageBounds clamps [offset, offset+limit) to a slice of length total.
+func pageBounds(total int, limit int, offset int) (int, int) {
+	start := offset
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// handleApps routes /apps/{id}, /apps/{id}/similar and /apps/{id}/graph.
+func handleApps(r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/apps/"), "/"), "/")
+
+	gameId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, notFound("unknown app %q", parts[0])
+	}
+
+	switch {
+	case len(parts) == 1:
+		return appResponse(gameId)
+	case len(parts) == 2 && parts[1] == "similar":
+		return similarResponse(r, gameId)
+	case len(parts) == 2 && parts[1] == "graph":
+		return graphResponse(r, gameId)
+	}
+	return nil, notFound("unknown path %v", r.URL.Path)
+}
+
+func appResponse(gameId int) (interface{}, error) {
+	entries := database.findStoreEntriesByIds([]int{gameId})
+	if len(entries) == 0 {
+		return nil, notFound("unknown app %v", gameId)
+	}
+
+	app := AppResponse{
+		GameId:  gameId,
+		Name:    entries[0].Name,
+		Reviews: database.findReviewCounts([]int{gameId})[gameId],
+	}
+	if games := database.findGamesByIds([]int{gameId}); len(games) > 0 {
+		app.IsGame = true
+		app.Genres = games[0].Genres
+		app.Community = games[0].Community
+		app.Centrality = games[0].Centrality
+	}
+	return app, nil
+}
+
+func similarResponse(r *http.Request, gameId int) (interface{}, error) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = "score"
+	}
+	if len(database.findStoreEntriesByIds([]int{gameId})) == 0 {
+		return nil, notFound("unknown app %v", gameId)
+	}
+
+	var similarGames []GameSimilarity
+
+	if metric == "als" {
+		similarGames, err = findSimilarGamesALS(gameId, offset+limit)
+		if err != nil {
+			return nil, apiError{http.StatusServiceUnavailable, err.Error()}
+		}
+	} else {
+		if !isSimilarityMetric(metric) {
+			return nil, badRequest("metric must be one of %v or als", strings.Join(similarityMetrics, ", "))
+		}
+		gameLink := database.findGameLink(gameId)
+		similarGames = gameLink.SimilarGames
+		if metric == "jaccard" {
+			similarGames = gameLink.ApproxSimilarGames
+		}
+	}
+
+	ids := []int{gameId}
+	for _, similarGame := range similarGames {
+		ids = append(ids, similarGame.GameId)
+	}
+	reviewCounts := make(map[int]int)
+	if metric == "cosine" {
+		reviewCounts = database.findReviewCounts(ids)
+	}
+
+	items := []SimilarGameResponse{}
+	for _, similarGame := range similarGames {
+		value := similarGame.Score
+		if metric != "als" {
+			value = metricValue(similarGame, metric, reviewCounts[gameId], reviewCounts[similarGame.GameId])
+		}
+		items = append(items, SimilarGameResponse{
+			GameId:     similarGame.GameId,
+			Count:      similarGame.Count,
+			Score:      similarGame.Score,
+			Similarity: value,
+		})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Similarity > items[j].Similarity
+	})
+
+	total := len(items)
+	start, end := pageBounds(total, limit, offset)
+	items = items[start:end]
+
+	var pageIds []int
+	for _, item := range items {
+		pageIds = append(pageIds, item.GameId)
+	}
+	names := make(map[int]string)
+	if len(pageIds) > 0 {
+		for _, entry := range database.findStoreEntriesByIds(pageIds) {
+			names[entry.ID] = entry.Name
+		}
+	}
+	for i := range items {
+		items[i].Name = names[items[i].GameId]
+	}
+
+	return Page{Items: items, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+func graphResponse(r *http.Request, gameId int) (interface{}, error) {
+	params := defaultGraphParams
+
+	depth, err := queryInt(r, "depth", params.depth)
+	if err != nil {
+		return nil, err
+	}
+	if depth < 1 || depth > maxGraphDepth {
+		return nil, badRequest("depth must be between 1 and %v", maxGraphDepth)
+	}
+	params.depth = depth
+
+	// The fanouts are capped so that a request can't build a huge graph
+	if fanout := r.URL.Query().Get("fanout"); fanout != "" {
+		parts := strings.Split(fanout, ",")
+		if len(parts) > maxGraphDepth {
+			return nil, badRequest("fanout must have at most %v values", maxGraphDepth)
+		}
+		params.fanout = nil
+		for _, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil || n <= 0 {
+				return nil, badRequest("fanout must be a comma separated list of positive integers")
+			}
+			if n > maxPageLimit {
+				return nil, badRequest("fanout must be at most %v", maxPageLimit)
+			}
+			params.fanout = append(params.fanout, n)
+		}
+	}
+
+	if len(database.findStoreEntriesByIds([]int{gameId})) == 0 {
+		return nil, notFound("unknown app %v", gameId)
+	}
+	return findOrBuildGraph(gameId, params, false), nil
+}
+
+// handleUsers routes /users/{steamid}/recommendations.
+func handleUsers(r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/users/"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "recommendations" {
+		return nil, notFound("unknown path %v", r.URL.Path)
+	}
+	steamId := parts[0]
+
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var recommendations []Recommendation
+	switch algorithm := r.URL.Query().Get("algorithm"); algorithm {
+	case "", "itemcf":
+		recommendations, err = recommendForUser(steamId, offset+limit, defaultNeighborsPerSeed)
+	case "als":
+		recommendations, err = recommendForUserALS(steamId, offset+limit)
+	default:
+		return nil, badRequest("algorithm must be itemcf or als")
+	}
+
+	switch err {
+	case nil:
+	case errUserNotFound:
+		return nil, notFound("unknown user %v", steamId)
+	case errModelNotTrained:
+		return nil, apiError{http.StatusServiceUnavailable, err.Error()}
+	default:
+		return nil, err
+	}
+
+	start, end := pageBounds(len(recommendations), limit, offset)
+	return RecommendationPage{Items: recommendations[start:end], Limit: limit, Offset: offset}, nil
+}
+
+func handleSearch(r *http.Request) (interface{}, error) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		return nil, badRequest("q is required")
+	}
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	gameSearch.lazy.ensure(func() {
+		gameSearch.index = buildSearchIndex()
+	})
+
+	results := gameSearch.index.search(query)
+	start, end := pageBounds(len(results), limit, offset)
+	return Page{Items: results[start:end], Total: len(results), Limit: limit, Offset: offset}, nil
+}
+
+func handleStatus(r *http.Request) (interface{}, error) {
+	stage := r.URL.Query().Get("stage")
+	statuses, ok := stageStatuses(stage)
+	if !ok {
+		return nil, notFound("no status for stage %v", stage)
+	}
+	return statuses, nil
+}
+
+func handlePath(r *http.Request) (interface{}, error) {
+	if r.URL.Query().Get("from") == "" || r.URL.Query().Get("to") == "" {
+		return nil, badRequest("from and to are required")
+	}
+	from, err := queryInt(r, "from", 0)
+	if err != nil {
+		return nil, err
+	}
+	to, err := queryInt(r, "to", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	pathGraph.lazy.ensure(func() {
+		pathGraph.graph = buildSimilarityGraph(defaultPathParams.topK, defaultPathParams.metric, defaultPathParams.minWeight)
+		pathGraph.names = populateGameNameMap()
+	})
+
+	path, err := findTastePath(pathGraph.graph, from, to, pathGraph.names)
+	switch err {
+	case nil:
+		return path, nil
+	case errGameNotInGraph, errNoPath:
+		return nil, notFound("%v", err)
+	}
+	return nil, err
+}
+
+// handleGraphQL accepts a query as a JSON POST body or as query, variables
+// and operationName GET parameters.
+func handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			slog.Error("panic serving request", "url", r.URL.String(), "panic", recovered)
+			writeError(w, apiError{http.StatusInternalServerError, "internal error"})
+		}
+	}()
+
+	var request gqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		request.Query = r.URL.Query().Get("query")
+		request.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				writeError(w, badRequest("variables must be a JSON object"))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, badRequest("body must be a JSON object with a query"))
+			return
+		}
+	default:
+		writeError(w, apiError{http.StatusMethodNotAllowed, "only GET and POST are supported"})
+		return
+	}
+	if strings.TrimSpace(request.Query) == "" {
+		writeError(w, badRequest("query is required"))
+		return
+	}
+
+	response := executeGraphQL(graphqlSchema, newGqlContext(), request)
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Data == nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(response)
+}
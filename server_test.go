@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLazyBuildRetriesAfterAPanic(t *testing.T) {
+	var lazy lazyBuild
+	builds := 0
+	build := func() {
+		builds++
+		if builds == 1 {
+			panic("database error")
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the first build didn't panic")
+			}
+		}()
+		lazy.ensure(build)
+	}()
+
+	lazy.ensure(build)
+	lazy.ensure(build)
+	if builds != 2 {
+		t.Errorf("built %v times, want a retry after the panic and no build after that", builds)
+	}
+}
+
+func TestHandlersRejectBadParameters(t *testing.T) {
+	tests := []struct {
+		handler apiHandler
+		url     string
+	}{
+		{handleApps, "/apps/440/graph?depth=4"},
+		{handleApps, "/apps/440/graph?fanout=100000"},
+		{handleApps, "/apps/440/graph?depth=3&fanout=10,10,10,10"},
+		{handleApps, "/apps/440/graph?fanout=10,-1"},
+		{handlePath, "/path?to=440"},
+		{handlePath, "/path?from=440"},
+	}
+	for _, test := range tests {
+		_, err := test.handler(httptest.NewRequest(http.MethodGet, test.url, nil))
+		if apiErr, ok := err.(apiError); !ok || apiErr.status != http.StatusBadRequest {
+			t.Errorf("GET %v = %v, want a bad request", test.url, err)
+		}
+	}
+}
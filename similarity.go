@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const defaultPlaytimeCapHours = 100
+
+// unknownPlaytime marks reviews crawled before playtime was recorded.
+const unknownPlaytime = -1
+
+// playtimeWeighting scales a reviewer's contribution to a co-occurrence by
+// how long they had played, log-scaled so that the first hours matter most
+// and capped so that a handful of 2,000 hour players can't dominate a link.
+type playtimeWeighting struct {
+	enabled  bool
+	capHours float64
+}
+
+var weighting playtimeWeighting
+
+func (w playtimeWeighting) name() string {
+	if !w.enabled {
+		return "none"
+	}
+	return fmt.Sprintf("playtime-log-cap-%gh", w.capHours)
+}
+
+// weight returns a value in [1, 2] for a playtime given in minutes, so that
+// every review counts at least once and the hours played add up to as much
+// again. Reviews without a recorded playtime count like unplayed ones.
+func (w playtimeWeighting) weight(minutes int) float64 {
+	if !w.enabled || minutes == unknownPlaytime {
+		return 1
+	}
+	hours := math.Min(float64(minutes)/60, w.capHours)
+	return 1 + math.Log1p(hours)/math.Log1p(w.capHours)
+}
+
+// pairWeight is a reviewer's contribution to the link between two games,
+// which is only as strong as their weaker engagement with either of them.
+func (w playtimeWeighting) pairWeight(minutesA int, minutesB int) float64 {
+	return math.Min(w.weight(minutesA), w.weight(minutesB))
+}
+
+func playtimeAt(playtimes []int, i int) int {
+	if i < len(playtimes) {
+		return playtimes[i]
+	}
+	return unknownPlaytime
+}
+
+// alignPlaytimes pads the playtimes of a link created before playtime was
+// recorded, so that they stay index-aligned with GamesReviewed.
+func alignPlaytimes(link UserLinkDTO) []int {
+	playtimes := link.Playtimes
+	for len(playtimes) < len(link.GamesReviewed) {
+		playtimes = append(playtimes, unknownPlaytime)
+	}
+	return playtimes
+}
+
+func reviewedPlaytime(link UserLinkDTO, gameId int) int {
+	for i, game := range link.GamesReviewed {
+		if game == gameId {
+			return playtimeAt(link.Playtimes, i)
+		}
+	}
+	return unknownPlaytime
+}
+
+// similarityScore is the strength of a link.
+func similarityScore(s GameSimilarity) float64 {
+	return s.Score
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestPlaytimeWeight(t *testing.T) {
+	w := playtimeWeighting{enabled: true, capHours: 100}
+	tests := map[int]float64{
+		unknownPlaytime: 1,
+		0:               1,
+		100 * 60:        2,
+		1000 * 60:       2,
+	}
+	for minutes, want := range tests {
+		if got := w.weight(minutes); got != want {
+			t.Errorf("weight(%v) = %v, want %v", minutes, got, want)
+		}
+	}
+	if hour := w.weight(60); hour <= 1 || hour >= 2 {
+		t.Errorf("weight(60) = %v, want between 1 and 2", hour)
+	}
+	if got := w.pairWeight(0, 100*60); got != 1 {
+		t.Errorf("pairWeight of an unplayed and a capped review = %v, want 1", got)
+	}
+}
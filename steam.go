@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const steamRequestTimeout = 60 * time.Second
+
+var steamClient = &http.Client{Timeout: steamRequestTimeout, Transport: meteredTransport{http.DefaultTransport}}
+
+// meteredTransport records the status and latency of requests under their
+// endpoint name. It wraps the network transport, under the cache, so that
+// cached and replayed responses aren't counted as Steam requests.
+type meteredTransport struct {
+	next http.RoundTripper
+}
+
+func (t meteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := steamEndpoint(req.URL)
+
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	steamRequestSeconds.since(start, endpoint)
+
+	if err != nil {
+		steamRequestErrors.inc(endpoint)
+		return nil, err
+	}
+	steamRequests.inc(endpoint, strconv.Itoa(res.StatusCode))
+	if res.StatusCode == http.StatusTooManyRequests {
+		steamRateLimited.inc(endpoint)
+	}
+	return res, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// replaySteam answers Steam requests from testdata/steam for the test.
+func replaySteam(t *testing.T) *replayTransport {
+	t.Helper()
+
+	transport, err := newReplayTransport(filepath.Join("testdata", "steam"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, pageDelay, entryDelay := steamClient, reviewPageDelay, entryDetailsDelay
+	steamClient = &http.Client{Transport: transport}
+	reviewPageDelay, entryDetailsDelay = 0, 0
+	t.Cleanup(func() {
+		steamClient, reviewPageDelay, entryDetailsDelay = client, pageDelay, entryDelay
+	})
+	return transport
+}
+
+func servedCount(transport *replayTransport, rawUrl string) int {
+	req, _ := http.NewRequest(http.MethodGet, rawUrl, nil)
+	return transport.served[fixtureKey(http.MethodGet, req.URL)]
+}
+
+func TestGetReviewsFollowsCursorsUntilOneRepeats(t *testing.T) {
+	transport := replaySteam(t)
+
+	review, err := getReviews(440)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := GameReviewDTO{
+		AppId:     440,
+		Users:     []string{"76561198000000001", "76561198000000002", "76561198000000003"},
+		Playtimes: []int{600, 30, 6000},
+	}
+	if !reflect.DeepEqual(review, want) {
+		t.Errorf("getReviews(440) = %+v, want %+v", review, want)
+	}
+
+	for _, cursor := range []string{"*", "AoJ4+abc/1=", "AoJ9xyz"} {
+		if n := servedCount(transport, getGameUrl("440", cursor).String()); n != 1 {
+			t.Errorf("cursor %q requested %v times, want 1", cursor, n)
+		}
+	}
+}
+
+func TestGetReviewsSkipsGamesWithFewReviews(t *testing.T) {
+	transport := replaySteam(t)
+
+	review, err := getReviews(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.AppId != 10 || len(review.Users) != 0 {
+		t.Errorf("getReviews(10) = %+v, want no users", review)
+	}
+	if n := servedCount(transport, getGameUrl("10", "AoJx").String()); n != 0 {
+		t.Errorf("next page requested %v times, want 0", n)
+	}
+}
+
+func TestGetReviewsReturnsErrorWhenRateLimited(t *testing.T) {
+	replaySteam(t)
+
+	review, err := getReviews(20)
+	if err == nil {
+		t.Fatal("getReviews(20) succeeded, want an error")
+	}
+	if review.AppId != 0 || len(review.Users) != 0 {
+		t.Errorf("getReviews(20) = %+v, want an empty review", review)
+	}
+}
+
+func TestSteamRequestsReturnErrNotCachedOffline(t *testing.T) {
+	transport, err := newCachingTransport(t.TempDir(), http.DefaultTransport, defaultCacheTTLs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := steamClient
+	steamClient = &http.Client{Transport: transport}
+	t.Cleanup(func() { steamClient = client })
+
+	if _, err := getReviews(440); !errors.Is(err, errNotCached) {
+		t.Errorf("getReviews(440) offline = %v, want errNotCached", err)
+	}
+	if _, err := getStoreEntryDetails(440); !errors.Is(err, errNotCached) {
+		t.Errorf("getStoreEntryDetails(440) offline = %v, want errNotCached", err)
+	}
+}
+
+func TestGetStoreEntryDetails(t *testing.T) {
+	replaySteam(t)
+
+	tests := []struct {
+		id   int
+		want EntryDetailsData
+	}{
+		{440, EntryDetailsData{
+			Type:   "game",
+			Name:   "Team Fortress 2",
+			Genres: []EntryGenre{{"1", "Action"}, {"37", "Free to Play"}},
+		}},
+		{1234, EntryDetailsData{
+			Type:   "dlc",
+			Name:   "Soundtrack",
+			Genres: []EntryGenre{{"1", "Action"}},
+		}},
+	}
+	for _, test := range tests {
+		details, err := getStoreEntryDetails(test.id)
+		if err != nil {
+			t.Errorf("getStoreEntryDetails(%v) failed: %v", test.id, err)
+			continue
+		}
+		if !reflect.DeepEqual(details.Data, test.want) {
+			t.Errorf("getStoreEntryDetails(%v) = %+v, want %+v", test.id, details.Data, test.want)
+		}
+	}
+}
+
+func TestGetStoreEntryDetailsRecoversAfterRateLimit(t *testing.T) {
+	replaySteam(t)
+
+	if _, err := getStoreEntryDetails(10); err == nil {
+		t.Fatal("first getStoreEntryDetails(10) succeeded, want the rate limit error")
+	}
+
+	details, err := getStoreEntryDetails(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Data.Type != "game" || details.Data.Name != "Counter-Strike" {
+		t.Errorf("getStoreEntryDetails(10) = %+v, want Counter-Strike", details.Data)
+	}
+}
+
+func TestFetchStoreEntries(t *testing.T) {
+	replaySteam(t)
+
+	want := []StoreEntry{
+		{AppId: 10, Name: "Counter-Strike"},
+		{AppId: 440, Name: "Team Fortress 2"},
+		{AppId: 1234, Name: "Soundtrack"},
+	}
+	if entries := fetchStoreEntries(); !reflect.DeepEqual(entries, want) {
+		t.Errorf("fetchStoreEntries() = %+v, want %+v", entries, want)
+	}
+}
+
+func TestRecordedResponsesReplayInOrder(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, "slow down")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"page":%q}`, r.URL.Query().Get("cursor"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder, err := newRecordingTransport(dir, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(client *http.Client, query string) (int, string) {
+		t.Helper()
+		res, err := client.Get(server.URL + "/reviews?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	recording := &http.Client{Transport: recorder}
+	get(recording, "cursor=a&json=1")
+	get(recording, "cursor=a&json=1")
+	get(recording, "cursor=b&json=1")
+
+	replayer, err := newReplayTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaying := &http.Client{Transport: replayer}
+
+	want := []struct {
+		query  string
+		status int
+		body   string
+	}{
+		// Query parameters match in any order
+		{"json=1&cursor=a", http.StatusTooManyRequests, "slow down"},
+		{"cursor=a&json=1", http.StatusOK, `{"page":"a"}`},
+		// Past the recorded responses, the last one repeats
+		{"cursor=a&json=1", http.StatusOK, `{"page":"a"}`},
+		{"cursor=b&json=1", http.StatusOK, `{"page":"b"}`},
+	}
+	for _, w := range want {
+		status, body := get(replaying, w.query)
+		if status != w.status || body != w.body {
+			t.Errorf("replay of %v = %v %q, want %v %q", w.query, status, body, w.status, w.body)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("server got %v requests, want 3", requests)
+	}
+
+	if _, err := replaying.Get(server.URL + "/unknown"); err == nil {
+		t.Error("replay of an unrecorded request succeeded, want an error")
+	}
+}
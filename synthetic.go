@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A synthetic dataset has a known answer: every game belongs to a planted
+// taste cluster and most reviewers stick to their own cluster, so game-links
+// and communities can be scored against the clusters.
+
+type syntheticParams struct {
+	games    int
+	users    int
+	clusters int
+	// reviewsPerUser is the mean number of games a user reviews
+	reviewsPerUser float64
+	// affinity is the probability that a review is of a game in the
+	// reviewer's own cluster
+	affinity float64
+	// popularity is the zipf exponent of how reviews spread over the games
+	// of a cluster, 0 for no skew
+	popularity float64
+	firstAppId int
+	seed       int64
+}
+
+var defaultSyntheticParams = syntheticParams{
+	games:          500,
+	users:          20000,
+	clusters:       8,
+	reviewsPerUser: 8,
+	affinity:       0.85,
+	popularity:     1,
+	firstAppId:     1000000,
+	seed:           1,
+}
+
+type syntheticDataset struct {
+	games     []StoreEntryDTO
+	reviews   []GameReviewDTO
+	userLinks []UserLinkDTO
+	// planted is the cluster of every game
+	planted map[int]int
+}
+
+func (p syntheticParams) validate() error {
+	switch {
+	case p.clusters < 1 || p.games < p.clusters:
+		return fmt.Errorf("need at least one game per cluster")
+	case p.users < 1:
+		return fmt.Errorf("need at least one user")
+	case p.reviewsPerUser < 1:
+		return fmt.Errorf("users review at least one game")
+	case p.affinity < 0 || p.affinity > 1:
+		return fmt.Errorf("affinity must be between 0 and 1")
+	case p.popularity < 0:
+		return fmt.Errorf("popularity must not be negative")
+	}
+	return nil
+}
+
+func generateSyntheticDataset(params syntheticParams) syntheticDataset {
+	defer timeTrack(time.Now(), "generateSyntheticDataset")
+
+	rng := rand.New(rand.NewSource(params.seed))
+	dataset := syntheticDataset{planted: make(map[int]int)}
+
+	// Game i is in cluster i modulo the cluster count, and games earlier in
+	// a cluster are more popular
+	members := make([][]int, params.clusters)
+	for i := 0; i < params.games; i++ {
+		id := params.firstAppId + i
+		cluster := i % params.clusters
+		genre := fakeSteamGenres[cluster%len(fakeSteamGenres)].Description
+		dataset.games = append(dataset.games, StoreEntryDTO{
+			ID:     id,
+			Name:   fmt.Sprintf("Synthetic %v %v", genre, id),
+			Genres: []string{genre},
+		})
+		dataset.planted[id] = cluster
+		members[cluster] = append(members[cluster], id)
+	}
+
+	cumulative := make([][]float64, params.clusters)
+	for c, ids := range members {
+		total := 0.0
+		for rank := range ids {
+			total += 1 / math.Pow(float64(rank+1), params.popularity)
+			cumulative[c] = append(cumulative[c], total)
+		}
+	}
+	pick := func(cluster int) int {
+		weights := cumulative[cluster]
+		target := rng.Float64() * weights[len(weights)-1]
+		return members[cluster][sort.SearchFloat64s(weights, target)]
+	}
+
+	reviewers := make(map[int][]string)
+	playtimes := make(map[int][]int)
+	for u := 0; u < params.users; u++ {
+		userId := fmt.Sprintf("9000000%010d", u+1)
+		own := u % params.clusters
+
+		count := 1 + int(rng.ExpFloat64()*(params.reviewsPerUser-1))
+		if count > params.games {
+			count = params.games
+		}
+
+		link := UserLinkDTO{UserId: userId}
+		reviewed := make(map[int]bool)
+		for len(link.GamesReviewed) < count {
+			cluster := own
+			if params.clusters > 1 && rng.Float64() >= params.affinity {
+				cluster = (own + 1 + rng.Intn(params.clusters-1)) % params.clusters
+			}
+			// Redrawn within the cluster so duplicates don't dilute the
+			// affinity, and bounded so small clusters can't spin forever
+			gameId := pick(cluster)
+			for attempts := 0; reviewed[gameId] && attempts < 20; attempts++ {
+				gameId = pick(cluster)
+			}
+			if reviewed[gameId] {
+				break
+			}
+			reviewed[gameId] = true
+
+			playtime := 1 + int(rng.ExpFloat64()*600)
+			link.GamesReviewed = append(link.GamesReviewed, gameId)
+			link.Playtimes = append(link.Playtimes, playtime)
+			reviewers[gameId] = append(reviewers[gameId], userId)
+			playtimes[gameId] = append(playtimes[gameId], playtime)
+		}
+		dataset.userLinks = append(dataset.userLinks, link)
+	}
+
+	for _, game := range dataset.games {
+		if len(reviewers[game.ID]) == 0 {
+			continue
+		}
+		dataset.reviews = append(dataset.reviews, GameReviewDTO{
+			AppId:     game.ID,
+			Users:     reviewers[game.ID],
+			Playtimes: playtimes[game.ID],
+		})
+	}
+	return dataset
+}
+
+// writeSyntheticDataset stores the dataset the way the crawler would have,
+// from store-entries through user-links, plus the planted clusters.
+func writeSyntheticDataset(dataset syntheticDataset) {
+	defer timeTrack(time.Now(), "writeSyntheticDataset")
+
+	if database.countDocuments(storeEntriesCollection) > 0 || database.countDocuments(gamesCollectionName) > 0 {
+		fatal("database isn't empty, set DATABASE_NAME to a new database", "database", databaseName)
+	}
+
+	slog.Info("writing synthetic dataset", "stage", "synthesize", "games", len(dataset.games), "reviews", len(dataset.reviews), "users", len(dataset.userLinks))
+	for _, game := range dataset.games {
+		database.saveStoreEntry(StoreEntry{AppId: game.ID, Name: game.Name})
+		database.saveGame(game)
+	}
+	for _, review := range dataset.reviews {
+		database.saveGameReview(review)
+	}
+
+	var wg sync.WaitGroup
+	for _, link := range dataset.userLinks {
+		wg.Add(1)
+		workerStarted("synthesize")
+		go func(link UserLinkDTO) {
+			defer wg.Done()
+			defer workerDone("synthesize")
+			database.updateUserLink(link)
+		}(link)
+	}
+	wg.Wait()
+
+	database.replacePlantedCommunities(dataset.planted)
+	database.touchCollection(gamesCollectionName)
+	database.touchCollection(gameReviewsCollection)
+}
+
+type syntheticReport struct {
+	Games    int `json:"games"`
+	Clusters int `json:"clusters"`
+	// Share of each game's top K similar games in its own cluster, and the
+	// share expected from picking games at random
+	K                 int     `json:"k"`
+	PrecisionAtK      float64 `json:"precisionAtK"`
+	BaselinePrecision float64 `json:"baselinePrecision"`
+	// Agreement of detected communities with the clusters, when the
+	// communities command has run
+	Communities int     `json:"communities"`
+	NMI         float64 `json:"nmi"`
+	Purity      float64 `json:"purity"`
+}
+
+// validateSynthetic scores findSimilarGames and the stored communities
+// against the planted clusters.
+func validateSynthetic(k int) syntheticReport {
+	defer timeTrack(time.Now(), "validateSynthetic")
+
+	planted := database.findPlantedCommunities()
+	if len(planted) == 0 {
+		fatal("no planted communities, run synthesize first")
+	}
+
+	var ids []int
+	for gameId := range planted {
+		ids = append(ids, gameId)
+	}
+	sort.Ints(ids)
+
+	report := syntheticReport{Games: len(ids), K: k, BaselinePrecision: baselinePrecision(planted)}
+	clusters := make(map[int]bool)
+	for _, cluster := range planted {
+		clusters[cluster] = true
+	}
+	report.Clusters = len(clusters)
+
+	// Games nobody reviewed have no game-reviews to start from
+	reviewCounts := database.findAllReviewCounts()
+	var precisionSum float64
+	var scored int
+	for _, gameId := range ids {
+		if reviewCounts[gameId] == 0 {
+			continue
+		}
+		if precision, ok := precisionAtK(planted, gameId, findSimilarGames(gameId), k); ok {
+			precisionSum += precision
+			scored++
+		}
+	}
+	if scored > 0 {
+		report.PrecisionAtK = precisionSum / float64(scored)
+	}
+
+	var truth, detected []int
+	communities := make(map[int]bool)
+	for _, game := range database.findGamesByIds(ids) {
+		if game.Community == nil {
+			continue
+		}
+		truth = append(truth, planted[game.ID])
+		detected = append(detected, *game.Community)
+		communities[*game.Community] = true
+	}
+	if len(detected) > 0 {
+		report.Communities = len(communities)
+		report.NMI = normalizedMutualInformation(truth, detected)
+		report.Purity = purity(truth, detected)
+	}
+
+	slog.Info("validated synthetic dataset", "precisionAtK", report.PrecisionAtK, "baseline", report.BaselinePrecision, "communities", report.Communities, "nmi", report.NMI)
+	return report
+}
+
+// precisionAtK is the share of the top k similar games in the game's own
+// cluster. ok is false when the game has no similar games.
+func precisionAtK(planted map[int]int, gameId int, similarGames []GameSimilarity, k int) (float64, bool) {
+	if len(similarGames) > k {
+		similarGames = similarGames[:k]
+	}
+	if len(similarGames) == 0 {
+		return 0, false
+	}
+	hits := 0
+	for _, similarGame := range similarGames {
+		if cluster, ok := planted[similarGame.GameId]; ok && cluster == planted[gameId] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(similarGames)), true
+}
+
+// baselinePrecision is the chance that another game picked at random is in
+// the same cluster.
+func baselinePrecision(planted map[int]int) float64 {
+	sizes := make(map[int]int)
+	for _, cluster := range planted {
+		sizes[cluster]++
+	}
+	n := float64(len(planted))
+	if n < 2 {
+		return 0
+	}
+	var p float64
+	for _, size := range sizes {
+		p += float64(size) / n * float64(size-1) / (n - 1)
+	}
+	return p
+}
+
+// normalizedMutualInformation compares two labellings of the same items, 1
+// when they agree up to renaming and near 0 when they are unrelated.
+func normalizedMutualInformation(a []int, b []int) float64 {
+	n := float64(len(a))
+	countsA := make(map[int]float64)
+	countsB := make(map[int]float64)
+	joint := make(map[[2]int]float64)
+	for i := range a {
+		countsA[a[i]]++
+		countsB[b[i]]++
+		joint[[2]int{a[i], b[i]}]++
+	}
+
+	entropy := func(counts map[int]float64) float64 {
+		var h float64
+		for _, count := range counts {
+			h -= count / n * math.Log(count/n)
+		}
+		return h
+	}
+	var mutual float64
+	for pair, count := range joint {
+		mutual += count / n * math.Log(count*n/(countsA[pair[0]]*countsB[pair[1]]))
+	}
+
+	ha, hb := entropy(countsA), entropy(countsB)
+	if ha == 0 && hb == 0 {
+		return 1
+	}
+	return 2 * mutual / (ha + hb)
+}
+
+// purity is the share of items whose detected label's most common true
+// label is their own.
+func purity(truth []int, detected []int) float64 {
+	counts := make(map[int]map[int]int)
+	for i := range detected {
+		if counts[detected[i]] == nil {
+			counts[detected[i]] = make(map[int]int)
+		}
+		counts[detected[i]][truth[i]]++
+	}
+	var majority int
+	for _, labels := range counts {
+		best := 0
+		for _, count := range labels {
+			if count > best {
+				best = count
+			}
+		}
+		majority += best
+	}
+	return float64(majority) / float64(len(truth))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var testSyntheticParams = syntheticParams{
+	games:          80,
+	users:          3000,
+	clusters:       4,
+	reviewsPerUser: 6,
+	affinity:       0.85,
+	popularity:     1,
+	firstAppId:     1000,
+	seed:           3,
+}
+
+func TestSyntheticDatasetIsDeterministic(t *testing.T) {
+	first := generateSyntheticDataset(testSyntheticParams)
+	second := generateSyntheticDataset(testSyntheticParams)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("datasets differ between runs with the same seed")
+	}
+
+	params := testSyntheticParams
+	params.seed++
+	if reflect.DeepEqual(first.userLinks, generateSyntheticDataset(params).userLinks) {
+		t.Error("datasets are equal across seeds")
+	}
+}
+
+func TestSyntheticReviewsMatchUserLinks(t *testing.T) {
+	dataset := generateSyntheticDataset(testSyntheticParams)
+
+	fromLinks := make(map[int]map[string]int)
+	for _, link := range dataset.userLinks {
+		if len(link.GamesReviewed) == 0 || len(link.Playtimes) != len(link.GamesReviewed) {
+			t.Fatalf("user %v has %v games and %v playtimes", link.UserId, len(link.GamesReviewed), len(link.Playtimes))
+		}
+		for i, gameId := range link.GamesReviewed {
+			if fromLinks[gameId] == nil {
+				fromLinks[gameId] = make(map[string]int)
+			}
+			fromLinks[gameId][link.UserId] = link.Playtimes[i]
+		}
+	}
+
+	for _, review := range dataset.reviews {
+		fromReviews := make(map[string]int)
+		for i, userId := range review.Users {
+			fromReviews[userId] = review.Playtimes[i]
+		}
+		if !reflect.DeepEqual(fromReviews, fromLinks[review.AppId]) {
+			t.Errorf("reviewers of %v don't match user-links", review.AppId)
+		}
+	}
+	if len(dataset.reviews) != len(fromLinks) {
+		t.Errorf("%v games have reviews, user-links reference %v", len(dataset.reviews), len(fromLinks))
+	}
+}
+
+func TestSyntheticDatasetPlantsClusters(t *testing.T) {
+	dataset := generateSyntheticDataset(testSyntheticParams)
+
+	var own, total int
+	for i, link := range dataset.userLinks {
+		for _, gameId := range link.GamesReviewed {
+			if dataset.planted[gameId] == i%testSyntheticParams.clusters {
+				own++
+			}
+			total++
+		}
+	}
+	if share := float64(own) / float64(total); math.Abs(share-testSyntheticParams.affinity) > 0.05 {
+		t.Errorf("%.2f of reviews are in the reviewer's cluster, want about %v", share, testSyntheticParams.affinity)
+	}
+
+	// The first games of a cluster are the popular ones
+	reviews := make(map[int]int)
+	for _, review := range dataset.reviews {
+		reviews[review.AppId] = len(review.Users)
+	}
+	for cluster := 0; cluster < testSyntheticParams.clusters; cluster++ {
+		first := testSyntheticParams.firstAppId + cluster
+		last := testSyntheticParams.firstAppId + testSyntheticParams.games - testSyntheticParams.clusters + cluster
+		if reviews[first] <= 3*reviews[last] {
+			t.Errorf("cluster %v: first game has %v reviews, last %v, want a popularity skew", cluster, reviews[first], reviews[last])
+		}
+	}
+}
+
+func TestRankSimilarGamesRecoversPlantedClusters(t *testing.T) {
+	dataset := generateSyntheticDataset(testSyntheticParams)
+
+	links := make(map[string]UserLinkDTO)
+	for _, link := range dataset.userLinks {
+		links[link.UserId] = link
+	}
+
+	var sum float64
+	for _, review := range dataset.reviews {
+		var userLinks []UserLinkDTO
+		for _, userId := range review.Users {
+			userLinks = append(userLinks, links[userId])
+		}
+		precision, ok := precisionAtK(dataset.planted, review.AppId, rankSimilarGames(review.AppId, userLinks), 10)
+		if !ok {
+			t.Fatalf("game %v has no similar games", review.AppId)
+		}
+		sum += precision
+	}
+
+	precision, baseline := sum/float64(len(dataset.reviews)), baselinePrecision(dataset.planted)
+	if precision < 0.7 || precision < 2*baseline {
+		t.Errorf("precision@10 = %.2f, baseline %.2f, want most similar games in the same cluster", precision, baseline)
+	}
+}
+
+func TestLouvainRecoversPlantedClusters(t *testing.T) {
+	dataset := generateSyntheticDataset(testSyntheticParams)
+
+	links := make(map[string]UserLinkDTO)
+	for _, link := range dataset.userLinks {
+		links[link.UserId] = link
+	}
+	reviewCounts := make(map[int]int)
+	for _, review := range dataset.reviews {
+		reviewCounts[review.AppId] = len(review.Users)
+	}
+
+	// The similarity graph the communities command builds from game-links
+	graph := similarityGraph{index: make(map[int]int)}
+	for _, review := range dataset.reviews {
+		var userLinks []UserLinkDTO
+		for _, userId := range review.Users {
+			userLinks = append(userLinks, links[userId])
+		}
+		similarGames := rankSimilarGames(review.AppId, userLinks)
+		sort.Slice(similarGames, func(i, j int) bool {
+			return metricValue(similarGames[i], "cosine", reviewCounts[review.AppId], reviewCounts[similarGames[i].GameId]) >
+				metricValue(similarGames[j], "cosine", reviewCounts[review.AppId], reviewCounts[similarGames[j].GameId])
+		})
+		if len(similarGames) > defaultCommunityParams.topK {
+			similarGames = similarGames[:defaultCommunityParams.topK]
+		}
+
+		a := graph.node(review.AppId)
+		for _, similarGame := range similarGames {
+			weight := metricValue(similarGame, "cosine", reviewCounts[review.AppId], reviewCounts[similarGame.GameId])
+			graph.addEdge(a, graph.node(similarGame.GameId), weight)
+		}
+	}
+
+	membership, _ := louvain(graph.adjacency, 1, rand.New(rand.NewSource(1)))
+	var truth []int
+	for _, gameId := range graph.ids {
+		truth = append(truth, dataset.planted[gameId])
+	}
+	if nmi := normalizedMutualInformation(truth, membership); nmi < 0.8 {
+		t.Errorf("NMI of communities against planted clusters = %.2f, want at least 0.8", nmi)
+	}
+	if p := purity(truth, membership); p < 0.9 {
+		t.Errorf("purity of communities = %.2f, want at least 0.9", p)
+	}
+}
+
+func TestNormalizedMutualInformation(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []int
+		want float64
+	}{
+		{"identical", []int{0, 0, 1, 1, 2, 2}, []int{0, 0, 1, 1, 2, 2}, 1},
+		{"renamed", []int{0, 0, 1, 1, 2, 2}, []int{5, 5, 3, 3, 4, 4}, 1},
+		{"independent", []int{0, 0, 1, 1}, []int{0, 1, 0, 1}, 0},
+		{"one cluster each", []int{0, 0, 0}, []int{1, 1, 1}, 1},
+	}
+	for _, test := range tests {
+		if got := normalizedMutualInformation(test.a, test.b); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%v: NMI = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPrecisionAtK(t *testing.T) {
+	planted := map[int]int{1: 0, 2: 0, 3: 1, 4: 0}
+	similarGames := []GameSimilarity{{GameId: 2}, {GameId: 3}, {GameId: 4}, {GameId: 9}}
+
+	if p, ok := precisionAtK(planted, 1, similarGames, 2); !ok || p != 0.5 {
+		t.Errorf("precisionAtK(k=2) = %v, %v, want 0.5", p, ok)
+	}
+	if p, ok := precisionAtK(planted, 1, similarGames, 10); !ok || p != 0.5 {
+		t.Errorf("precisionAtK(k=10) = %v, %v, want 0.5", p, ok)
+	}
+	if _, ok := precisionAtK(planted, 1, nil, 10); ok {
+		t.Error("precisionAtK of no similar games is ok, want not ok")
+	}
+}
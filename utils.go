@@ -1,14 +1,14 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"time"
 )
 
 func timeTrack(start time.Time, name string) {
 	elapsed := time.Since(start)
-	log.Printf("%s took %s", name, elapsed)
+	slog.Debug("timing", "op", name, "duration", elapsed)
 }
 func check(e error) {
 	if e != nil {